@@ -1,12 +1,12 @@
 package backend
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -20,12 +20,6 @@ type AmazonDownloader struct {
 	regions []string
 }
 
-type SongLinkResponse struct {
-	LinksByPlatform map[string]struct {
-		URL string `json:"url"`
-	} `json:"linksByPlatform"`
-}
-
 type AmazonStreamResponse struct {
 	StreamURL     string `json:"streamUrl"`
 	DecryptionKey string `json:"decryptionKey"`
@@ -33,64 +27,18 @@ type AmazonStreamResponse struct {
 
 func NewAmazonDownloader() *AmazonDownloader {
 	return &AmazonDownloader{
-		client: &http.Client{
-			Timeout: 120 * time.Second,
-		},
+		client:  newProviderClient("amazon", 120*time.Second),
 		regions: []string{"us", "eu"},
 	}
 }
 
-func (a *AmazonDownloader) GetAmazonURLFromSpotify(spotifyTrackID string) (string, error) {
-
-	spotifyBase := "https://open.spotify.com/track/"
-	spotifyURL := fmt.Sprintf("%s%s", spotifyBase, spotifyTrackID)
-
-	apiBase := "https://api.song.link/v1-alpha.1/links?url="
-	apiURL := fmt.Sprintf("%s%s", apiBase, url.QueryEscape(spotifyURL))
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/144.0.0.0 Safari/537.36")
-
+func (a *AmazonDownloader) GetAmazonURLFromSpotify(ctx context.Context, spotifyTrackID string) (string, error) {
 	fmt.Println("Getting Amazon URL...")
 
-	resp, err := a.client.Do(req)
+	amazonURL, err := GetSongLinkClient().PlatformURL(ctx, spotifyTrackID, "amazonMusic")
 	if err != nil {
 		return "", fmt.Errorf("failed to get Amazon URL: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("API returned status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	if len(body) == 0 {
-		return "", fmt.Errorf("API returned empty response")
-	}
-
-	var songLinkResp SongLinkResponse
-	if err := json.Unmarshal(body, &songLinkResp); err != nil {
-
-		bodyStr := string(body)
-		if len(bodyStr) > 200 {
-			bodyStr = bodyStr[:200] + "..."
-		}
-		return "", fmt.Errorf("failed to decode response: %w (response: %s)", err, bodyStr)
-	}
-
-	amazonLink, ok := songLinkResp.LinksByPlatform["amazonMusic"]
-	if !ok || amazonLink.URL == "" {
-		return "", fmt.Errorf("amazon Music link not found")
-	}
-
-	amazonURL := amazonLink.URL
 
 	if strings.Contains(amazonURL, "trackAsin=") {
 		parts := strings.Split(amazonURL, "trackAsin=")
@@ -105,7 +53,7 @@ func (a *AmazonDownloader) GetAmazonURLFromSpotify(spotifyTrackID string) (strin
 	return amazonURL, nil
 }
 
-func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality string) (string, error) {
+func (a *AmazonDownloader) DownloadFromAfkarXYZ(ctx context.Context, amazonURL, outputDir, quality string) (string, error) {
 
 	asinRegex := regexp.MustCompile(`(B[0-9A-Z]{9})`)
 	asin := asinRegex.FindString(amazonURL)
@@ -114,7 +62,7 @@ func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality st
 	}
 
 	apiURL := fmt.Sprintf("https://amazon.afkarxyz.fun/api/track/%s", asin)
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", err
 	}
@@ -149,39 +97,18 @@ func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality st
 	fileName := fmt.Sprintf("%s.m4a", asin)
 	filePath := filepath.Join(outputDir, fileName)
 
-	out, err := os.Create(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer out.Close()
-
-	dlReq, _ := http.NewRequest("GET", downloadURL, nil)
-	dlReq.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/144.0.0.0 Safari/537.36")
-
-	dlResp, err := a.client.Do(dlReq)
-	if err != nil {
-		return "", err
-	}
-	defer dlResp.Body.Close()
-
 	fmt.Printf("Downloading track: %s\n", fileName)
-	pw := NewProgressWriter(out)
-	_, err = io.Copy(pw, dlResp.Body)
-	if err != nil {
-		out.Close()
-		os.Remove(filePath)
+	if _, err := downloadToFile(ctx, newTransferClient(), downloadURL, filePath); err != nil {
 		return "", err
 	}
 
-	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
-
 	if apiResp.DecryptionKey != "" {
 		fmt.Printf("Decrypting file...\n")
 
 		ffprobePath, err := GetFFprobePath()
 		var codec string
 		if err == nil {
-			cmdProbe := exec.Command(ffprobePath,
+			cmdProbe := exec.CommandContext(ctx, ffprobePath,
 				"-v", "quiet",
 				"-select_streams", "a:0",
 				"-show_entries", "stream=codec_name",
@@ -218,7 +145,7 @@ func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality st
 
 		key := strings.TrimSpace(apiResp.DecryptionKey)
 
-		cmd := exec.Command(ffmpegPath,
+		cmd := exec.CommandContext(ctx, ffmpegPath,
 			"-decryption_key", key,
 			"-i", filePath,
 			"-c", "copy",
@@ -229,6 +156,11 @@ func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality st
 		setHideWindow(cmd)
 		output, err := cmd.CombinedOutput()
 		if err != nil {
+			if ctx.Err() != nil {
+				os.Remove(filePath)
+				os.Remove(decryptedPath)
+				return "", ctx.Err()
+			}
 
 			outStr := string(output)
 			if len(outStr) > 500 {
@@ -257,131 +189,112 @@ func (a *AmazonDownloader) DownloadFromAfkarXYZ(amazonURL, outputDir, quality st
 	return filePath, nil
 }
 
-func (a *AmazonDownloader) DownloadFromService(amazonURL, outputDir, quality string) (string, error) {
-	return a.DownloadFromAfkarXYZ(amazonURL, outputDir, quality)
+func (a *AmazonDownloader) DownloadFromService(ctx context.Context, amazonURL, outputDir, quality string) (string, error) {
+	return a.DownloadFromAfkarXYZ(ctx, amazonURL, outputDir, quality)
+}
+
+func (a *AmazonDownloader) Name() string {
+	return "amazon"
+}
+
+func (a *AmazonDownloader) Qualities() []string {
+	return []string{"original"}
 }
 
-func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filenameFormat, playlistName, playlistOwner string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, spotifyCoverURL string, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, embedMaxQualityCover bool, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, useFirstArtistOnly bool) (string, error) {
+func (a *AmazonDownloader) Resolve(ctx context.Context, req TrackRequest) (*ResolvedTrack, error) {
+	amazonURL, err := a.GetAmazonURLFromSpotify(ctx, req.SpotifyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResolvedTrack{
+		Provider: a.Name(),
+		ID:       regexp.MustCompile(`(B[0-9A-Z]{9})`).FindString(amazonURL),
+		URL:      amazonURL,
+	}, nil
+}
+
+func (a *AmazonDownloader) Fetch(ctx context.Context, track *ResolvedTrack, req TrackRequest) (*DownloadResult, error) {
+	result, err := a.DownloadByURL(ctx, track.URL, req)
+	if err != nil || result.AlreadyExists {
+		return result, err
+	}
 
+	result.Match, err = amazonMatch(ctx, req, track.ID, result.FilePath)
+	if err != nil {
+		os.Remove(result.FilePath)
+		return nil, err
+	}
+	return result, nil
+}
+
+// amazonMatch checks a downloaded file against the request. Amazon returns no
+// track metadata, so only the duration of the file can be compared; without
+// it the match is recorded as unverified.
+func amazonMatch(ctx context.Context, req TrackRequest, id, path string) (*TrackMatch, error) {
+	candidate := MatchCandidate{ID: id}
+	if format, err := probeAudioFormat(ctx, path); err == nil {
+		candidate.DurationMS = int(format.totalSamples * 1000 / int64(format.sampleRate))
+	}
+	if req.Duration == 0 || candidate.DurationMS == 0 {
+		return &TrackMatch{
+			Provider:   "amazon",
+			ID:         id,
+			Unverified: true,
+			Reasons:    []string{"no duration to compare"},
+		}, nil
+	}
+
+	_, match, err := BestMatch("amazon", TrackRequest{TrackName: req.TrackName, Duration: req.Duration}, []MatchCandidate{candidate})
+	if match != nil {
+		match.Reasons = append(match.Reasons, "only the duration was compared")
+	}
+	return match, err
+}
+
+func (a *AmazonDownloader) DownloadByURL(ctx context.Context, amazonURL string, req TrackRequest) (*DownloadResult, error) {
+	outputDir := req.OutputDir
 	if outputDir != "." {
 		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return "", fmt.Errorf("failed to create output directory: %w", err)
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
 		}
 	}
 
-	if spotifyTrackName != "" && spotifyArtistName != "" {
-		filenameArtist := spotifyArtistName
-		filenameAlbumArtist := spotifyAlbumArtist
-		if useFirstArtistOnly {
-			filenameArtist = GetFirstArtist(spotifyArtistName)
-			filenameAlbumArtist = GetFirstArtist(spotifyAlbumArtist)
-		}
-		expectedFilename := BuildExpectedFilename(spotifyTrackName, filenameArtist, spotifyAlbumName, filenameAlbumArtist, spotifyReleaseDate, filenameFormat, playlistName, playlistOwner, includeTrackNumber, position, spotifyDiscNumber, false, "flac")
+	if req.TrackName != "" && req.ArtistName != "" {
+		expectedFilename := BuildFilename(req.FilenameFormat, filenameDataForRequest(req), req.IncludeTrackNumber, ".flac")
 		expectedPath := filepath.Join(outputDir, expectedFilename)
 
 		if fileInfo, err := os.Stat(expectedPath); err == nil && fileInfo.Size() > 0 {
 			fmt.Printf("File already exists: %s (%.2f MB)\n", expectedPath, float64(fileInfo.Size())/(1024*1024))
-			return "EXISTS:" + expectedPath, nil
+			return &DownloadResult{FilePath: expectedPath, AlreadyExists: true, Provider: a.Name()}, nil
 		}
 	}
 
-	isrcChan := make(chan string, 1)
-	if spotifyURL != "" {
-		go func() {
-			var isrc string
-			parts := strings.Split(spotifyURL, "/")
-			if len(parts) > 0 {
-				sID := strings.Split(parts[len(parts)-1], "?")[0]
-				if sID != "" {
-					client := NewSongLinkClient()
-					if val, err := client.GetISRC(sID); err == nil {
-						isrc = val
-					}
-				}
-			}
-			isrcChan <- isrc
-		}()
-	} else {
-		close(isrcChan)
-	}
+	isrcChan := lookupISRCAsync(ctx, req.SpotifyURL)
 
 	fmt.Printf("Using Amazon URL: %s\n", amazonURL)
 
-	filePath, err := a.DownloadFromService(amazonURL, outputDir, quality)
+	filePath, err := a.DownloadFromService(ctx, amazonURL, outputDir, req.Quality)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var isrc string
-	if spotifyURL != "" {
-		isrc = <-isrcChan
-	}
+	isrc := <-isrcChan
 
 	originalFileDir := filepath.Dir(filePath)
 	originalFileBase := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
 
-	if spotifyTrackName != "" && spotifyArtistName != "" {
-		safeArtist := sanitizeFilename(spotifyArtistName)
-		safeAlbumArtist := sanitizeFilename(spotifyAlbumArtist)
-
-		if useFirstArtistOnly {
-			safeArtist = sanitizeFilename(GetFirstArtist(spotifyArtistName))
-			safeAlbumArtist = sanitizeFilename(GetFirstArtist(spotifyAlbumArtist))
-		}
-
-		safeTitle := sanitizeFilename(spotifyTrackName)
-		safeAlbum := sanitizeFilename(spotifyAlbumName)
-
-		year := ""
-		if len(spotifyReleaseDate) >= 4 {
-			year = spotifyReleaseDate[:4]
-		}
-
-		var newFilename string
-
-		if strings.Contains(filenameFormat, "{") {
-			newFilename = filenameFormat
-			newFilename = strings.ReplaceAll(newFilename, "{title}", safeTitle)
-			newFilename = strings.ReplaceAll(newFilename, "{artist}", safeArtist)
-			newFilename = strings.ReplaceAll(newFilename, "{album}", safeAlbum)
-			newFilename = strings.ReplaceAll(newFilename, "{album_artist}", safeAlbumArtist)
-			newFilename = strings.ReplaceAll(newFilename, "{year}", year)
-
-			if spotifyDiscNumber > 0 {
-				newFilename = strings.ReplaceAll(newFilename, "{disc}", fmt.Sprintf("%d", spotifyDiscNumber))
-			} else {
-				newFilename = strings.ReplaceAll(newFilename, "{disc}", "")
-			}
-
-			if position > 0 {
-				newFilename = strings.ReplaceAll(newFilename, "{track}", fmt.Sprintf("%02d", position))
-			} else {
-
-				newFilename = regexp.MustCompile(`\{track\}\.\s*`).ReplaceAllString(newFilename, "")
-				newFilename = regexp.MustCompile(`\{track\}\s*-\s*`).ReplaceAllString(newFilename, "")
-				newFilename = regexp.MustCompile(`\{track\}\s*`).ReplaceAllString(newFilename, "")
-			}
-		} else {
-
-			switch filenameFormat {
-			case "artist-title":
-				newFilename = fmt.Sprintf("%s - %s", safeArtist, safeTitle)
-			case "title":
-				newFilename = safeTitle
-			default:
-				newFilename = fmt.Sprintf("%s - %s", safeTitle, safeArtist)
-			}
-
-			if includeTrackNumber && position > 0 {
-				newFilename = fmt.Sprintf("%02d. %s", position, newFilename)
-			}
+	if req.TrackName != "" && req.ArtistName != "" {
+		filenameData := filenameDataForRequest(req)
+		if filenameData.ISRC == "" {
+			filenameData.ISRC = isrc
 		}
 
 		ext := filepath.Ext(filePath)
 		if ext == "" {
 			ext = ".flac"
 		}
-		newFilename = newFilename + ext
+		newFilename := BuildFilename(req.FilenameFormat, filenameData, req.IncludeTrackNumber, ext)
 		newFilePath := filepath.Join(outputDir, newFilename)
 
 		if err := os.Rename(filePath, newFilePath); err != nil {
@@ -396,10 +309,10 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 
 	coverPath := ""
 
-	if spotifyCoverURL != "" {
+	if req.CoverURL != "" {
 		coverPath = filePath + ".cover.jpg"
 		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
+		if err := coverClient.DownloadCoverToPath(req.CoverURL, coverPath, req.EmbedMaxQualityCover); err != nil {
 			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
 			coverPath = ""
 		} else {
@@ -408,29 +321,7 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 		}
 	}
 
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
-	}
-
-	metadata := Metadata{
-		Title:       spotifyTrackName,
-		Artist:      spotifyArtistName,
-		Album:       spotifyAlbumName,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
-		ISRC:        isrc,
-	}
-
-	if err := EmbedMetadataToConvertedFile(filePath, metadata, coverPath); err != nil {
+	if err := EmbedMetadataToConvertedFile(ctx, filePath, metadataFromRequest(req, isrc), coverPath); err != nil {
 		fmt.Printf("Warning: Failed to embed metadata: %v\n", err)
 	} else {
 		fmt.Println("Metadata embedded successfully")
@@ -449,18 +340,11 @@ func (a *AmazonDownloader) DownloadByURL(amazonURL, outputDir, quality, filename
 	}
 
 	fmt.Println("Done")
-	fmt.Println("✓ Downloaded successfully from Amazon Music")
-	return filePath, nil
-}
-
-func (a *AmazonDownloader) DownloadBySpotifyID(spotifyTrackID, outputDir, quality, filenameFormat, playlistName, playlistOwner string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, spotifyCoverURL string, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, embedMaxQualityCover bool, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string,
-	useFirstArtistOnly bool,
-) (string, error) {
-
-	amazonURL, err := a.GetAmazonURLFromSpotify(spotifyTrackID)
-	if err != nil {
-		return "", err
+	quality := req.Quality
+	if quality == "" {
+		quality = "original"
 	}
 
-	return a.DownloadByURL(amazonURL, outputDir, quality, filenameFormat, playlistName, playlistOwner, includeTrackNumber, position, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, spotifyCoverURL, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks, embedMaxQualityCover, spotifyTotalDiscs, spotifyCopyright, spotifyPublisher, spotifyURL, useFirstArtistOnly)
+	fmt.Println("✓ Downloaded successfully from Amazon Music")
+	return &DownloadResult{FilePath: filePath, Provider: a.Name(), Quality: quality}, nil
 }
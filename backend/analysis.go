@@ -1,30 +1,38 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/bits"
 	"os"
+	pathfilepath "path/filepath"
+	"strings"
 
 	"github.com/go-flac/go-flac"
-	mewflac "github.com/mewkiz/flac"
 )
 
 type AnalysisResult struct {
-	FilePath      string        `json:"file_path"`
-	FileSize      int64         `json:"file_size"`
-	SampleRate    uint32        `json:"sample_rate"`
-	Channels      uint8         `json:"channels"`
-	BitsPerSample uint8         `json:"bits_per_sample"`
-	TotalSamples  uint64        `json:"total_samples"`
-	Duration      float64       `json:"duration"`
-	BitDepth      string        `json:"bit_depth"`
-	DynamicRange  float64       `json:"dynamic_range"`
-	PeakAmplitude float64       `json:"peak_amplitude"`
-	RMSLevel      float64       `json:"rms_level"`
-	Spectrum      *SpectrumData `json:"spectrum,omitempty"`
+	FilePath      string          `json:"file_path"`
+	FileSize      int64           `json:"file_size"`
+	Codec         string          `json:"codec"`
+	SampleRate    uint32          `json:"sample_rate"`
+	Channels      uint8           `json:"channels"`
+	BitsPerSample uint8           `json:"bits_per_sample"`
+	TotalSamples  uint64          `json:"total_samples"`
+	Duration      float64         `json:"duration"`
+	BitDepth      string          `json:"bit_depth"`
+	DynamicRange  float64         `json:"dynamic_range"`
+	PeakAmplitude float64         `json:"peak_amplitude"`
+	RMSLevel      float64         `json:"rms_level"`
+	Spectrum      *SpectrumData   `json:"spectrum,omitempty"`
+	Verdict       *QualityVerdict `json:"verdict,omitempty"`
+	Loudness      *Loudness       `json:"loudness,omitempty"`
 }
 
-func AnalyzeTrack(filepath string) (*AnalysisResult, error) {
+func AnalyzeTrack(ctx context.Context, filepath string) (*AnalysisResult, error) {
 	if !fileExists(filepath) {
 		return nil, fmt.Errorf("file does not exist: %s", filepath)
 	}
@@ -34,125 +42,187 @@ func AnalyzeTrack(filepath string) (*AnalysisResult, error) {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	f, err := flac.ParseFile(filepath)
+	decoder, err := openAudio(ctx, filepath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse FLAC file: %w", err)
+		return nil, fmt.Errorf("failed to open audio file: %w", err)
 	}
+	defer decoder.Close()
+	format := decoder.Format()
 
 	result := &AnalysisResult{
-		FilePath: filepath,
-		FileSize: fileInfo.Size(),
+		FilePath:      filepath,
+		FileSize:      fileInfo.Size(),
+		Codec:         format.codec,
+		SampleRate:    uint32(format.sampleRate),
+		Channels:      uint8(format.channels),
+		BitsPerSample: uint8(format.bitsPerSample),
+		TotalSamples:  uint64(format.totalSamples),
 	}
 
-	if len(f.Meta) > 0 {
-		streamInfo := f.Meta[0]
-		if streamInfo.Type == flac.StreamInfo {
-
-			data := streamInfo.Data
-			if len(data) >= 18 {
-
-				result.SampleRate = uint32(data[10])<<12 | uint32(data[11])<<4 | uint32(data[12])>>4
-
-				result.Channels = ((data[12] >> 1) & 0x07) + 1
-
-				result.BitsPerSample = ((data[12]&0x01)<<4 | data[13]>>4) + 1
-
-				result.TotalSamples = uint64(data[13]&0x0F)<<32 |
-					uint64(data[14])<<24 |
-					uint64(data[15])<<16 |
-					uint64(data[16])<<8 |
-					uint64(data[17])
-
-				if result.SampleRate > 0 {
-					result.Duration = float64(result.TotalSamples) / float64(result.SampleRate)
-				}
-
-			}
-		}
-	}
-
-	spectrum, err := AnalyzeSpectrum(filepath)
+	analysis, err := analyzeDecoder(decoder, analysisOptions{spectrum: true, loudness: true})
 	if err != nil {
 
-		fmt.Printf("Warning: failed to analyze spectrum: %v\n", err)
+		fmt.Printf("Warning: failed to analyze audio: %v\n", err)
 	} else {
-		result.Spectrum = spectrum
+		result.TotalSamples = uint64(analysis.samples)
+		result.Spectrum = analysis.spectrum
+		// Only lossless sources can be fake lossless
+		if format.lossless() {
+			result.Verdict = judgeQuality(analysis.spectrum, analysis.stats)
+		}
+		result.Loudness = analysis.loudness
 
-		calculateRealAudioMetrics(result, filepath)
+		result.PeakAmplitude = linearToDB(analysis.peak)
+		result.RMSLevel = linearToDB(analysis.rms)
+		result.DynamicRange = result.PeakAmplitude - result.RMSLevel
 	}
 
-	result.BitDepth = fmt.Sprintf("%d-bit", result.BitsPerSample)
-
-	return result, nil
-}
-
-func calculateRealAudioMetrics(result *AnalysisResult, filepath string) {
-
-	samples, err := decodeFLACForMetrics(filepath)
-	if err != nil {
-		return
+	if result.SampleRate > 0 {
+		result.Duration = float64(result.TotalSamples) / float64(result.SampleRate)
 	}
 
-	var peak float64
-	var sumSquares float64
-
-	for _, sample := range samples {
-		absVal := sample
-		if absVal < 0 {
-			absVal = -absVal
-		}
-		if absVal > peak {
-			peak = absVal
-		}
-		sumSquares += sample * sample
+	result.BitDepth = fmt.Sprintf("%d-bit", result.BitsPerSample)
+	if !format.lossless() {
+		result.BitDepth = "lossy"
 	}
 
-	peakDB := 20.0 * math.Log10(peak)
-	result.PeakAmplitude = peakDB
+	return result, nil
+}
 
-	rms := math.Sqrt(sumSquares / float64(len(samples)))
-	rmsDB := 20.0 * math.Log10(rms)
-	result.RMSLevel = rmsDB
+// analysisOptions selects the optional measurements of analyzeAudio.
+// Without slices the spectrum has spectrumSlices slices, and without channel
+// it is taken of the mono mix. reduce, if set, shrinks the magnitudes of each
+// slice as soon as it is computed, e.g. to the rows of an image.
+type analysisOptions struct {
+	spectrum bool
+	slices   int
+	channel  string
+	reduce   func(magnitudes []float64) []float64
+	loudness bool
+}
 
-	result.DynamicRange = peakDB - rmsDB
+// audioAnalysis is what analyzeAudio measures. peak and rms are linear and
+// cover all channels; samples is the decoded length per channel.
+type audioAnalysis struct {
+	samples  int64
+	spectrum *SpectrumData
+	stats    sampleStats
+	peak     float64
+	rms      float64
+	loudness *Loudness
 }
 
-func decodeFLACForMetrics(filepath string) ([]float64, error) {
-	stream, err := mewflac.ParseFile(filepath)
+// analyzeAudio decodes a file and measures it in a single pass.
+func analyzeAudio(ctx context.Context, filepath string, opts analysisOptions) (*audioAnalysis, error) {
+	decoder, err := openAudio(ctx, filepath)
 	if err != nil {
 		return nil, err
 	}
-	defer stream.Close()
+	defer decoder.Close()
+	return analyzeDecoder(decoder, opts)
+}
 
-	maxSamples := 10000000
-	samples := make([]float64, 0, maxSamples)
+// analyzeDecoder measures a stream block by block. Only the current block and
+// spectrum slice are held in memory, plus a value per 100 ms for loudness
+// gating.
+func analyzeDecoder(decoder audioDecoder, opts analysisOptions) (*audioAnalysis, error) {
+	format := decoder.Format()
+	channels := format.channels
+	scale := 1 / float64(int64(1)<<(format.sampleBits-1))
+
+	var spectrum *spectrumBuilder
+	if opts.spectrum {
+		slices := opts.slices
+		if slices <= 0 {
+			slices = spectrumSlices
+		}
+		spectrum = newSpectrumBuilder(format.sampleRate, format.totalSamples, slices)
+		spectrum.reduce = opts.reduce
+	}
+	var meter *loudnessMeter
+	if opts.loudness {
+		meter = newLoudnessMeter(format.sampleRate, channels)
+	}
 
+	var peak, sumSquares float64
+	var count int64
+	var mask int32
+	buf := make([][]float64, channels)
 	for {
-		frame, err := stream.ParseNext()
-		if err != nil {
+		block, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
 			break
 		}
-
-		var channelSamples []int32
-		if len(frame.Subframes) > 0 {
-			channelSamples = frame.Subframes[0].Samples
+		if err != nil {
+			return nil, err
 		}
 
-		maxVal := float64(int64(1) << (stream.Info.BitsPerSample - 1))
-		for _, sample := range channelSamples {
-			if len(samples) >= maxSamples {
-				return samples, nil
+		n := len(block[0])
+		for ch := range buf {
+			buf[ch] = buf[ch][:0]
+			for _, sample := range block[ch] {
+				mask |= sample
+				x := float64(sample) * scale
+				peak = max(peak, math.Abs(x))
+				sumSquares += x * x
+				buf[ch] = append(buf[ch], x)
 			}
-			normalized := float64(sample) / maxVal
-			samples = append(samples, normalized)
 		}
+		count += int64(n)
 
-		if len(samples) >= maxSamples {
-			break
+		if spectrum != nil {
+			// The spectrum is taken of unscaled samples
+			for i := 0; i < n; i++ {
+				spectrum.add(channelSample(buf, i, opts.channel) / scale)
+			}
 		}
+		if meter != nil {
+			meter.add(buf)
+		}
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("no audio samples found")
+	}
+
+	analysis := &audioAnalysis{
+		samples: count,
+		stats:   sampleStats{bitsPerSample: format.bitsPerSample},
+		peak:    peak,
+		rms:     math.Sqrt(sumSquares / float64(count*int64(channels))),
+	}
+	if mask != 0 && format.lossless() {
+		analysis.stats.usedBits = format.sampleBits - bits.TrailingZeros32(uint32(mask))
+	}
+	if spectrum != nil {
+		analysis.spectrum = spectrum.result()
 	}
+	if meter != nil {
+		analysis.loudness = meter.result()
+	}
+	return analysis, nil
+}
 
-	return samples, nil
+// channelSample returns sample i of a channel of a block: "left", "right",
+// "side" (half the difference of left and right) or, by default, the mono
+// mix, which is the mid channel of a stereo file.
+func channelSample(buf [][]float64, i int, channel string) float64 {
+	switch channel {
+	case SpectrogramLeft:
+		return buf[0][i]
+	case SpectrogramRight:
+		return buf[min(1, len(buf)-1)][i]
+	case SpectrogramSide:
+		if len(buf) < 2 {
+			return 0
+		}
+		return (buf[0][i] - buf[1][i]) / 2
+	}
+	var sample float64
+	for ch := range buf {
+		sample += buf[ch][i]
+	}
+	return sample / float64(len(buf))
 }
 
 func GetFileSize(filepath string) (int64, error) {
@@ -163,7 +233,9 @@ func GetFileSize(filepath string) (int64, error) {
 	return info.Size(), nil
 }
 
-func GetTrackMetadata(filepath string) (*AnalysisResult, error) {
+// GetTrackMetadata reads the format of a file without decoding it: FLAC from
+// its STREAMINFO block, every other format through ffprobe.
+func GetTrackMetadata(ctx context.Context, filepath string) (*AnalysisResult, error) {
 	if !fileExists(filepath) {
 		return nil, fmt.Errorf("file does not exist: %s", filepath)
 	}
@@ -173,16 +245,35 @@ func GetTrackMetadata(filepath string) (*AnalysisResult, error) {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	f, err := flac.ParseFile(filepath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse FLAC file: %w", err)
-	}
-
 	result := &AnalysisResult{
 		FilePath: filepath,
 		FileSize: fileInfo.Size(),
 	}
 
+	if !strings.EqualFold(pathfilepath.Ext(filepath), ".flac") {
+		format, err := probeAudioFormat(ctx, filepath)
+		if err != nil {
+			return nil, err
+		}
+		result.Codec = format.codec
+		result.SampleRate = uint32(format.sampleRate)
+		result.Channels = uint8(format.channels)
+		result.BitsPerSample = uint8(format.bitsPerSample)
+		result.TotalSamples = uint64(format.totalSamples)
+		result.Duration = float64(format.totalSamples) / float64(format.sampleRate)
+		result.BitDepth = fmt.Sprintf("%d-bit", result.BitsPerSample)
+		if !format.lossless() {
+			result.BitDepth = "lossy"
+		}
+		return result, nil
+	}
+
+	f, err := flac.ParseFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+	result.Codec = "flac"
+
 	if len(f.Meta) > 0 {
 		streamInfo := f.Meta[0]
 		if streamInfo.Type == flac.StreamInfo {
@@ -0,0 +1,381 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Quality tiers reported by availability checks, from best to worst. A
+// service whose quality could not be determined has an empty tier.
+const (
+	QualityHiRes    = "hi_res"
+	QualityLossless = "lossless"
+)
+
+// durationTolerance is how far a service's duration may differ from
+// Spotify's before it is reported. Providers often round to the second.
+const durationTolerance = 3 * time.Second
+
+// availabilityServices lists the checked services in the order preferred
+// when two offer the same quality.
+var availabilityServices = []string{"tidal", "qobuz", "amazon"}
+
+// ServiceQuality is the best version of a track that one service offers.
+// SampleRate is in Hz. Explicit is nil when the service does not say.
+type ServiceQuality struct {
+	Available  bool     `json:"available"`
+	URL        string   `json:"url,omitempty"`
+	Tier       string   `json:"tier,omitempty"`
+	BitDepth   int      `json:"bit_depth,omitempty"`
+	SampleRate int      `json:"sample_rate,omitempty"`
+	DurationMS int      `json:"duration_ms,omitempty"`
+	Explicit   *bool    `json:"explicit,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+type TrackAvailability struct {
+	SpotifyID string                     `json:"spotify_id"`
+	Tidal     bool                       `json:"tidal"`
+	Amazon    bool                       `json:"amazon"`
+	Qobuz     bool                       `json:"qobuz"`
+	TidalURL  string                     `json:"tidal_url,omitempty"`
+	AmazonURL string                     `json:"amazon_url,omitempty"`
+	QobuzURL  string                     `json:"qobuz_url,omitempty"`
+	ISRC      string                     `json:"isrc,omitempty"`
+	Services  map[string]*ServiceQuality `json:"services,omitempty"`
+	Best      string                     `json:"best,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+}
+
+// AvailabilityCheck identifies a track to check. DurationMS and Explicit
+// are the Spotify values the services are compared against; they are
+// optional.
+type AvailabilityCheck struct {
+	SpotifyID  string `json:"spotify_id"`
+	DurationMS int    `json:"duration_ms,omitempty"`
+	Explicit   *bool  `json:"explicit,omitempty"`
+}
+
+// ServiceSummary counts how many tracks of a batch a service offers.
+type ServiceSummary struct {
+	Available int `json:"available"`
+	HiRes     int `json:"hi_res"`
+	Lossless  int `json:"lossless"`
+	Warnings  int `json:"warnings"`
+}
+
+func (s *SongLinkClient) CheckTrackAvailability(spotifyTrackID string) (*TrackAvailability, error) {
+	return s.CheckTrackQuality(context.Background(), AvailabilityCheck{SpotifyID: spotifyTrackID})
+}
+
+// CheckTrackQuality reports which services offer a track and the best
+// quality each of them has. Service lookups that succeed are cached with the
+// song.link response; failed ones are retried on the next check.
+func (s *SongLinkClient) CheckTrackQuality(ctx context.Context, check AvailabilityCheck) (*TrackAvailability, error) {
+	fmt.Printf("Checking availability for track: %s\n", check.SpotifyID)
+
+	entry, err := s.lookup(ctx, check.SpotifyID, "")
+	if err != nil {
+		return nil, err
+	}
+	if entry.Services == nil {
+		entry.Services = map[string]*ServiceQuality{}
+	}
+
+	found := map[string]*ServiceQuality{}
+	for _, service := range availabilityServices {
+		if entry.Services[service] != nil {
+			continue
+		}
+		q, err := s.serviceQuality(ctx, check.SpotifyID, entry, service)
+		if err != nil {
+			fmt.Printf("⚠ %s quality check failed: %v\n", service, err)
+			continue
+		}
+		entry.Services[service] = q
+		found[service] = q
+	}
+	if len(found) > 0 {
+		s.update(check.SpotifyID, "", func(cached *songLinkEntry) {
+			if cached.Services == nil {
+				cached.Services = map[string]*ServiceQuality{}
+			}
+			for service, q := range found {
+				cached.Services[service] = q
+			}
+		})
+	}
+
+	availability := &TrackAvailability{
+		SpotifyID: check.SpotifyID,
+		ISRC:      entry.ISRC,
+		Services:  map[string]*ServiceQuality{},
+	}
+	for service, cached := range entry.Services {
+		q := *cached
+		q.Warnings = compareWithSpotify(q, check)
+		availability.Services[service] = &q
+	}
+	// A service whose quality lookup failed is still reported from its link
+	for service, platform := range map[string]string{"tidal": "tidal", "amazon": "amazonMusic"} {
+		if availability.Services[service] == nil && entry.Links[platform] != "" {
+			availability.Services[service] = &ServiceQuality{Available: true, URL: entry.Links[platform]}
+		}
+	}
+
+	if q := availability.Services["tidal"]; q != nil && q.Available {
+		availability.Tidal, availability.TidalURL = true, q.URL
+	}
+	if q := availability.Services["qobuz"]; q != nil && q.Available {
+		availability.Qobuz, availability.QobuzURL = true, q.URL
+	}
+	if q := availability.Services["amazon"]; q != nil && q.Available {
+		availability.Amazon, availability.AmazonURL = true, q.URL
+	}
+	availability.Best = bestService(availability.Services)
+
+	return availability, nil
+}
+
+// serviceQuality looks up the best quality a service offers for a track.
+func (s *SongLinkClient) serviceQuality(ctx context.Context, spotifyTrackID string, entry *songLinkEntry, service string) (*ServiceQuality, error) {
+	switch service {
+	case "tidal":
+		tidalURL := entry.Links["tidal"]
+		if tidalURL == "" {
+			return &ServiceQuality{}, nil
+		}
+		return tidalQuality(ctx, tidalURL)
+	case "qobuz":
+		if entry.Links["deezer"] == "" && entry.ISRC == "" {
+			// Without an ISRC Qobuz cannot be searched
+			return &ServiceQuality{}, nil
+		}
+		isrc, err := s.isrcForEntry(ctx, spotifyTrackID, "", entry)
+		if err != nil {
+			return nil, err
+		}
+		return qobuzQuality(ctx, isrc)
+	case "amazon":
+		amazonURL := entry.Links["amazonMusic"]
+		if amazonURL == "" {
+			return &ServiceQuality{}, nil
+		}
+		// Amazon does not expose the quality of a track without streaming it
+		return &ServiceQuality{Available: true, URL: amazonURL}, nil
+	}
+	return nil, fmt.Errorf("unknown service: %s", service)
+}
+
+func qobuzQuality(ctx context.Context, isrc string) (*ServiceQuality, error) {
+	track, err := NewQobuzDownloader().searchByISRC(ctx, isrc)
+	if errors.Is(err, errTrackNotFound) {
+		return &ServiceQuality{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	explicit := track.ParentalWarning
+	q := &ServiceQuality{
+		Available:  true,
+		URL:        fmt.Sprintf("https://open.qobuz.com/track/%d", track.ID),
+		BitDepth:   track.MaximumBitDepth,
+		SampleRate: int(track.MaximumSamplingRate*1000 + 0.5),
+		DurationMS: track.Duration * 1000,
+		Explicit:   &explicit,
+	}
+	q.Tier = qualityTier(q.BitDepth, q.SampleRate)
+	return q, nil
+}
+
+func tidalQuality(ctx context.Context, tidalURL string) (*ServiceQuality, error) {
+	t := NewTidalDownloader("")
+	trackID, err := t.GetTrackIDFromURL(tidalURL)
+	if err != nil {
+		return nil, err
+	}
+	apis, _ := t.GetAvailableAPIs()
+
+	q := &ServiceQuality{Available: true, URL: tidalURL}
+
+	// Requesting the best quality returns the format Tidal actually serves
+	var stream TidalAPIResponseV2
+	streamErr := tidalAPIGet(ctx, apis, fmt.Sprintf("/track/?id=%d&quality=HI_RES_LOSSLESS", trackID), &stream)
+	if streamErr == nil {
+		q.BitDepth = stream.Data.BitDepth
+		q.SampleRate = stream.Data.SampleRate
+		q.Tier = qualityTier(q.BitDepth, q.SampleRate)
+	}
+
+	info, infoErr := fetchTidalTrackInfo(ctx, apis, trackID)
+	if infoErr == nil {
+		q.DurationMS = info.Data.Duration * 1000
+		explicit := info.Data.Explicit
+		q.Explicit = &explicit
+		if q.Tier == "" {
+			q.Tier = tidalTagTier(info.Data.AudioQuality, info.Data.MediaMetadata.Tags)
+		}
+	}
+
+	if streamErr != nil && infoErr != nil {
+		return nil, streamErr
+	}
+	return q, nil
+}
+
+// qualityTier classifies a lossless format. Anything above CD quality is
+// hi-res.
+func qualityTier(bitDepth, sampleRate int) string {
+	switch {
+	case bitDepth > 16 || sampleRate > 48000:
+		return QualityHiRes
+	case bitDepth > 0:
+		return QualityLossless
+	}
+	return ""
+}
+
+func tidalTagTier(audioQuality string, tags []string) string {
+	for _, tag := range tags {
+		if tag == "HIRES_LOSSLESS" {
+			return QualityHiRes
+		}
+	}
+	if audioQuality == "HI_RES_LOSSLESS" {
+		return QualityHiRes
+	}
+	for _, tag := range tags {
+		if tag == "LOSSLESS" {
+			return QualityLossless
+		}
+	}
+	if audioQuality == "LOSSLESS" {
+		return QualityLossless
+	}
+	return ""
+}
+
+// compareWithSpotify warns when a service's version of a track differs in
+// length or explicitness from the Spotify track.
+func compareWithSpotify(q ServiceQuality, check AvailabilityCheck) []string {
+	if !q.Available {
+		return nil
+	}
+
+	var warnings []string
+	if check.DurationMS > 0 && q.DurationMS > 0 {
+		diff := time.Duration(q.DurationMS-check.DurationMS) * time.Millisecond
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > durationTolerance {
+			warnings = append(warnings, fmt.Sprintf("duration differs from Spotify by %s", diff.Round(time.Second)))
+		}
+	}
+	if check.Explicit != nil && q.Explicit != nil && *check.Explicit != *q.Explicit {
+		if *q.Explicit {
+			warnings = append(warnings, "explicit version, Spotify track is clean")
+		} else {
+			warnings = append(warnings, "clean version, Spotify track is explicit")
+		}
+	}
+	return warnings
+}
+
+func tierRank(tier string) int {
+	switch tier {
+	case QualityHiRes:
+		return 2
+	case QualityLossless:
+		return 1
+	}
+	return 0
+}
+
+// betterQuality reports whether a is a better source than b. Versions that
+// match the Spotify track win over higher quality ones that do not.
+func betterQuality(a, b *ServiceQuality) bool {
+	if (len(a.Warnings) == 0) != (len(b.Warnings) == 0) {
+		return len(a.Warnings) == 0
+	}
+	if tierRank(a.Tier) != tierRank(b.Tier) {
+		return tierRank(a.Tier) > tierRank(b.Tier)
+	}
+	if a.BitDepth != b.BitDepth {
+		return a.BitDepth > b.BitDepth
+	}
+	return a.SampleRate > b.SampleRate
+}
+
+// bestService returns the service with the best available version of a
+// track, or "" when none has it.
+func bestService(services map[string]*ServiceQuality) string {
+	best := ""
+	for _, service := range availabilityServices {
+		q := services[service]
+		if q == nil || !q.Available {
+			continue
+		}
+		if best == "" || betterQuality(q, services[best]) {
+			best = service
+		}
+	}
+	return best
+}
+
+// SummarizeAvailability counts the tracks each service offers and returns
+// the service that covers the most tracks, preferring more hi-res tracks and
+// fewer mismatches on a tie.
+func SummarizeAvailability(results []*TrackAvailability) (map[string]*ServiceSummary, string) {
+	summary := map[string]*ServiceSummary{}
+	for _, service := range availabilityServices {
+		summary[service] = &ServiceSummary{}
+	}
+	for _, result := range results {
+		for service, q := range result.Services {
+			sum := summary[service]
+			if sum == nil || !q.Available {
+				continue
+			}
+			sum.Available++
+			switch q.Tier {
+			case QualityHiRes:
+				sum.HiRes++
+			case QualityLossless:
+				sum.Lossless++
+			}
+			if len(q.Warnings) > 0 {
+				sum.Warnings++
+			}
+		}
+	}
+
+	best := ""
+	for _, service := range availabilityServices {
+		sum := summary[service]
+		if sum.Available == 0 {
+			continue
+		}
+		if best == "" {
+			best = service
+			continue
+		}
+		b := summary[best]
+		switch {
+		case sum.Available != b.Available:
+			if sum.Available > b.Available {
+				best = service
+			}
+		case sum.HiRes != b.HiRes:
+			if sum.HiRes > b.HiRes {
+				best = service
+			}
+		case sum.Warnings < b.Warnings:
+			best = service
+		}
+	}
+	return summary, best
+}
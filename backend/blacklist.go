@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const sourceBlacklistBucket = "SourceBlacklist"
+
+// blacklistConfidence is the confidence a fake-lossless verdict needs before
+// its source is blacklisted.
+const blacklistConfidence = 0.8
+
+// BlacklistEntry is a provider track that served fake lossless audio. It is
+// skipped by later downloads so the fallback chain moves on to the next
+// service.
+type BlacklistEntry struct {
+	Key        string  `json:"key"`
+	Provider   string  `json:"provider"`
+	ID         string  `json:"id"`
+	SpotifyID  string  `json:"spotify_id,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Verdict    string  `json:"verdict"`
+	Confidence float64 `json:"confidence"`
+	Reason     string  `json:"reason,omitempty"`
+	Timestamp  int64   `json:"timestamp"`
+}
+
+func blacklistKey(provider, id string) string {
+	return provider + ":" + id
+}
+
+func BlacklistSource(entry BlacklistEntry, appName string) error {
+	if historyDB == nil {
+		if err := InitHistoryDB(appName); err != nil {
+			return err
+		}
+	}
+	entry.Key = blacklistKey(entry.Provider, entry.ID)
+	entry.Timestamp = time.Now().Unix()
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(sourceBlacklistBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(entry.Key), buf)
+	})
+}
+
+// IsSourceBlacklisted reports whether a provider track has been blacklisted.
+func IsSourceBlacklisted(provider, id, appName string) bool {
+	if historyDB == nil {
+		if err := InitHistoryDB(appName); err != nil {
+			return false
+		}
+	}
+	found := false
+	historyDB.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(sourceBlacklistBucket)); b != nil {
+			found = b.Get([]byte(blacklistKey(provider, id))) != nil
+		}
+		return nil
+	})
+	return found
+}
+
+func GetBlacklist(appName string) ([]BlacklistEntry, error) {
+	if historyDB == nil {
+		if err := InitHistoryDB(appName); err != nil {
+			return nil, err
+		}
+	}
+	entries := []BlacklistEntry{}
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sourceBlacklistBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var entry BlacklistEntry
+			if err := json.Unmarshal(v, &entry); err == nil {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Timestamp > entries[j].Timestamp
+	})
+
+	return entries, err
+}
+
+func RemoveFromBlacklist(key string, appName string) error {
+	if historyDB == nil {
+		if err := InitHistoryDB(appName); err != nil {
+			return err
+		}
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sourceBlacklistBucket))
+		if b == nil || b.Get([]byte(key)) == nil {
+			return fmt.Errorf("blacklist entry not found")
+		}
+		return b.Delete([]byte(key))
+	})
+}
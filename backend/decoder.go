@@ -0,0 +1,310 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	mewflac "github.com/mewkiz/flac"
+)
+
+// audioFormat describes a decoded stream. Samples are integers of sampleBits
+// bits. bitsPerSample is the bit depth of the source, which is 0 for lossy
+// codecs. totalSamples is per channel and 0 when unknown; for files decoded by
+// ffmpeg it is estimated from the duration.
+type audioFormat struct {
+	codec         string
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	sampleBits    int
+	totalSamples  int64
+}
+
+// lossless reports whether the source keeps every sample as it was mastered.
+func (f audioFormat) lossless() bool {
+	return f.bitsPerSample > 0
+}
+
+// audioDecoder streams the PCM samples of a file. Next returns a block of
+// samples, one slice per channel, and io.EOF after the last one. The slices
+// are only valid until the next call.
+type audioDecoder interface {
+	Format() audioFormat
+	Next() ([][]int32, error)
+	Close() error
+}
+
+// openAudio returns a decoder for a file: FLAC is decoded natively, every
+// other format through ffmpeg, which is killed when ctx is done.
+func openAudio(ctx context.Context, path string) (audioDecoder, error) {
+	if strings.EqualFold(filepath.Ext(path), ".flac") {
+		return openFLACDecoder(path)
+	}
+	return openFFmpegDecoder(ctx, path)
+}
+
+type flacDecoder struct {
+	stream *mewflac.Stream
+	format audioFormat
+	block  [][]int32
+}
+
+func openFLACDecoder(path string) (*flacDecoder, error) {
+	stream, err := mewflac.ParseFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FLAC: %w", err)
+	}
+	info := stream.Info
+	return &flacDecoder{
+		stream: stream,
+		format: audioFormat{
+			codec:         "flac",
+			sampleRate:    int(info.SampleRate),
+			channels:      int(info.NChannels),
+			bitsPerSample: int(info.BitsPerSample),
+			sampleBits:    int(info.BitsPerSample),
+			totalSamples:  int64(info.NSamples),
+		},
+		block: make([][]int32, info.NChannels),
+	}, nil
+}
+
+func (d *flacDecoder) Format() audioFormat {
+	return d.format
+}
+
+func (d *flacDecoder) Next() ([][]int32, error) {
+	frame, err := d.stream.ParseNext()
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode FLAC: %w", err)
+	}
+	n := frame.Subframes[0].NSamples
+	for ch := range d.block {
+		d.block[ch] = frame.Subframes[ch].Samples[:n]
+	}
+	return d.block, nil
+}
+
+func (d *flacDecoder) Close() error {
+	return d.stream.Close()
+}
+
+// ffmpegBlockSamples is how many samples per channel an ffmpeg decoder
+// returns at once.
+const ffmpegBlockSamples = 4096
+
+// losslessCodecs are the ffprobe codec names whose bit depth is real.
+var losslessCodecs = map[string]bool{
+	"alac": true, "flac": true, "wavpack": true, "ape": true, "tta": true, "mlp": true, "truehd": true,
+}
+
+// ffmpegDecoder reads raw little-endian PCM from an ffmpeg process: 16 or 32
+// bit integers for lossless sources and 32-bit floats for lossy ones, which
+// are converted to 24-bit integers without clipping overs.
+type ffmpegDecoder struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	reader *bufio.Reader
+	format audioFormat
+	float  bool
+	raw    []byte
+	block  [][]int32
+	done   bool
+}
+
+func openFFmpegDecoder(ctx context.Context, path string) (*ffmpegDecoder, error) {
+	format, err := probeAudioFormat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	ffmpegPath, err := GetFFmpegPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateExecutable(ffmpegPath); err != nil {
+		return nil, fmt.Errorf("invalid ffmpeg executable: %w", err)
+	}
+
+	d := &ffmpegDecoder{format: format}
+	pcm := "s16le"
+	switch {
+	case !format.lossless():
+		pcm, d.float = "f32le", true
+		format.sampleBits = 24
+	case format.bitsPerSample > 16:
+		pcm = "s32le"
+		format.sampleBits = 32
+	default:
+		format.sampleBits = 16
+	}
+	d.format = format
+
+	d.cmd = exec.CommandContext(ctx, ffmpegPath,
+		"-v", "error",
+		"-i", path,
+		"-map", "0:a:0",
+		"-f", pcm,
+		"-acodec", "pcm_"+pcm,
+		"-",
+	)
+	setHideWindow(d.cmd)
+	d.cmd.Stderr = &d.stderr
+	d.stdout, err = d.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	bytesPerSample := 2
+	if pcm != "s16le" {
+		bytesPerSample = 4
+	}
+	d.reader = bufio.NewReaderSize(d.stdout, 64*1024)
+	d.raw = make([]byte, ffmpegBlockSamples*format.channels*bytesPerSample)
+	d.block = make([][]int32, format.channels)
+	for ch := range d.block {
+		d.block[ch] = make([]int32, ffmpegBlockSamples)
+	}
+	return d, nil
+}
+
+func (d *ffmpegDecoder) Format() audioFormat {
+	return d.format
+}
+
+func (d *ffmpegDecoder) Next() ([][]int32, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+
+	n, err := io.ReadFull(d.reader, d.raw)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		d.done = true
+		if waitErr := d.cmd.Wait(); waitErr != nil {
+			return nil, fmt.Errorf("ffmpeg failed to decode: %v: %s", waitErr, strings.TrimSpace(d.stderr.String()))
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read from ffmpeg: %w", err)
+	}
+
+	channels := d.format.channels
+	width := len(d.raw) / ffmpegBlockSamples / channels
+	samples := n / width / channels
+	if samples == 0 {
+		return nil, io.EOF
+	}
+	for ch := range d.block {
+		d.block[ch] = d.block[ch][:samples]
+	}
+	for i := 0; i < samples*channels; i++ {
+		b := d.raw[i*width:]
+		var sample int32
+		switch {
+		case d.float:
+			sample = int32(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) * (1 << 23))
+		case width == 4:
+			sample = int32(binary.LittleEndian.Uint32(b))
+		default:
+			sample = int32(int16(binary.LittleEndian.Uint16(b)))
+		}
+		d.block[i%channels][i/channels] = sample
+	}
+	return d.block, nil
+}
+
+func (d *ffmpegDecoder) Close() error {
+	d.stdout.Close()
+	if !d.done {
+		d.done = true
+		d.cmd.Process.Kill()
+		d.cmd.Wait()
+	}
+	return nil
+}
+
+// probeAudioFormat reads the format of a file's first audio stream with
+// ffprobe.
+func probeAudioFormat(ctx context.Context, path string) (audioFormat, error) {
+	ffprobePath, err := GetFFprobePath()
+	if err != nil {
+		return audioFormat{}, err
+	}
+	if err := ValidateExecutable(ffprobePath); err != nil {
+		return audioFormat{}, fmt.Errorf("invalid ffprobe executable: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_streams",
+		"-select_streams", "a:0",
+		path,
+	)
+	setHideWindow(cmd)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return audioFormat{}, fmt.Errorf("failed to probe audio: %w", err)
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecName        string `json:"codec_name"`
+			SampleFmt        string `json:"sample_fmt"`
+			SampleRate       string `json:"sample_rate"`
+			Channels         int    `json:"channels"`
+			BitsPerSample    int    `json:"bits_per_sample"`
+			BitsPerRawSample string `json:"bits_per_raw_sample"`
+			Duration         string `json:"duration"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return audioFormat{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return audioFormat{}, fmt.Errorf("no audio stream found")
+	}
+
+	stream := result.Streams[0]
+	format := audioFormat{codec: stream.CodecName, channels: stream.Channels}
+	format.sampleRate, _ = strconv.Atoi(stream.SampleRate)
+	if format.sampleRate <= 0 || format.channels <= 0 {
+		return audioFormat{}, fmt.Errorf("invalid audio stream: %d Hz, %d channels", format.sampleRate, format.channels)
+	}
+
+	if losslessCodecs[stream.CodecName] || strings.HasPrefix(stream.CodecName, "pcm_") {
+		format.bitsPerSample, _ = strconv.Atoi(stream.BitsPerRawSample)
+		if format.bitsPerSample == 0 {
+			format.bitsPerSample = stream.BitsPerSample
+		}
+		if format.bitsPerSample == 0 {
+			format.bitsPerSample = 16
+			if strings.HasPrefix(stream.SampleFmt, "s32") {
+				format.bitsPerSample = 32
+			}
+		}
+	}
+
+	if duration, err := strconv.ParseFloat(stream.Duration, 64); err == nil {
+		format.totalSamples = int64(math.Round(duration * float64(format.sampleRate)))
+	}
+	return format, nil
+}
@@ -0,0 +1,315 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FallbackStep is one entry of a fallback chain such as "qobuz:27".
+type FallbackStep struct {
+	Provider string `json:"provider"`
+	Quality  string `json:"quality,omitempty"`
+}
+
+func (s FallbackStep) String() string {
+	if s.Quality == "" {
+		return s.Provider
+	}
+	return s.Provider + ":" + s.Quality
+}
+
+// FallbackAttempt reports the state of a single step while a chain runs.
+type FallbackAttempt struct {
+	Attempt  int    `json:"attempt"`
+	Total    int    `json:"total"`
+	Provider string `json:"provider"`
+	Quality  string `json:"quality"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+const (
+	AttemptTrying  = "trying"
+	AttemptFailed  = "failed"
+	AttemptSuccess = "success"
+)
+
+// ParseFallbackChain parses a chain like "qobuz:27 -> tidal:HI_RES -> amazon".
+// Steps may be separated by "->", "→", ">" or ",".
+func ParseFallbackChain(spec string) ([]FallbackStep, error) {
+	normalized := strings.NewReplacer("→", ",", "->", ",", ">", ",").Replace(spec)
+
+	var chain []FallbackStep
+	for _, part := range strings.Split(normalized, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, quality, _ := strings.Cut(part, ":")
+		step := FallbackStep{
+			Provider: strings.ToLower(strings.TrimSpace(name)),
+			Quality:  strings.TrimSpace(quality),
+		}
+
+		provider, err := GetProvider(step.Provider)
+		if err != nil {
+			return nil, err
+		}
+		if step.Quality != "" && !slices.Contains(provider.Qualities(), step.Quality) {
+			return nil, fmt.Errorf("unsupported quality %q for %s (supported: %s)", step.Quality, step.Provider, strings.Join(provider.Qualities(), ", "))
+		}
+
+		chain = append(chain, step)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("fallback chain is empty")
+	}
+	return chain, nil
+}
+
+// GetFallbackProfile returns a named chain from the "fallbackProfiles"
+// settings object, e.g. {"hires": "qobuz:27 -> tidal:HI_RES -> amazon"}.
+func GetFallbackProfile(name string) ([]FallbackStep, error) {
+	settings, err := LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, _ := settings["fallbackProfiles"].(map[string]interface{})
+	spec, ok := profiles[name].(string)
+	if !ok {
+		return nil, fmt.Errorf("fallback profile not found: %s", name)
+	}
+	return ParseFallbackChain(spec)
+}
+
+// DefaultFallbackChain builds the chain used by the "auto" downloader from
+// the autoOrder and autoQuality settings.
+func DefaultFallbackChain() ([]FallbackStep, error) {
+	settings, err := LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	order := SettingString(settings, "autoOrder", "tidal-qobuz-amazon")
+	is24Bit := SettingString(settings, "autoQuality", "24") == "24"
+
+	var chain []FallbackStep
+	for _, name := range strings.Split(order, "-") {
+		step := FallbackStep{Provider: name}
+		switch name {
+		case "tidal":
+			step.Quality = "LOSSLESS"
+			if is24Bit {
+				step.Quality = "HI_RES_LOSSLESS"
+			}
+		case "qobuz":
+			step.Quality = "6"
+			if is24Bit {
+				step.Quality = "7"
+			}
+		}
+		chain = append(chain, step)
+	}
+	return chain, nil
+}
+
+// DefaultQuality returns the quality selected in settings for a provider, or
+// "" for a provider without a quality setting.
+func DefaultQuality(settings map[string]interface{}, provider string) string {
+	switch provider {
+	case "tidal":
+		return SettingString(settings, "tidalQuality", "LOSSLESS")
+	case "qobuz":
+		return SettingString(settings, "qobuzQuality", "6")
+	case "amazon":
+		return SettingString(settings, "amazonQuality", "original")
+	}
+	return ""
+}
+
+// providerQuality returns the requested quality when the provider supports it
+// and the quality selected in settings for the provider otherwise, e.g. for
+// a generic format such as "flac".
+func providerQuality(p Provider, quality string) string {
+	qualities := p.Qualities()
+	if slices.Contains(qualities, quality) || len(qualities) == 0 {
+		return quality
+	}
+	settings, _ := LoadSettings()
+	if configured := DefaultQuality(settings, p.Name()); slices.Contains(qualities, configured) {
+		return configured
+	}
+	return DefaultQuality(nil, p.Name())
+}
+
+// verifyRetries is how many times a corrupt download is fetched again from
+// the same provider before the step fails.
+const verifyRetries = 1
+
+// downloadVerified downloads a track, verifies the file and checks it for
+// fake lossless audio. A file that fails either is removed so a retry or the
+// next step starts afresh.
+func downloadVerified(ctx context.Context, p Provider, req TrackRequest) (*DownloadResult, error) {
+	for try := 0; ; try++ {
+		result, err := DownloadWithProvider(ctx, p, req)
+		if err != nil || result.AlreadyExists {
+			return result, err
+		}
+
+		fmt.Println("Verifying download...")
+		verification := VerifyFile(result.FilePath, float64(req.Duration))
+		result.Verification = verification
+		if verification.Status != VerifyFailed {
+			if verification.Status == VerifyOK {
+				fmt.Printf("✓ Verified (%.1fs, MD5 %s)\n", verification.Duration, verification.MD5)
+			}
+			if err := analyzeDownload(ctx, p, req, result); err != nil {
+				os.Remove(result.FilePath)
+				return nil, err
+			}
+			tagReplayGain(ctx, result)
+			return result, nil
+		}
+
+		os.Remove(result.FilePath)
+		if !verification.Corrupt() || try >= verifyRetries || ctx.Err() != nil {
+			return nil, fmt.Errorf("verification failed: %s", verification.Error)
+		}
+		fmt.Printf("⚠ Verification failed (%s), downloading again...\n", verification.Error)
+	}
+}
+
+// analyzeDownload runs the fake-lossless check on a downloaded FLAC when the
+// "analyzeDownloads" setting is on. With "rejectFakeLossless" also on, a
+// confident fake verdict blacklists the provider track and fails the step.
+func analyzeDownload(ctx context.Context, p Provider, req TrackRequest, result *DownloadResult) error {
+	settings, err := LoadSettings()
+	if err != nil || !SettingBool(settings, "analyzeDownloads", false) {
+		return nil
+	}
+	if !strings.EqualFold(filepath.Ext(result.FilePath), ".flac") {
+		return nil
+	}
+
+	fmt.Println("Analyzing audio quality...")
+	verdict, err := AnalyzeQuality(ctx, result.FilePath)
+	if err != nil {
+		fmt.Printf("⚠ Failed to analyze audio quality: %v\n", err)
+		return nil
+	}
+	result.Verdict = verdict
+	if !verdict.Fake() {
+		fmt.Printf("✓ Quality check: %s (%.0f%% confidence)\n", verdict.Verdict, verdict.Confidence*100)
+		return nil
+	}
+
+	reason := strings.Join(verdict.Reasons, "; ")
+	fmt.Printf("⚠ Quality check: %s (%.0f%% confidence): %s\n", verdict.Verdict, verdict.Confidence*100, reason)
+	if verdict.Confidence < blacklistConfidence || !SettingBool(settings, "rejectFakeLossless", false) || result.TrackID == "" {
+		return nil
+	}
+
+	entry := BlacklistEntry{
+		Provider:   p.Name(),
+		ID:         result.TrackID,
+		SpotifyID:  req.SpotifyID,
+		Title:      req.TrackName,
+		Verdict:    verdict.Verdict,
+		Confidence: verdict.Confidence,
+		Reason:     reason,
+	}
+	if err := BlacklistSource(entry, "SpotiFLAC"); err != nil {
+		fmt.Printf("⚠ Failed to blacklist %s track %s: %v\n", entry.Provider, entry.ID, err)
+	}
+	return fmt.Errorf("fake lossless (%s, %.0f%% confidence): %s", verdict.Verdict, verdict.Confidence*100, reason)
+}
+
+// DownloadWithFallback tries each step of the chain in order and returns the
+// first successful result. onAttempt, if set, is called before and after
+// every step. The chain stops as soon as ctx is cancelled.
+func DownloadWithFallback(ctx context.Context, chain []FallbackStep, req TrackRequest, onAttempt func(FallbackAttempt)) (*DownloadResult, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("fallback chain is empty")
+	}
+
+	report := func(attempt FallbackAttempt) {
+		if onAttempt != nil {
+			onAttempt(attempt)
+		}
+	}
+
+	var errs []string
+	var lastErr error
+	for i, step := range chain {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		attempt := FallbackAttempt{
+			Attempt:  i + 1,
+			Total:    len(chain),
+			Provider: step.Provider,
+			Quality:  step.Quality,
+		}
+
+		provider, err := GetProvider(step.Provider)
+		if err != nil {
+			attempt.Status = AttemptFailed
+			attempt.Error = err.Error()
+			report(attempt)
+			errs = append(errs, fmt.Sprintf("%s: %v", step, err))
+			lastErr = err
+			continue
+		}
+
+		stepReq := req
+		if stepReq.RequestedQuality == "" {
+			stepReq.RequestedQuality = req.Quality
+		}
+		stepReq.Quality = providerQuality(provider, step.Quality)
+		attempt.Quality = stepReq.Quality
+
+		if len(chain) > 1 {
+			fmt.Printf("Trying %s (%d/%d)...\n", step, i+1, len(chain))
+		}
+
+		attempt.Status = AttemptTrying
+		report(attempt)
+
+		result, err := downloadVerified(ctx, provider, stepReq)
+		if err == nil {
+			if result.Provider == "" {
+				result.Provider = provider.Name()
+			}
+			if result.Quality == "" && !result.AlreadyExists {
+				result.Quality = stepReq.Quality
+			}
+			attempt.Status = AttemptSuccess
+			report(attempt)
+			return result, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		fmt.Printf("✗ %s failed: %v\n", step, err)
+		attempt.Status = AttemptFailed
+		attempt.Error = err.Error()
+		report(attempt)
+		errs = append(errs, fmt.Sprintf("%s: %v", step, err))
+		lastErr = err
+	}
+
+	if len(chain) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("all %d services failed: %s", len(chain), strings.Join(errs, "; "))
+}
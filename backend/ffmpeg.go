@@ -3,6 +3,7 @@ package backend
 import (
 	"archive/tar"
 	"archive/zip"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -505,7 +506,7 @@ type ConvertAudioResult struct {
 	Error      string `json:"error,omitempty"`
 }
 
-func ConvertAudio(req ConvertAudioRequest) ([]ConvertAudioResult, error) {
+func ConvertAudio(ctx context.Context, req ConvertAudioRequest) ([]ConvertAudioResult, error) {
 	ffmpegPath, err := GetFFmpegPath()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get ffmpeg path: %w", err)
@@ -624,7 +625,7 @@ func ConvertAudio(req ConvertAudioRequest) ([]ConvertAudioResult, error) {
 
 			fmt.Printf("[FFmpeg] Converting: %s -> %s\n", inputFile, outputFile)
 
-			cmd := exec.Command(ffmpegPath, args...)
+			cmd := exec.CommandContext(ctx, ffmpegPath, args...)
 
 			setHideWindow(cmd)
 			output, err := cmd.CombinedOutput()
@@ -641,7 +642,7 @@ func ConvertAudio(req ConvertAudioRequest) ([]ConvertAudioResult, error) {
 				return
 			}
 
-			if err := EmbedMetadataToConvertedFile(outputFile, inputMetadata, coverArtPath); err != nil {
+			if err := EmbedMetadataToConvertedFile(ctx, outputFile, inputMetadata, coverArtPath); err != nil {
 				fmt.Printf("[FFmpeg] Warning: Failed to embed metadata: %v\n", err)
 			} else {
 				fmt.Printf("[FFmpeg] Metadata embedded successfully\n")
@@ -315,58 +315,29 @@ func readM4aMetadata(filePath string) (*AudioMetadata, error) {
 	return metadata, nil
 }
 
+// GenerateFilename builds a new name for an existing audio file from its tags
+// using a filename template. It returns "" when the template produces no text.
 func GenerateFilename(metadata *AudioMetadata, format string, ext string) string {
 	if metadata == nil {
 		return ""
 	}
 
-	result := format
-
-	year := metadata.Year
-	if len(year) >= 4 {
-		year = year[:4]
-	}
-
-	result = strings.ReplaceAll(result, "{title}", sanitizeFilenameForRename(metadata.Title))
-	result = strings.ReplaceAll(result, "{artist}", sanitizeFilenameForRename(metadata.Artist))
-	result = strings.ReplaceAll(result, "{album}", sanitizeFilenameForRename(metadata.Album))
-	result = strings.ReplaceAll(result, "{album_artist}", sanitizeFilenameForRename(metadata.AlbumArtist))
-	result = strings.ReplaceAll(result, "{year}", sanitizeFilenameForRename(year))
-
-	if metadata.TrackNumber > 0 {
-		result = strings.ReplaceAll(result, "{track}", fmt.Sprintf("%02d", metadata.TrackNumber))
-	} else {
-		result = strings.ReplaceAll(result, "{track}", "")
-	}
-
-	if metadata.DiscNumber > 0 {
-		result = strings.ReplaceAll(result, "{disc}", fmt.Sprintf("%d", metadata.DiscNumber))
-	} else {
-		result = strings.ReplaceAll(result, "{disc}", "")
-	}
-
-	result = strings.TrimSpace(result)
-	result = strings.Join(strings.Fields(result), " ")
-
-	result = strings.Trim(result, " -._")
-
-	if result == "" {
+	result, err := RenderFilename(format, TemplateData{
+		Title:       metadata.Title,
+		Artist:      metadata.Artist,
+		Album:       metadata.Album,
+		AlbumArtist: metadata.AlbumArtist,
+		ReleaseDate: metadata.Year,
+		Track:       metadata.TrackNumber,
+		Disc:        metadata.DiscNumber,
+	}, false)
+	if err != nil || result == "" {
 		return ""
 	}
 
 	return result + ext
 }
 
-func sanitizeFilenameForRename(name string) string {
-
-	invalid := []string{"<", ">", ":", "\"", "/", "\\", "|", "?", "*"}
-	result := name
-	for _, char := range invalid {
-		result = strings.ReplaceAll(result, char, "")
-	}
-	return strings.TrimSpace(result)
-}
-
 func PreviewRename(files []string, format string) []RenamePreview {
 	var previews []RenamePreview
 
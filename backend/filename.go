@@ -4,74 +4,31 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
+// BuildExpectedFilename returns the filename a download of the given track is
+// saved under, including the extension (flac when format is empty).
 func BuildExpectedFilename(trackName, artistName, albumName, albumArtist, releaseDate, filenameFormat, playlistName, playlistOwner string, includeTrackNumber bool, position, discNumber int, useAlbumTrackNumber bool, format string) string {
-
-	safeTitle := SanitizeFilename(trackName)
-	safeArtist := SanitizeFilename(artistName)
-	safeAlbum := SanitizeFilename(albumName)
-	safeAlbumArtist := SanitizeFilename(albumArtist)
-
-	safePlaylist := SanitizeFilename(playlistName)
-	safeCreator := SanitizeFilename(playlistOwner)
-
-	year := ""
-	if len(releaseDate) >= 4 {
-		year = releaseDate[:4]
-	}
-
-	var filename string
-
-	if strings.Contains(filenameFormat, "{") {
-		filename = filenameFormat
-		filename = strings.ReplaceAll(filename, "{title}", safeTitle)
-		filename = strings.ReplaceAll(filename, "{artist}", safeArtist)
-		filename = strings.ReplaceAll(filename, "{album}", safeAlbum)
-		filename = strings.ReplaceAll(filename, "{album_artist}", safeAlbumArtist)
-		filename = strings.ReplaceAll(filename, "{year}", year)
-		filename = strings.ReplaceAll(filename, "{playlist}", safePlaylist)
-		filename = strings.ReplaceAll(filename, "{creator}", safeCreator)
-
-		if discNumber > 0 {
-			filename = strings.ReplaceAll(filename, "{disc}", fmt.Sprintf("%d", discNumber))
-		} else {
-			filename = strings.ReplaceAll(filename, "{disc}", "")
-		}
-
-		if position > 0 {
-			filename = strings.ReplaceAll(filename, "{track}", fmt.Sprintf("%02d", position))
-		} else {
-
-			filename = regexp.MustCompile(`\{track\}\.\s*`).ReplaceAllString(filename, "")
-			filename = regexp.MustCompile(`\{track\}\s*-\s*`).ReplaceAllString(filename, "")
-			filename = regexp.MustCompile(`\{track\}\s*`).ReplaceAllString(filename, "")
-		}
-	} else {
-
-		switch filenameFormat {
-		case "artist-title":
-			filename = fmt.Sprintf("%s - %s", safeArtist, safeTitle)
-		case "title":
-			filename = safeTitle
-		default:
-			filename = fmt.Sprintf("%s - %s", safeTitle, safeArtist)
-		}
-
-		if includeTrackNumber && position > 0 {
-			filename = fmt.Sprintf("%02d. %s", position, filename)
-		}
-	}
-
-	// Use provided format or default to flac
 	if format == "" {
 		format = "flac"
 	}
 
-	return filename + "." + format
+	data := TemplateData{
+		Title:       trackName,
+		Artist:      artistName,
+		Album:       albumName,
+		AlbumArtist: albumArtist,
+		ReleaseDate: releaseDate,
+		Playlist:    playlistName,
+		Creator:     playlistOwner,
+		Track:       position,
+		Disc:        discNumber,
+	}
+	return BuildFilename(filenameFormat, data, includeTrackNumber, "."+format)
 }
 
 func SanitizeFilename(name string) string {
@@ -175,3 +132,201 @@ func sanitizeFolderName(name string) string { return SanitizeFilename(name) }
 
 // sanitizeFilename is an alias for SanitizeFilename for backward compatibility
 func sanitizeFilename(name string) string { return SanitizeFilename(name) }
+
+// TemplateData holds the track values available to path and filename
+// templates.
+type TemplateData struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	ReleaseDate string
+	Playlist    string
+	Creator     string
+	Track       int
+	Disc        int
+	TotalTracks int
+	TotalDiscs  int
+	ISRC        string
+	Label       string
+	Quality     string
+	Explicit    bool
+}
+
+func (d TemplateData) values() map[string]string {
+	year := ""
+	if len(d.ReleaseDate) >= 4 {
+		year = d.ReleaseDate[:4]
+	}
+	number := func(n int) string {
+		if n <= 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	explicit := ""
+	if d.Explicit {
+		explicit = "Explicit"
+	}
+
+	return map[string]string{
+		"title":        d.Title,
+		"artist":       d.Artist,
+		"album":        d.Album,
+		"album_artist": d.AlbumArtist,
+		"year":         year,
+		"date":         d.ReleaseDate,
+		"playlist":     d.Playlist,
+		"creator":      d.Creator,
+		"track":        number(d.Track),
+		"disc":         number(d.Disc),
+		"total_tracks": number(d.TotalTracks),
+		"total_discs":  number(d.TotalDiscs),
+		"isrc":         d.ISRC,
+		"label":        d.Label,
+		"quality":      d.Quality,
+		"explicit":     explicit,
+	}
+}
+
+// folderTemplateDefaults match the values the web UI used for empty fields.
+var folderTemplateDefaults = map[string]string{
+	"title":        "Unknown Title",
+	"artist":       "Unknown Artist",
+	"album":        "Unknown Album",
+	"album_artist": "Unknown Artist",
+	"track":        "00",
+	"disc":         "1",
+	"year":         "0000",
+}
+
+// BuildFolderPath expands a folder template such as
+// "{album_artist|artist}/{album}{if total_discs>1}/Disc {disc}{end}" into a
+// relative path. Slashes in values do not create folders; each segment is
+// sanitized and empty segments are dropped.
+func BuildFolderPath(template string, data TemplateData) (string, error) {
+	if strings.TrimSpace(template) == "" {
+		return "", nil
+	}
+
+	tmpl, err := ParseTemplate(template)
+	if err != nil {
+		return "", err
+	}
+
+	values := data.values()
+	if values["album_artist"] == "" {
+		values["album_artist"] = values["artist"]
+	}
+
+	rendered := tmpl.Render(values, folderTemplateDefaults, func(v string) string {
+		return strings.ReplaceAll(v, "/", " ")
+	})
+
+	var parts []string
+	for _, segment := range strings.Split(rendered, "/") {
+		if strings.TrimSpace(segment) == "" {
+			continue
+		}
+		parts = append(parts, SanitizeFilename(segment))
+	}
+	return filepath.Join(parts...), nil
+}
+
+// defaultFilenameTemplate is used when a filename template fails to parse.
+const defaultFilenameTemplate = "{title} - {artist}"
+
+// legacyTrackPattern matches a bare {track} and the separator after it, which
+// older templates expected to disappear along with a missing track number.
+var legacyTrackPattern = regexp.MustCompile(`\{track\}(\.\s*|\s*-\s*|\s*)`)
+
+// FilenameTemplate converts a filename format into template syntax. Besides
+// templates it accepts the presets "title-artist", "artist-title" and "title";
+// includeTrackNumber prefixes presets with the track number.
+func FilenameTemplate(format string, includeTrackNumber bool) string {
+	if strings.Contains(format, "{") {
+		return legacyTrackPattern.ReplaceAllString(format, "<{track}$1>")
+	}
+
+	var tmpl string
+	switch format {
+	case "artist-title":
+		tmpl = "{artist} - {title}"
+	case "title":
+		tmpl = "{title}"
+	default:
+		tmpl = defaultFilenameTemplate
+	}
+	if includeTrackNumber {
+		tmpl = "<{track}. >" + tmpl
+	}
+	return tmpl
+}
+
+// RenderFilename expands a filename format without an extension. Values are
+// sanitized individually, so separators in the format are kept while slashes
+// or reserved characters in tags are not. The result is empty when the
+// template produces no text.
+func RenderFilename(format string, data TemplateData, includeTrackNumber bool) (string, error) {
+	tmpl, err := ParseTemplate(FilenameTemplate(format, includeTrackNumber))
+	if err != nil {
+		return "", err
+	}
+
+	name := tmpl.Render(data.values(), nil, SanitizeFilename)
+	name = strings.Join(strings.Fields(name), " ")
+	return strings.Trim(name, " -._"), nil
+}
+
+// BuildFilename expands a filename format and appends ext. Invalid templates
+// fall back to "{title} - {artist}".
+func BuildFilename(format string, data TemplateData, includeTrackNumber bool, ext string) string {
+	name, err := RenderFilename(format, data, includeTrackNumber)
+	if err != nil {
+		fmt.Printf("⚠ Invalid filename template %q: %v\n", format, err)
+		name, _ = RenderFilename(defaultFilenameTemplate, data, false)
+	}
+	if name == "" {
+		name = "Unknown"
+	}
+	return name + ext
+}
+
+// filenameDataForRequest collects the filename values of a download request,
+// numbering by album track number when the request asks for it.
+func filenameDataForRequest(req TrackRequest) TemplateData {
+	artist := req.ArtistName
+	albumArtist := req.AlbumArtist
+	if req.UseFirstArtistOnly {
+		artist = GetFirstArtist(artist)
+		albumArtist = GetFirstArtist(albumArtist)
+	}
+
+	track := req.Position
+	if req.UseAlbumTrackNumber && req.TrackNumber > 0 {
+		track = req.TrackNumber
+	}
+
+	quality := req.RequestedQuality
+	if quality == "" {
+		quality = req.Quality
+	}
+
+	return TemplateData{
+		Title:       req.TrackName,
+		Artist:      artist,
+		Album:       req.AlbumName,
+		AlbumArtist: albumArtist,
+		ReleaseDate: req.ReleaseDate,
+		Playlist:    req.PlaylistName,
+		Creator:     req.PlaylistOwner,
+		Track:       track,
+		Disc:        req.DiscNumber,
+		TotalTracks: req.TotalTracks,
+		TotalDiscs:  req.TotalDiscs,
+		ISRC:        req.ISRC,
+		Label:       req.Publisher,
+		Quality:     quality,
+		Explicit:    req.Explicit,
+	}
+}
@@ -12,17 +12,31 @@ import (
 )
 
 type HistoryItem struct {
-	ID          string `json:"id"`
-	SpotifyID   string `json:"spotify_id"`
-	Title       string `json:"title"`
-	Artists     string `json:"artists"`
-	Album       string `json:"album"`
-	DurationStr string `json:"duration_str"`
-	CoverURL    string `json:"cover_url"`
-	Quality     string `json:"quality"`
-	Format      string `json:"format"`
-	Path        string `json:"path"`
-	Timestamp   int64  `json:"timestamp"`
+	ID           string          `json:"id"`
+	SpotifyID    string          `json:"spotify_id"`
+	Title        string          `json:"title"`
+	Artists      string          `json:"artists"`
+	Album        string          `json:"album"`
+	DurationStr  string          `json:"duration_str"`
+	CoverURL     string          `json:"cover_url"`
+	Quality      string          `json:"quality"`
+	Format       string          `json:"format"`
+	Provider     string          `json:"provider,omitempty"`
+	Match        *TrackMatch     `json:"match,omitempty"`
+	Verification *VerifyResult   `json:"verification,omitempty"`
+	Verdict      *QualityVerdict `json:"verdict,omitempty"`
+	Path         string          `json:"path"`
+	Timestamp    int64           `json:"timestamp"`
+}
+
+// ExpectedDuration is the duration in seconds the item's file should have:
+// the one it was first verified with, or else the listed duration. It is 0
+// when neither is known.
+func (h HistoryItem) ExpectedDuration() float64 {
+	if h.Verification != nil && h.Verification.ExpectedDuration > 0 {
+		return h.Verification.ExpectedDuration
+	}
+	return float64(parseDuration(h.DurationStr)) / 1000
 }
 
 var historyDB *bolt.DB
@@ -140,6 +154,27 @@ func GetHistoryItems(appName string) ([]HistoryItem, error) {
 	return items, err
 }
 
+// UpdateHistoryItem overwrites an existing history item, keeping its ID and
+// timestamp.
+func UpdateHistoryItem(item HistoryItem, appName string) error {
+	if historyDB == nil {
+		if err := InitHistoryDB(appName); err != nil {
+			return err
+		}
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(historyBucket))
+		if b == nil || b.Get([]byte(item.ID)) == nil {
+			return fmt.Errorf("history item not found")
+		}
+		buf, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(item.ID), buf)
+	})
+}
+
 func ClearHistory(appName string) error {
 	if historyDB == nil {
 		if err := InitHistoryDB(appName); err != nil {
@@ -0,0 +1,576 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobSkipped   JobStatus = "skipped"
+	JobCancelled JobStatus = "cancelled"
+)
+
+const jobsBucket = "DownloadJobs"
+
+// ErrJobCancelled is the cause of a job context cancelled through
+// JobQueue.Cancel. Other cancellations, such as a dropped client connection,
+// leave partial downloads in place so they can be resumed.
+var ErrJobCancelled = errors.New("job cancelled")
+
+// jobCancelled reports whether ctx was cancelled through JobQueue.Cancel.
+func jobCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrJobCancelled)
+}
+
+// Job is a persisted unit of download work. Payload holds the original
+// request so a job can be replayed after a restart.
+type Job struct {
+	ID         string          `json:"id"`
+	BatchID    string          `json:"batch_id,omitempty"`
+	TrackName  string          `json:"track_name"`
+	ArtistName string          `json:"artist_name"`
+	AlbumName  string          `json:"album_name"`
+	SpotifyID  string          `json:"spotify_id"`
+	Status     JobStatus       `json:"status"`
+	Attempts   int             `json:"attempts"`
+	Error      string          `json:"error,omitempty"`
+	FilePath   string          `json:"file_path,omitempty"`
+	CreatedAt  int64           `json:"created_at"`
+	StartedAt  int64           `json:"started_at,omitempty"`
+	FinishedAt int64           `json:"finished_at,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+func (j *Job) IsFinished() bool {
+	return j.Status == JobCompleted || j.Status == JobFailed || j.Status == JobSkipped || j.Status == JobCancelled
+}
+
+type JobResult struct {
+	Status   JobStatus
+	FilePath string
+	Error    string
+}
+
+// JobHandler runs a job. ctx is cancelled when the job is cancelled through
+// JobQueue.Cancel.
+type JobHandler func(ctx context.Context, job *Job) JobResult
+
+// JobQueue drains persisted download jobs with a fixed pool of workers.
+type JobQueue struct {
+	appName  string
+	workers  int
+	handler  JobHandler
+	onUpdate func(job Job)
+
+	mu      sync.Mutex
+	pending []string
+	running map[string]context.CancelCauseFunc
+	wake    chan struct{}
+	stop    chan struct{}
+	started bool
+	paused  bool
+	admit   func() bool
+}
+
+func NewJobQueue(appName string, workers int, handler JobHandler) *JobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &JobQueue{
+		appName: appName,
+		workers: workers,
+		handler: handler,
+		running: make(map[string]context.CancelCauseFunc),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// SetUpdateCallback registers a function that is called after every job state change.
+func (q *JobQueue) SetUpdateCallback(callback func(job Job)) {
+	q.onUpdate = callback
+}
+
+// SetAdmitFunc registers a function that decides whether queued jobs may
+// start now. Jobs that are not admitted stay queued until Wake is called.
+func (q *JobQueue) SetAdmitFunc(admit func() bool) {
+	q.mu.Lock()
+	q.admit = admit
+	q.mu.Unlock()
+}
+
+// Pause stops workers from starting queued jobs. Running jobs are not
+// interrupted.
+func (q *JobQueue) Pause() {
+	q.mu.Lock()
+	q.paused = true
+	q.mu.Unlock()
+}
+
+// Resume lets workers start queued jobs again.
+func (q *JobQueue) Resume() {
+	q.mu.Lock()
+	q.paused = false
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Paused reports whether the queue is paused.
+func (q *JobQueue) Paused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// Pending returns the number of jobs waiting for a worker.
+func (q *JobQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Wake makes idle workers check the queue again, e.g. when a download
+// window opens.
+func (q *JobQueue) Wake() {
+	q.signal()
+}
+
+func (q *JobQueue) ensureDB() error {
+	if historyDB == nil {
+		return InitHistoryDB(q.appName)
+	}
+	return nil
+}
+
+// Start restores unfinished jobs from the database and launches the workers.
+// Jobs that were running when the server stopped are queued again.
+func (q *JobQueue) Start() error {
+	if err := q.ensureDB(); err != nil {
+		return err
+	}
+
+	var restored []Job
+	err := historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(jobsBucket))
+		if err != nil {
+			return err
+		}
+
+		var interrupted []Job
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				continue
+			}
+			if job.IsFinished() {
+				continue
+			}
+			if job.Status == JobRunning {
+				job.Status = JobQueued
+				job.StartedAt = 0
+				interrupted = append(interrupted, job)
+			}
+			restored = append(restored, job)
+		}
+
+		for _, job := range interrupted {
+			buf, err := json.Marshal(job)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(job.ID), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore jobs: %w", err)
+	}
+
+	q.mu.Lock()
+	for _, job := range restored {
+		q.pending = append(q.pending, job.ID)
+		AddToQueue(job.ID, job.TrackName, job.ArtistName, job.AlbumName, job.SpotifyID)
+	}
+	q.started = true
+	q.mu.Unlock()
+
+	if len(restored) > 0 {
+		fmt.Printf("[Jobs] Restored %d unfinished job(s)\n", len(restored))
+	}
+
+	for i := 0; i < q.workers; i++ {
+		go q.worker()
+	}
+	q.signal()
+
+	return nil
+}
+
+// Stop prevents workers from picking up new jobs. Jobs that are still running
+// stay marked as running and are queued again on the next Start.
+func (q *JobQueue) Stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.started {
+		return
+	}
+	q.started = false
+	close(q.stop)
+}
+
+func (q *JobQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue persists the given jobs and hands them to the workers.
+func (q *JobQueue) Enqueue(jobs []Job) ([]Job, error) {
+	if err := q.ensureDB(); err != nil {
+		return nil, err
+	}
+
+	err := historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(jobsBucket))
+		if err != nil {
+			return err
+		}
+		for i := range jobs {
+			seq, _ := b.NextSequence()
+			jobs[i].ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), seq)
+			jobs[i].Status = JobQueued
+			jobs[i].CreatedAt = time.Now().Unix()
+
+			buf, err := json.Marshal(jobs[i])
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(jobs[i].ID), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save jobs: %w", err)
+	}
+
+	q.mu.Lock()
+	for _, job := range jobs {
+		q.pending = append(q.pending, job.ID)
+		AddToQueue(job.ID, job.TrackName, job.ArtistName, job.AlbumName, job.SpotifyID)
+	}
+	q.mu.Unlock()
+
+	for _, job := range jobs {
+		q.notify(job)
+	}
+	q.signal()
+
+	return jobs, nil
+}
+
+func (q *JobQueue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 || q.paused {
+		return "", false
+	}
+	if q.admit != nil && !q.admit() {
+		return "", false
+	}
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	return id, true
+}
+
+func (q *JobQueue) worker() {
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		id, ok := q.next()
+		if !ok {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.stop:
+				return
+			}
+		}
+
+		// More work may be waiting for another idle worker.
+		q.signal()
+		q.run(id)
+	}
+}
+
+func (q *JobQueue) run(id string) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	q.mu.Lock()
+	q.running[id] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, id)
+		q.mu.Unlock()
+	}()
+
+	job, err := q.updateJob(id, func(job *Job) bool {
+		if job.Status != JobQueued {
+			return false
+		}
+		job.Status = JobRunning
+		job.Attempts++
+		job.StartedAt = time.Now().Unix()
+		job.Error = ""
+		return true
+	})
+	if err != nil || job == nil {
+		return
+	}
+
+	result := q.safeHandle(ctx, job)
+	if ctx.Err() != nil && result.Status == JobFailed {
+		result.Status = JobCancelled
+		result.Error = "Cancelled"
+	}
+
+	q.updateJob(id, func(job *Job) bool {
+		job.Status = result.Status
+		job.FilePath = result.FilePath
+		job.Error = result.Error
+		job.FinishedAt = time.Now().Unix()
+		return true
+	})
+}
+
+func (q *JobQueue) safeHandle(ctx context.Context, job *Job) (result JobResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = JobResult{Status: JobFailed, Error: fmt.Sprintf("job panicked: %v", r)}
+		}
+	}()
+	return q.handler(ctx, job)
+}
+
+// updateJob applies fn to the stored job inside a single transaction. When fn
+// returns false the job is left untouched and nil is returned.
+func (q *JobQueue) updateJob(id string, fn func(job *Job) bool) (*Job, error) {
+	if err := q.ensureDB(); err != nil {
+		return nil, err
+	}
+
+	var updated *Job
+	err := historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(jobsBucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		var job Job
+		if err := json.Unmarshal(v, &job); err != nil {
+			return err
+		}
+		if !fn(&job) {
+			return nil
+		}
+
+		buf, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(id), buf); err != nil {
+			return err
+		}
+		updated = &job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if updated != nil {
+		q.notify(*updated)
+	}
+	return updated, nil
+}
+
+func (q *JobQueue) notify(job Job) {
+	if q.onUpdate != nil {
+		q.onUpdate(job)
+	}
+}
+
+// CancelQueued marks every job that has not started yet as cancelled.
+func (q *JobQueue) CancelQueued() int {
+	q.mu.Lock()
+	ids := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	cancelled := 0
+	for _, id := range ids {
+		job, _ := q.updateJob(id, func(job *Job) bool {
+			if job.Status != JobQueued {
+				return false
+			}
+			job.Status = JobCancelled
+			job.Error = "Cancelled"
+			job.FinishedAt = time.Now().Unix()
+			return true
+		})
+		if job != nil {
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// Cancel stops a job. Queued jobs are marked cancelled immediately; running
+// jobs have their context cancelled and are marked by the worker once the
+// handler returns. It reports false when the job is unknown or finished.
+func (q *JobQueue) Cancel(id string) (bool, error) {
+	q.mu.Lock()
+	if cancel, ok := q.running[id]; ok {
+		q.mu.Unlock()
+		cancel(ErrJobCancelled)
+		return true, nil
+	}
+	for i, pendingID := range q.pending {
+		if pendingID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+
+	job, err := q.updateJob(id, func(job *Job) bool {
+		if job.Status != JobQueued {
+			return false
+		}
+		job.Status = JobCancelled
+		job.Error = "Cancelled"
+		job.FinishedAt = time.Now().Unix()
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	return job != nil, nil
+}
+
+func (q *JobQueue) GetJob(id string) (*Job, error) {
+	if err := q.ensureDB(); err != nil {
+		return nil, err
+	}
+
+	var job *Job
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(jobsBucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		job = &Job{}
+		return json.Unmarshal(v, job)
+	})
+	return job, err
+}
+
+// ListJobs returns all jobs in creation order, optionally filtered by status
+// and batch.
+func (q *JobQueue) ListJobs(status JobStatus, batchID string) ([]Job, error) {
+	if err := q.ensureDB(); err != nil {
+		return nil, err
+	}
+
+	jobs := []Job{}
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(jobsBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				continue
+			}
+			if status != "" && job.Status != status {
+				continue
+			}
+			if batchID != "" && job.BatchID != batchID {
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt < jobs[j].CreatedAt
+	})
+
+	return jobs, err
+}
+
+// ClearFinishedJobs removes completed, failed, skipped and cancelled jobs from
+// the database.
+func (q *JobQueue) ClearFinishedJobs() error {
+	if err := q.ensureDB(); err != nil {
+		return err
+	}
+
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(jobsBucket))
+		if b == nil {
+			return nil
+		}
+
+		var keysToDelete [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil || job.IsFinished() {
+				keysToDelete = append(keysToDelete, k)
+			}
+		}
+
+		for _, k := range keysToDelete {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
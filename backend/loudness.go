@@ -0,0 +1,322 @@
+package backend
+
+import (
+	"context"
+	"math"
+	"slices"
+)
+
+const (
+	// loudnessAbsoluteGate drops silence from the measurement, in LUFS.
+	loudnessAbsoluteGate = -70.0
+	// integratedRelativeGate and rangeRelativeGate are relative to the
+	// loudness of the blocks that pass the absolute gate, in LU.
+	integratedRelativeGate = -10.0
+	rangeRelativeGate      = -20.0
+
+	// truePeakOversampling and truePeakTaps describe the polyphase
+	// interpolator of BS.1770-4 Annex 2: 4x oversampling with 12 taps per
+	// phase.
+	truePeakOversampling = 4
+	truePeakTaps         = 12
+
+	// silenceDB is reported for peaks of digital silence.
+	silenceDB = -120.0
+)
+
+// Loudness is an ITU-R BS.1770-4 / EBU R128 measurement. Integrated is in
+// LUFS, Range (LRA) in LU, TruePeak in dBTP and SamplePeak in dBFS.
+type Loudness struct {
+	Integrated float64 `json:"integrated"`
+	Range      float64 `json:"range"`
+	TruePeak   float64 `json:"true_peak"`
+	SamplePeak float64 `json:"sample_peak"`
+
+	// blocks are the mean square energies of the 400 ms gating blocks and
+	// peak is the linear true peak. Both are kept to measure whole albums.
+	blocks []float64
+	peak   float64
+}
+
+// MeasureLoudness decodes an audio file and measures its integrated
+// loudness, loudness range and true peak.
+func MeasureLoudness(ctx context.Context, path string) (*Loudness, error) {
+	analysis, err := analyzeAudio(ctx, path, analysisOptions{loudness: true})
+	if err != nil {
+		return nil, err
+	}
+	return analysis.loudness, nil
+}
+
+// AlbumLoudness gates the blocks of all tracks together, the way an album
+// played from start to finish would be measured.
+func AlbumLoudness(tracks []*Loudness) *Loudness {
+	album := &Loudness{SamplePeak: silenceDB}
+	for _, track := range tracks {
+		album.blocks = append(album.blocks, track.blocks...)
+		album.peak = max(album.peak, track.peak)
+		album.SamplePeak = max(album.SamplePeak, track.SamplePeak)
+	}
+	album.Integrated = round2(gatedLoudness(album.blocks, integratedRelativeGate))
+	album.TruePeak = round2(linearToDB(album.peak))
+	return album
+}
+
+// biquad is a second order IIR filter in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the two stages of the BS.1770 K-weighting filter, a
+// high shelf and a high pass, for any sample rate.
+func kWeighting(sampleRate float64) [2]biquad {
+	f0 := 1681.974450955533
+	gain := 3.999843853973347
+	q := 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0 = 38.13547087602444
+	q = 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return [2]biquad{shelf, highPass}
+}
+
+// truePeakFilter holds the phases of a windowed-sinc interpolator that
+// upsamples by truePeakOversampling.
+var truePeakFilter = func() [truePeakOversampling][truePeakTaps]float64 {
+	var phases [truePeakOversampling][truePeakTaps]float64
+	n := truePeakOversampling * truePeakTaps
+	for i := 0; i < n; i++ {
+		t := (float64(i) - float64(n-1)/2) / truePeakOversampling
+		sinc := 1.0
+		if t != 0 {
+			sinc = math.Sin(math.Pi*t) / (math.Pi * t)
+		}
+		window := 0.5 * (1 - math.Cos(2*math.Pi*float64(i+1)/float64(n+1)))
+		phases[i%truePeakOversampling][i/truePeakOversampling] = sinc * window
+	}
+	for p := range phases {
+		var sum float64
+		for _, h := range phases[p] {
+			sum += h
+		}
+		for k := range phases[p] {
+			phases[p][k] /= sum
+		}
+	}
+	return phases
+}()
+
+// loudnessMeter measures loudness in a single pass. Energy is summed in
+// 100 ms steps: four make a 400 ms gating block and thirty a 3 s short-term
+// block for the loudness range, taken every second.
+type loudnessMeter struct {
+	weights []float64
+	filters [][2]biquad
+	history [][truePeakTaps]float64
+	pos     int
+
+	stepLength int
+	stepEnergy float64
+	stepCount  int
+	steps      int
+	recent     []float64
+
+	blocks     []float64
+	shortTerm  []float64
+	truePeak   float64
+	samplePeak float64
+}
+
+func newLoudnessMeter(sampleRate, channels int) *loudnessMeter {
+	m := &loudnessMeter{
+		weights:    channelWeights(channels),
+		filters:    make([][2]biquad, channels),
+		history:    make([][truePeakTaps]float64, channels),
+		stepLength: max(1, sampleRate/10),
+	}
+	for ch := range m.filters {
+		m.filters[ch] = kWeighting(float64(sampleRate))
+	}
+	return m
+}
+
+// channelWeights follows BS.1770: surround channels count 1.41 times and the
+// LFE channel is left out. Channel order is the FLAC/WAV order.
+func channelWeights(channels int) []float64 {
+	switch channels {
+	case 4:
+		return []float64{1, 1, 1.41, 1.41}
+	case 5:
+		return []float64{1, 1, 1, 1.41, 1.41}
+	case 6:
+		return []float64{1, 1, 1, 0, 1.41, 1.41}
+	}
+	weights := make([]float64, channels)
+	for ch := range weights {
+		weights[ch] = 1
+	}
+	return weights
+}
+
+// add feeds samples normalized to [-1, 1], one slice per channel.
+func (m *loudnessMeter) add(samples [][]float64) {
+	n := len(samples[0])
+	for i := 0; i < n; i++ {
+		m.pos = (m.pos + 1) % truePeakTaps
+		var energy float64
+		for ch, weight := range m.weights {
+			x := samples[ch][i]
+			m.samplePeak = max(m.samplePeak, math.Abs(x))
+			m.truePeak = max(m.truePeak, m.interpolatedPeak(ch, x))
+
+			filters := &m.filters[ch]
+			y := filters[1].process(filters[0].process(x))
+			energy += weight * y * y
+		}
+
+		m.stepEnergy += energy
+		m.stepCount++
+		if m.stepCount == m.stepLength {
+			m.endStep()
+		}
+	}
+}
+
+// interpolatedPeak adds x to the channel's history and returns the largest
+// absolute value of the samples interpolated before it.
+func (m *loudnessMeter) interpolatedPeak(ch int, x float64) float64 {
+	history := &m.history[ch]
+	history[m.pos] = x
+	var peak float64
+	for p := range truePeakFilter {
+		var y float64
+		for k, h := range truePeakFilter[p] {
+			y += h * history[(m.pos-k+truePeakTaps)%truePeakTaps]
+		}
+		peak = max(peak, math.Abs(y))
+	}
+	return peak
+}
+
+func (m *loudnessMeter) endStep() {
+	m.recent = append(m.recent, m.stepEnergy/float64(m.stepLength))
+	if len(m.recent) > 30 {
+		m.recent = m.recent[1:]
+	}
+	m.stepEnergy, m.stepCount = 0, 0
+	m.steps++
+
+	if m.steps >= 4 {
+		m.blocks = append(m.blocks, mean(m.recent[len(m.recent)-4:]))
+	}
+	if m.steps >= 30 && (m.steps-30)%10 == 0 {
+		m.shortTerm = append(m.shortTerm, mean(m.recent))
+	}
+}
+
+func (m *loudnessMeter) result() *Loudness {
+	peak := max(m.truePeak, m.samplePeak)
+	return &Loudness{
+		Integrated: round2(gatedLoudness(m.blocks, integratedRelativeGate)),
+		Range:      round2(loudnessRange(m.shortTerm)),
+		TruePeak:   round2(linearToDB(peak)),
+		SamplePeak: round2(linearToDB(m.samplePeak)),
+		blocks:     m.blocks,
+		peak:       peak,
+	}
+}
+
+// gatedLoudness applies the absolute gate and the given relative gate to
+// the blocks and returns the loudness of the rest.
+func gatedLoudness(blocks []float64, relativeGate float64) float64 {
+	passed := gateBlocks(blocks, lufsToEnergy(loudnessAbsoluteGate))
+	if len(passed) == 0 {
+		return loudnessAbsoluteGate
+	}
+	threshold := lufsToEnergy(energyToLUFS(mean(passed)) + relativeGate)
+	passed = gateBlocks(passed, threshold)
+	if len(passed) == 0 {
+		return loudnessAbsoluteGate
+	}
+	return energyToLUFS(mean(passed))
+}
+
+// loudnessRange is the spread between the 10th and 95th percentile of the
+// gated short-term loudness (EBU Tech 3342).
+func loudnessRange(shortTerm []float64) float64 {
+	passed := gateBlocks(shortTerm, lufsToEnergy(loudnessAbsoluteGate))
+	if len(passed) == 0 {
+		return 0
+	}
+	threshold := lufsToEnergy(energyToLUFS(mean(passed)) + rangeRelativeGate)
+	passed = gateBlocks(passed, threshold)
+	if len(passed) < 2 {
+		return 0
+	}
+
+	levels := make([]float64, len(passed))
+	for i, energy := range passed {
+		levels[i] = energyToLUFS(energy)
+	}
+	slices.Sort(levels)
+	low := levels[int(math.Round(0.10*float64(len(levels)-1)))]
+	high := levels[int(math.Round(0.95*float64(len(levels)-1)))]
+	return high - low
+}
+
+func gateBlocks(blocks []float64, threshold float64) []float64 {
+	var passed []float64
+	for _, energy := range blocks {
+		if energy > threshold {
+			passed = append(passed, energy)
+		}
+	}
+	return passed
+}
+
+func energyToLUFS(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+func lufsToEnergy(lufs float64) float64 {
+	return math.Pow(10, (lufs+0.691)/10)
+}
+
+func linearToDB(x float64) float64 {
+	if x <= 0 {
+		return silenceDB
+	}
+	return max(silenceDB, 20*math.Log10(x))
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
@@ -0,0 +1,364 @@
+package backend
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MinMatchScore is the lowest score a candidate needs to be downloaded.
+const MinMatchScore = 0.6
+
+// Weights of the match criteria. ISRC agreement is applied on top of the
+// weighted score because it identifies the exact recording.
+const (
+	matchWeightTitle    = 0.35
+	matchWeightArtist   = 0.25
+	matchWeightDuration = 0.25
+	matchWeightAlbum    = 0.15
+
+	matchISRCBonus   = 0.3
+	matchISRCPenalty = 0.2
+)
+
+// MatchCandidate is a track in a provider's catalogue. Version holds the
+// version when the provider keeps it apart from the title (e.g. Qobuz's
+// "Remastered 2011").
+type MatchCandidate struct {
+	ID         string
+	Title      string
+	Version    string
+	Artists    []string
+	Album      string
+	ISRC       string
+	DurationMS int
+}
+
+// TrackMatch is the candidate a track was matched to and how confident the
+// match is, from 0 to 1. Unverified is set when the provider gave nothing to
+// compare, in which case Score is meaningless.
+type TrackMatch struct {
+	Provider   string   `json:"provider"`
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Artists    string   `json:"artists,omitempty"`
+	Album      string   `json:"album,omitempty"`
+	ISRC       string   `json:"isrc,omitempty"`
+	Score      float64  `json:"score"`
+	Unverified bool     `json:"unverified,omitempty"`
+	Reasons    []string `json:"reasons,omitempty"`
+}
+
+// versionKeywords mark a recording as a particular version. Two titles that
+// differ in these are different recordings even if the rest matches.
+var versionKeywords = []string{
+	"remaster", "remastered", "live", "radio edit", "edit", "acoustic",
+	"instrumental", "remix", "mix", "demo", "karaoke", "extended", "mono",
+	"unplugged", "orchestral", "sped up", "slowed", "reprise", "a cappella",
+	"acapella", "version", "re recorded", "rerecorded", "session",
+}
+
+var (
+	featRegex       = regexp.MustCompile(`(?i)[\(\[](feat|ft|featuring|with)\b[^\)\]]*[\)\]]|\s(feat\.?|ft\.|featuring)\s.*$`)
+	bracketRegex    = regexp.MustCompile(`[\(\[]([^\)\]]*)[\)\]]`)
+	dashVersionExpr = regexp.MustCompile(`\s+-\s+(.*)$`)
+	artistSplit     = regexp.MustCompile(`(?i)\s*(?:,|;|&|\bx\b|\band\b|\bfeat\.|\bfeat\b|\bft\.|\bfeaturing\b)\s*`)
+)
+
+// normalizeText lowercases s, strips accents and punctuation and collapses
+// whitespace.
+func normalizeText(s string) string {
+	s = norm.NFD.String(strings.ToLower(s))
+	s = strings.ReplaceAll(s, "&", " and ")
+
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Drop combining accents
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			b.WriteRune(r)
+			space = false
+		default:
+			if !space && b.Len() > 0 {
+				b.WriteByte(' ')
+				space = true
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// splitTitle separates a title into its base and the version keywords found
+// in brackets or after " - ". Featured artists are dropped.
+func splitTitle(title, version string) (string, []string) {
+	title = featRegex.ReplaceAllString(title, " ")
+
+	var versionText []string
+	if version != "" {
+		versionText = append(versionText, version)
+	}
+	for _, m := range bracketRegex.FindAllStringSubmatch(title, -1) {
+		versionText = append(versionText, m[1])
+	}
+	title = bracketRegex.ReplaceAllString(title, " ")
+	if m := dashVersionExpr.FindStringSubmatch(title); m != nil && hasVersionKeyword(m[1]) {
+		versionText = append(versionText, m[1])
+		title = title[:len(title)-len(m[0])]
+	}
+
+	tags := map[string]bool{}
+	for _, text := range versionText {
+		padded := " " + normalizeText(text) + " "
+		for _, kw := range versionKeywords {
+			if strings.Contains(padded, " "+kw+" ") {
+				tags[canonicalVersion(kw)] = true
+			}
+		}
+	}
+	// "Radio Edit" is an edit, not a mix, and "Live Version" is live
+	if tags["radio edit"] {
+		delete(tags, "edit")
+	}
+	delete(tags, "version")
+
+	list := make([]string, 0, len(tags))
+	for tag := range tags {
+		list = append(list, tag)
+	}
+	sort.Strings(list)
+	return normalizeText(title), list
+}
+
+func hasVersionKeyword(s string) bool {
+	padded := " " + normalizeText(s) + " "
+	for _, kw := range versionKeywords {
+		if strings.Contains(padded, " "+kw+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalVersion(kw string) string {
+	switch kw {
+	case "remastered":
+		return "remaster"
+	case "acapella":
+		return "a cappella"
+	case "rerecorded":
+		return "re recorded"
+	}
+	return kw
+}
+
+// splitArtists returns the normalized names of a comma or "&" separated
+// artist list.
+func splitArtists(artists ...string) map[string]bool {
+	names := map[string]bool{}
+	for _, a := range artists {
+		for _, name := range artistSplit.Split(a, -1) {
+			if n := normalizeText(name); n != "" {
+				names[n] = true
+			}
+		}
+	}
+	return names
+}
+
+// tokenSimilarity is the Jaccard index of the words of a and b.
+func tokenSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	wordsA := strings.Fields(a)
+	wordsB := strings.Fields(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	set := map[string]bool{}
+	for _, w := range wordsA {
+		set[w] = true
+	}
+	common := 0
+	seen := map[string]bool{}
+	for _, w := range wordsB {
+		if set[w] && !seen[w] {
+			common++
+		}
+		seen[w] = true
+	}
+	union := len(set) + len(seen) - common
+	return float64(common) / float64(union)
+}
+
+// ScoreMatch rates how likely candidate is the track described by req, from
+// 0 to 1, and explains the criteria that lowered the score. Criteria whose
+// values are unknown on either side are left out.
+func ScoreMatch(req TrackRequest, candidate MatchCandidate) (float64, []string) {
+	var reasons []string
+	var score, weight float64
+
+	if req.TrackName != "" && candidate.Title != "" {
+		wantTitle, wantTags := splitTitle(req.TrackName, "")
+		gotTitle, gotTags := splitTitle(candidate.Title, candidate.Version)
+
+		s := tokenSimilarity(wantTitle, gotTitle)
+		if s < 1 {
+			reasons = append(reasons, fmt.Sprintf("title %q differs", candidate.Title))
+		}
+		if missing, extra := diffTags(wantTags, gotTags); len(missing)+len(extra) > 0 {
+			if len(extra) > 0 {
+				reasons = append(reasons, fmt.Sprintf("candidate is %s", strings.Join(extra, ", ")))
+			}
+			if len(missing) > 0 {
+				reasons = append(reasons, fmt.Sprintf("candidate is not %s", strings.Join(missing, ", ")))
+			}
+			// A remaster is the same performance; other versions are not
+			if len(missing)+len(extra) == 1 && (slices.Contains(missing, "remaster") || slices.Contains(extra, "remaster")) {
+				s *= 0.6
+			} else {
+				s *= 0.3
+			}
+		}
+		score += s * matchWeightTitle
+		weight += matchWeightTitle
+	}
+
+	if req.ArtistName != "" && len(candidate.Artists) > 0 {
+		want := splitArtists(req.ArtistName)
+		got := splitArtists(candidate.Artists...)
+		common := 0
+		for name := range want {
+			if got[name] {
+				common++
+			}
+		}
+		s := 0.0
+		if n := min(len(want), len(got)); n > 0 {
+			s = float64(common) / float64(n)
+		}
+		if s < 1 {
+			reasons = append(reasons, fmt.Sprintf("artists %q differ", strings.Join(candidate.Artists, ", ")))
+		}
+		score += s * matchWeightArtist
+		weight += matchWeightArtist
+	}
+
+	if req.Duration > 0 && candidate.DurationMS > 0 {
+		delta := candidate.DurationMS/1000 - req.Duration
+		if delta < 0 {
+			delta = -delta
+		}
+		var s float64
+		switch {
+		case delta <= 2:
+			s = 1
+		case delta <= 5:
+			s = 0.7
+		case delta <= 10:
+			s = 0.3
+		}
+		if delta > 2 {
+			reasons = append(reasons, fmt.Sprintf("duration differs by %ds", delta))
+		}
+		score += s * matchWeightDuration
+		weight += matchWeightDuration
+	}
+
+	if req.AlbumName != "" && candidate.Album != "" {
+		wantAlbum, _ := splitTitle(req.AlbumName, "")
+		gotAlbum, _ := splitTitle(candidate.Album, "")
+		s := tokenSimilarity(wantAlbum, gotAlbum)
+		if s < 1 && wantAlbum != "" && gotAlbum != "" && (strings.Contains(gotAlbum, wantAlbum) || strings.Contains(wantAlbum, gotAlbum)) {
+			s = 0.7
+		}
+		if s < 1 {
+			reasons = append(reasons, fmt.Sprintf("album %q differs", candidate.Album))
+		}
+		score += s * matchWeightAlbum
+		weight += matchWeightAlbum
+	}
+
+	if weight > 0 {
+		score /= weight
+	}
+
+	if req.ISRC != "" && candidate.ISRC != "" {
+		if strings.EqualFold(req.ISRC, candidate.ISRC) {
+			if weight == 0 {
+				score = 1
+			} else {
+				score = min(1, score+matchISRCBonus)
+			}
+		} else {
+			reasons = append(reasons, fmt.Sprintf("ISRC %s differs", candidate.ISRC))
+			score = max(0, score-matchISRCPenalty)
+		}
+	} else if weight == 0 {
+		// Nothing to compare, the candidate is taken on trust
+		score = 1
+	}
+
+	return score, reasons
+}
+
+func diffTags(want, got []string) (missing, extra []string) {
+	for _, tag := range want {
+		if !slices.Contains(got, tag) {
+			missing = append(missing, tag)
+		}
+	}
+	for _, tag := range got {
+		if !slices.Contains(want, tag) {
+			extra = append(extra, tag)
+		}
+	}
+	return missing, extra
+}
+
+// BestMatch scores the candidates against req and returns the index of the
+// best one. It fails when no candidate reaches MinMatchScore, naming the best
+// rejected candidate and why it scored low.
+func BestMatch(provider string, req TrackRequest, candidates []MatchCandidate) (int, *TrackMatch, error) {
+	if len(candidates) == 0 {
+		return -1, nil, fmt.Errorf("no %s candidates for %q", provider, req.TrackName)
+	}
+
+	bestIdx, bestScore := -1, -1.0
+	var best *TrackMatch
+	for i, c := range candidates {
+		score, reasons := ScoreMatch(req, c)
+		if score > bestScore {
+			bestIdx, bestScore = i, score
+			best = &TrackMatch{
+				Provider: provider,
+				ID:       c.ID,
+				Title:    c.Title,
+				Artists:  strings.Join(c.Artists, ", "),
+				Album:    c.Album,
+				ISRC:     c.ISRC,
+				Score:    math.Round(score*100) / 100,
+				Reasons:  reasons,
+			}
+			if c.Version != "" {
+				best.Title = fmt.Sprintf("%s (%s)", c.Title, c.Version)
+			}
+		}
+	}
+
+	if best.Score < MinMatchScore {
+		return -1, best, fmt.Errorf("no confident %s match for %q: best candidate %q by %s scored %.2f (%s)",
+			provider, req.TrackName, best.Title, best.Artists, best.Score, strings.Join(best.Reasons, "; "))
+	}
+
+	fmt.Printf("✓ Matched %s track %s (score %.2f)\n", provider, best.ID, best.Score)
+	return bestIdx, best, nil
+}
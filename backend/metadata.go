@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -32,6 +33,7 @@ type Metadata struct {
 	Lyrics      string
 	Description string
 	ISRC        string
+	ReplayGain  *ReplayGain
 }
 
 func EmbedMetadata(filepath string, metadata Metadata, coverPath string) error {
@@ -95,6 +97,12 @@ func EmbedMetadata(filepath string, metadata Metadata, coverPath string) error {
 		_ = cmt.Add("LYRICS", metadata.Lyrics)
 	}
 
+	if metadata.ReplayGain != nil {
+		for _, tag := range metadata.ReplayGain.Tags() {
+			_ = cmt.Add(tag[0], tag[1])
+		}
+	}
+
 	cmtBlock := cmt.Marshal()
 	if cmtIdx < 0 {
 		f.Meta = append(f.Meta, &cmtBlock)
@@ -784,13 +792,18 @@ func ExtractFullMetadataFromFile(filePath string) (Metadata, error) {
 			if metadata.Description == "" {
 				metadata.Description = value
 			}
+		case "replaygain_track_gain", "replaygain_track_peak", "replaygain_album_gain", "replaygain_album_peak":
+			if metadata.ReplayGain == nil {
+				metadata.ReplayGain = &ReplayGain{}
+			}
+			setReplayGainTag(metadata.ReplayGain, key, value)
 		}
 	}
 
 	return metadata, nil
 }
 
-func EmbedMetadataToConvertedFile(filePath string, metadata Metadata, coverPath string) error {
+func EmbedMetadataToConvertedFile(ctx context.Context, filePath string, metadata Metadata, coverPath string) error {
 	ext := strings.ToLower(pathfilepath.Ext(filePath))
 
 	switch ext {
@@ -800,7 +813,7 @@ func EmbedMetadataToConvertedFile(filePath string, metadata Metadata, coverPath
 	case ".mp3":
 		return embedMetadataToMP3(filePath, metadata, coverPath)
 	case ".m4a":
-		return embedMetadataToM4A(filePath, metadata, coverPath)
+		return embedMetadataToM4A(ctx, filePath, metadata, coverPath)
 	default:
 		return fmt.Errorf("unsupported file format: %s", ext)
 	}
@@ -870,6 +883,10 @@ func embedMetadataToMP3(filePath string, metadata Metadata, coverPath string) er
 		tag.AddTextFrame("TSRC", id3v2.EncodingUTF8, metadata.ISRC)
 	}
 
+	if metadata.ReplayGain != nil {
+		addReplayGainFrames(tag, metadata.ReplayGain)
+	}
+
 	if coverPath != "" && fileExists(coverPath) {
 
 		tag.DeleteFrames(tag.CommonID("Attached picture"))
@@ -896,7 +913,7 @@ func embedMetadataToMP3(filePath string, metadata Metadata, coverPath string) er
 	return nil
 }
 
-func embedMetadataToM4A(filePath string, metadata Metadata, coverPath string) error {
+func embedMetadataToM4A(ctx context.Context, filePath string, metadata Metadata, coverPath string) error {
 	ffmpegPath, err := GetFFmpegPath()
 	if err != nil {
 		return fmt.Errorf("ffmpeg not found: %w", err)
@@ -966,7 +983,7 @@ func embedMetadataToM4A(filePath string, metadata Metadata, coverPath string) er
 
 	args = append(args, "-f", "ipod", tmpOutputFile)
 
-	cmd := exec.Command(ffmpegPath, args...)
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
 	setHideWindow(cmd)
 
 	output, err := cmd.CombinedOutput()
@@ -978,5 +995,11 @@ func embedMetadataToM4A(filePath string, metadata Metadata, coverPath string) er
 		return fmt.Errorf("failed to replace original file: %w", err)
 	}
 
+	if metadata.ReplayGain != nil {
+		if err := writeM4AFreeform(filePath, metadata.ReplayGain.Tags()); err != nil {
+			return fmt.Errorf("failed to write ReplayGain: %w", err)
+		}
+	}
+
 	return nil
 }
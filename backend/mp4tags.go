@@ -0,0 +1,313 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// mp4Atom is a box of an MP4 file. Containers keep their children parsed,
+// other atoms keep their payload as is. header holds the version and flags
+// of full boxes that have children, such as meta.
+type mp4Atom struct {
+	typ      string
+	header   []byte
+	data     []byte
+	children []*mp4Atom
+}
+
+// mp4Containers are the atoms parsed on the way to the iTunes tag list and
+// the chunk offset tables.
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "meta": true, "ilst": true, "edts": true, "dinf": true,
+}
+
+func parseMP4Atoms(b []byte) ([]*mp4Atom, error) {
+	var atoms []*mp4Atom
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, fmt.Errorf("truncated atom header")
+		}
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		headerLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, fmt.Errorf("truncated %s atom", typ)
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			headerLen = 16
+		}
+		if size < headerLen || size > uint64(len(b)) {
+			return nil, fmt.Errorf("invalid size of %s atom", typ)
+		}
+
+		atom := &mp4Atom{typ: typ}
+		payload := b[headerLen:size]
+		if mp4Containers[typ] {
+			// iTunes meta is a full box; QuickTime meta starts with its children
+			if typ == "meta" && len(payload) >= 8 && string(payload[4:8]) != "hdlr" {
+				atom.header, payload = payload[:4], payload[4:]
+			}
+			children, err := parseMP4Atoms(payload)
+			if err != nil {
+				return nil, err
+			}
+			atom.children = children
+		} else {
+			atom.data = payload
+		}
+		atoms = append(atoms, atom)
+		b = b[size:]
+	}
+	return atoms, nil
+}
+
+func (a *mp4Atom) size() int {
+	size := 8 + len(a.header) + len(a.data)
+	for _, child := range a.children {
+		size += child.size()
+	}
+	return size
+}
+
+func (a *mp4Atom) marshal(buf *bytes.Buffer) {
+	binary.Write(buf, binary.BigEndian, uint32(a.size()))
+	buf.WriteString(a.typ)
+	buf.Write(a.header)
+	buf.Write(a.data)
+	for _, child := range a.children {
+		child.marshal(buf)
+	}
+}
+
+func (a *mp4Atom) child(typ string) *mp4Atom {
+	for _, child := range a.children {
+		if child.typ == typ {
+			return child
+		}
+	}
+	return nil
+}
+
+// childOrNew returns the child of the given type, appending newAtom when
+// there is none.
+func (a *mp4Atom) childOrNew(typ string, newAtom func() *mp4Atom) *mp4Atom {
+	if child := a.child(typ); child != nil {
+		return child
+	}
+	child := newAtom()
+	a.children = append(a.children, child)
+	return child
+}
+
+// shiftChunkOffsets adds delta to the chunk offsets of every track, which is
+// needed when a grown moov atom sits before the media data.
+func (a *mp4Atom) shiftChunkOffsets(delta int64) error {
+	for _, child := range a.children {
+		if err := child.shiftChunkOffsets(delta); err != nil {
+			return err
+		}
+	}
+	switch a.typ {
+	case "stco", "co64":
+		if len(a.data) < 8 {
+			return fmt.Errorf("truncated %s atom", a.typ)
+		}
+		entrySize := 4
+		if a.typ == "co64" {
+			entrySize = 8
+		}
+		count := int(binary.BigEndian.Uint32(a.data[4:]))
+		if len(a.data) < 8+count*entrySize {
+			return fmt.Errorf("truncated %s atom", a.typ)
+		}
+		for i := 0; i < count; i++ {
+			entry := a.data[8+i*entrySize:]
+			if entrySize == 4 {
+				offset := int64(binary.BigEndian.Uint32(entry)) + delta
+				if offset > 0xFFFFFFFF {
+					return fmt.Errorf("chunk offset overflows stco")
+				}
+				binary.BigEndian.PutUint32(entry, uint32(offset))
+			} else {
+				binary.BigEndian.PutUint64(entry, uint64(int64(binary.BigEndian.Uint64(entry))+delta))
+			}
+		}
+	}
+	return nil
+}
+
+// freeformName returns the name of an iTunes "----" atom, e.g.
+// "REPLAYGAIN_TRACK_GAIN".
+func freeformName(a *mp4Atom) string {
+	children, err := parseMP4Atoms(a.data)
+	if err != nil {
+		return ""
+	}
+	for _, child := range children {
+		if child.typ == "name" && len(child.data) >= 4 {
+			return string(child.data[4:])
+		}
+	}
+	return ""
+}
+
+func newFreeformAtom(name, value string) *mp4Atom {
+	var buf bytes.Buffer
+	for _, part := range []struct {
+		typ  string
+		data []byte
+	}{
+		{"mean", append([]byte{0, 0, 0, 0}, "com.apple.iTunes"...)},
+		{"name", append([]byte{0, 0, 0, 0}, name...)},
+		// Type 1 is UTF-8 text, followed by a zero locale
+		{"data", append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, value...)},
+	} {
+		(&mp4Atom{typ: part.typ, data: part.data}).marshal(&buf)
+	}
+	return &mp4Atom{typ: "----", data: buf.Bytes()}
+}
+
+// topLevelAtom is the position of an atom at the top level of a file.
+type topLevelAtom struct {
+	typ    string
+	offset int64
+	size   int64
+}
+
+func scanMP4(f *os.File) ([]topLevelAtom, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var atoms []topLevelAtom
+	var offset int64
+	header := make([]byte, 16)
+	for offset < info.Size() {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("failed to read atom header: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		switch size {
+		case 0:
+			size = info.Size() - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("failed to read atom header: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if size < 8 || offset+size > info.Size() {
+			return nil, fmt.Errorf("invalid size of %s atom", header[4:8])
+		}
+		atoms = append(atoms, topLevelAtom{typ: string(header[4:8]), offset: offset, size: size})
+		offset += size
+	}
+	return atoms, nil
+}
+
+// writeM4AFreeform sets iTunes freeform ("----:com.apple.iTunes:NAME") tags,
+// replacing existing tags of the same names. Only the moov atom is rewritten;
+// the media data is copied unchanged.
+func writeM4AFreeform(path string, tags [][2]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	atoms, err := scanMP4(f)
+	if err != nil {
+		return err
+	}
+	var moov *topLevelAtom
+	mdatOffset := int64(-1)
+	for i := range atoms {
+		switch atoms[i].typ {
+		case "moov":
+			moov = &atoms[i]
+		case "mdat":
+			if mdatOffset < 0 {
+				mdatOffset = atoms[i].offset
+			}
+		}
+	}
+	if moov == nil {
+		return fmt.Errorf("no moov atom found")
+	}
+
+	raw := make([]byte, moov.size)
+	if _, err := f.ReadAt(raw, moov.offset); err != nil {
+		return fmt.Errorf("failed to read moov atom: %w", err)
+	}
+	parsed, err := parseMP4Atoms(raw)
+	if err != nil || len(parsed) != 1 {
+		return fmt.Errorf("failed to parse moov atom: %v", err)
+	}
+	root := parsed[0]
+
+	udta := root.childOrNew("udta", func() *mp4Atom { return &mp4Atom{typ: "udta"} })
+	meta := udta.childOrNew("meta", func() *mp4Atom {
+		hdlr := &mp4Atom{typ: "hdlr", data: append(make([]byte, 8), "mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)}
+		return &mp4Atom{typ: "meta", header: []byte{0, 0, 0, 0}, children: []*mp4Atom{hdlr}}
+	})
+	ilst := meta.childOrNew("ilst", func() *mp4Atom { return &mp4Atom{typ: "ilst"} })
+
+	replaced := map[string]bool{}
+	for _, tag := range tags {
+		replaced[strings.ToUpper(tag[0])] = true
+	}
+	kept := ilst.children[:0]
+	for _, item := range ilst.children {
+		if item.typ == "----" && replaced[strings.ToUpper(freeformName(item))] {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	ilst.children = kept
+	for _, tag := range tags {
+		ilst.children = append(ilst.children, newFreeformAtom(tag[0], tag[1]))
+	}
+
+	delta := int64(root.size()) - moov.size
+	if mdatOffset > moov.offset && delta != 0 {
+		if err := root.shiftChunkOffsets(delta); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	root.marshal(&buf)
+
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	_, err = io.Copy(out, io.NewSectionReader(f, 0, moov.offset))
+	if err == nil {
+		_, err = out.Write(buf.Bytes())
+	}
+	if err == nil {
+		end := moov.offset + moov.size
+		_, err = io.Copy(out, io.NewSectionReader(f, end, 1<<62))
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write M4A file: %w", err)
+	}
+
+	f.Close()
+	return os.Rename(tmpPath, path)
+}
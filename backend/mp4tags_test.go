@@ -0,0 +1,213 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testChunks = [][]byte{[]byte("first audio chunk"), []byte("second audio chunk")}
+
+// testTagItem builds an ilst item holding a data atom of the given type.
+func testTagItem(name string, dataType uint32, value []byte) *mp4Atom {
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(data, dataType)
+	var buf bytes.Buffer
+	(&mp4Atom{typ: "data", data: append(data, value...)}).marshal(&buf)
+	return &mp4Atom{typ: name, data: buf.Bytes()}
+}
+
+// tagItemValue returns the value of an ilst item's data atom.
+func tagItemValue(item *mp4Atom) []byte {
+	children, _ := parseMP4Atoms(item.data)
+	for _, child := range children {
+		if child.typ == "data" && len(child.data) >= 8 {
+			return child.data[8:]
+		}
+	}
+	return nil
+}
+
+func testChunkOffsetAtom(typ string, offsets []int64) *mp4Atom {
+	entrySize := 4
+	if typ == "co64" {
+		entrySize = 8
+	}
+	data := make([]byte, 8+len(offsets)*entrySize)
+	binary.BigEndian.PutUint32(data[4:], uint32(len(offsets)))
+	for i, offset := range offsets {
+		if entrySize == 4 {
+			binary.BigEndian.PutUint32(data[8+i*4:], uint32(offset))
+		} else {
+			binary.BigEndian.PutUint64(data[8+i*8:], uint64(offset))
+		}
+	}
+	return &mp4Atom{typ: typ, data: data}
+}
+
+// testMoov builds a moov atom with one track whose chunks start at offsets,
+// tagged with a title and a cover unless untagged is set.
+func testMoov(offsetAtom string, offsets []int64, untagged bool) *mp4Atom {
+	stbl := &mp4Atom{typ: "stbl", children: []*mp4Atom{testChunkOffsetAtom(offsetAtom, offsets)}}
+	trak := &mp4Atom{typ: "trak", children: []*mp4Atom{
+		{typ: "mdia", children: []*mp4Atom{
+			{typ: "minf", children: []*mp4Atom{stbl}},
+		}},
+	}}
+	moov := &mp4Atom{typ: "moov", children: []*mp4Atom{{typ: "mvhd", data: make([]byte, 100)}, trak}}
+	if untagged {
+		return moov
+	}
+
+	hdlr := &mp4Atom{typ: "hdlr", data: append(make([]byte, 8), "mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)}
+	ilst := &mp4Atom{typ: "ilst", children: []*mp4Atom{
+		testTagItem("\xa9nam", 1, []byte("Song Title")),
+		testTagItem("covr", 13, []byte("\xff\xd8\xff\xe0 jpeg")),
+		newFreeformAtom("REPLAYGAIN_TRACK_GAIN", "+1.00 dB"),
+	}}
+	meta := &mp4Atom{typ: "meta", header: []byte{0, 0, 0, 0}, children: []*mp4Atom{hdlr, ilst}}
+	moov.children = append(moov.children, &mp4Atom{typ: "udta", children: []*mp4Atom{meta}})
+	return moov
+}
+
+// writeTestM4A writes an M4A file with the moov atom before the media data
+// when faststart is set and after it otherwise.
+func writeTestM4A(t *testing.T, offsetAtom string, faststart, untagged bool) string {
+	t.Helper()
+	var ftyp, mdat bytes.Buffer
+	(&mp4Atom{typ: "ftyp", data: []byte("M4A \x00\x00\x00\x00M4A mp42isom")}).marshal(&ftyp)
+	(&mp4Atom{typ: "mdat", data: bytes.Join(testChunks, nil)}).marshal(&mdat)
+
+	chunkOffsets := func(mdatOffset int64) []int64 {
+		offsets := []int64{mdatOffset + 8}
+		return append(offsets, offsets[0]+int64(len(testChunks[0])))
+	}
+
+	var file bytes.Buffer
+	file.Write(ftyp.Bytes())
+	if faststart {
+		moovSize := testMoov(offsetAtom, []int64{0, 0}, untagged).size()
+		moov := testMoov(offsetAtom, chunkOffsets(int64(ftyp.Len()+moovSize)), untagged)
+		moov.marshal(&file)
+		file.Write(mdat.Bytes())
+	} else {
+		file.Write(mdat.Bytes())
+		testMoov(offsetAtom, chunkOffsets(int64(ftyp.Len())), untagged).marshal(&file)
+	}
+
+	path := filepath.Join(t.TempDir(), "track.m4a")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTestMoov parses the moov atom of a written file.
+func readTestMoov(t *testing.T, path string) (*mp4Atom, []byte) {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atoms, err := parseMP4Atoms(raw)
+	if err != nil {
+		t.Fatalf("parsing the tagged file: %v", err)
+	}
+	for _, atom := range atoms {
+		if atom.typ == "moov" {
+			return atom, raw
+		}
+	}
+	t.Fatal("tagged file has no moov atom")
+	return nil, nil
+}
+
+func testChunkOffsets(t *testing.T, moov *mp4Atom) []int64 {
+	t.Helper()
+	stbl := moov.child("trak").child("mdia").child("minf").child("stbl")
+	atom := stbl.children[0]
+	entrySize := 4
+	if atom.typ == "co64" {
+		entrySize = 8
+	}
+	var offsets []int64
+	count := int(binary.BigEndian.Uint32(atom.data[4:]))
+	for i := 0; i < count; i++ {
+		entry := atom.data[8+i*entrySize:]
+		if entrySize == 4 {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(entry)))
+		} else {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(entry)))
+		}
+	}
+	return offsets
+}
+
+func testFreeformTags(moov *mp4Atom) map[string][]string {
+	tags := map[string][]string{}
+	for _, item := range moov.child("udta").child("meta").child("ilst").children {
+		if item.typ != "----" {
+			continue
+		}
+		name := freeformName(item)
+		tags[name] = append(tags[name], string(tagItemValue(item)))
+	}
+	return tags
+}
+
+func TestWriteM4AFreeform(t *testing.T) {
+	rg := &ReplayGain{TrackGain: -7.25, TrackPeak: 0.988, HasAlbum: true, AlbumGain: -6.5, AlbumPeak: 1.02}
+
+	for _, tc := range []struct {
+		name       string
+		offsetAtom string
+		faststart  bool
+		untagged   bool
+	}{
+		{"faststart", "stco", true, false},
+		{"faststart co64", "co64", true, false},
+		{"moov at end", "stco", false, false},
+		{"faststart untagged", "stco", true, true},
+		{"moov at end untagged", "stco", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestM4A(t, tc.offsetAtom, tc.faststart, tc.untagged)
+
+			// Writing twice must replace the tags instead of adding more
+			for i := 0; i < 2; i++ {
+				if err := writeM4AFreeform(path, rg.Tags()); err != nil {
+					t.Fatalf("writeM4AFreeform: %v", err)
+				}
+			}
+
+			moov, raw := readTestMoov(t, path)
+			for i, offset := range testChunkOffsets(t, moov) {
+				chunk := testChunks[i]
+				if offset+int64(len(chunk)) > int64(len(raw)) || !bytes.Equal(raw[offset:offset+int64(len(chunk))], chunk) {
+					t.Errorf("chunk %d offset %d does not point at its data", i, offset)
+				}
+			}
+
+			tags := testFreeformTags(moov)
+			for _, tag := range rg.Tags() {
+				if got := tags[tag[0]]; len(got) != 1 || got[0] != tag[1] {
+					t.Errorf("%s = %q, want [%q]", tag[0], got, tag[1])
+				}
+			}
+
+			if tc.untagged {
+				return
+			}
+			ilst := moov.child("udta").child("meta").child("ilst")
+			title := ilst.child("\xa9nam")
+			if title == nil || string(tagItemValue(title)) != "Song Title" {
+				t.Error("title atom was not kept")
+			}
+			if cover := ilst.child("covr"); cover == nil || !bytes.HasPrefix(tagItemValue(cover), []byte("\xff\xd8")) {
+				t.Error("cover atom was not kept")
+			}
+		})
+	}
+}
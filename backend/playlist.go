@@ -0,0 +1,373 @@
+package backend
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PlaylistEntry is a track in an exported playlist. Duration is in seconds
+// and -1 when it could not be read.
+type PlaylistEntry struct {
+	Path     string
+	Title    string
+	Artist   string
+	Album    string
+	Duration int
+}
+
+// ReadPlaylistEntries reads the tags and duration of each audio file. Files
+// that no longer exist are skipped; unreadable tags fall back to the filename.
+func ReadPlaylistEntries(paths []string) []PlaylistEntry {
+	entries := make([]PlaylistEntry, 0, len(paths))
+	for _, path := range paths {
+		if !fileExists(path) {
+			fmt.Printf("⚠ Skipping missing playlist file: %s\n", path)
+			continue
+		}
+
+		entry := PlaylistEntry{Path: path, Duration: -1}
+		if metadata, err := ReadAudioMetadata(path); err == nil && metadata != nil {
+			entry.Title = metadata.Title
+			entry.Artist = metadata.Artist
+			entry.Album = metadata.Album
+		}
+		if duration, err := GetAudioDuration(path); err == nil && duration > 0 {
+			entry.Duration = int(math.Round(duration))
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// DisplayName returns "Artist - Title", or the filename when the file has no
+// title tag.
+func (e PlaylistEntry) DisplayName() string {
+	switch {
+	case e.Title == "":
+		return strings.TrimSuffix(filepath.Base(e.Path), filepath.Ext(e.Path))
+	case e.Artist == "":
+		return e.Title
+	default:
+		return e.Artist + " - " + e.Title
+	}
+}
+
+// playlistRelPath returns trackPath relative to the playlist's folder with
+// forward slashes, falling back to the absolute path on another volume.
+func playlistRelPath(playlistPath, trackPath string) string {
+	absTrack, err := filepath.Abs(trackPath)
+	if err != nil {
+		return filepath.ToSlash(trackPath)
+	}
+	absDir, err := filepath.Abs(filepath.Dir(playlistPath))
+	if err != nil {
+		return filepath.ToSlash(absTrack)
+	}
+	rel, err := filepath.Rel(absDir, absTrack)
+	if err != nil {
+		return filepath.ToSlash(absTrack)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// WriteM3U8 writes an extended M3U playlist with paths relative to the
+// playlist file.
+func WriteM3U8(playlistPath string, entries []PlaylistEntry) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n", entry.Duration, entry.DisplayName())
+		b.WriteString(playlistRelPath(playlistPath, entry.Path))
+		b.WriteString("\n")
+	}
+	return writePlaylistFile(playlistPath, b.String())
+}
+
+// writePlaylistFile replaces the playlist atomically so media servers never
+// read a half-written file.
+func writePlaylistFile(playlistPath, content string) error {
+	if err := os.MkdirAll(filepath.Dir(playlistPath), 0755); err != nil {
+		return fmt.Errorf("failed to create playlist directory: %w", err)
+	}
+
+	tmpPath := playlistPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+	if err := os.Rename(tmpPath, playlistPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return nil
+}
+
+// PlaylistFormats maps the supported export formats to their file extensions.
+var PlaylistFormats = map[string]string{
+	"m3u8": ".m3u8",
+	"xspf": ".xspf",
+	"jspf": ".jspf",
+	"pls":  ".pls",
+}
+
+// PlaylistExport is a Spotify playlist together with the local files its
+// tracks were downloaded to. Tracks without a file are kept so other tools can
+// still match them by Spotify URL or ISRC.
+type PlaylistExport struct {
+	Title      string
+	Creator    string
+	Annotation string
+	Image      string
+	Tracks     []PlaylistTrack
+}
+
+// PlaylistTrack is a track of an exported playlist. Path is empty when the
+// track was not downloaded.
+type PlaylistTrack struct {
+	Path       string
+	Title      string
+	Artist     string
+	Album      string
+	DurationMS int
+	TrackNum   int
+	SpotifyURL string
+	ISRC       string
+}
+
+// NewPlaylistExport builds an export from playlist metadata. files and isrcs
+// are keyed by Spotify track ID.
+func NewPlaylistExport(payload *PlaylistResponsePayload, files, isrcs map[string]string) *PlaylistExport {
+	info := payload.PlaylistInfo
+	creator := info.Owner.DisplayName
+	if creator == "" {
+		creator = info.Owner.Name
+	}
+
+	export := &PlaylistExport{
+		Title:      info.Name,
+		Creator:    creator,
+		Annotation: info.Description,
+		Image:      info.Cover,
+		Tracks:     make([]PlaylistTrack, 0, len(payload.TrackList)),
+	}
+
+	for i, track := range payload.TrackList {
+		spotifyURL := track.ExternalURL
+		if spotifyURL == "" && track.SpotifyID != "" {
+			spotifyURL = "https://open.spotify.com/track/" + track.SpotifyID
+		}
+
+		path := files[track.SpotifyID]
+		if path != "" && !fileExists(path) {
+			path = ""
+		}
+
+		export.Tracks = append(export.Tracks, PlaylistTrack{
+			Path:       path,
+			Title:      track.Name,
+			Artist:     track.Artists,
+			Album:      track.AlbumName,
+			DurationMS: track.DurationMS,
+			TrackNum:   i + 1,
+			SpotifyURL: spotifyURL,
+			ISRC:       isrcs[track.SpotifyID],
+		})
+	}
+	return export
+}
+
+// Downloaded returns the number of tracks that have a local file.
+func (p *PlaylistExport) Downloaded() int {
+	n := 0
+	for _, track := range p.Tracks {
+		if track.Path != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Write saves the playlist in format (m3u8, xspf, jspf or pls).
+func (p *PlaylistExport) Write(playlistPath, format string) error {
+	switch format {
+	case "m3u8":
+		var paths []string
+		for _, track := range p.Tracks {
+			if track.Path != "" {
+				paths = append(paths, track.Path)
+			}
+		}
+		return WriteM3U8(playlistPath, ReadPlaylistEntries(paths))
+	case "xspf":
+		return p.writeXSPF(playlistPath)
+	case "jspf":
+		return p.writeJSPF(playlistPath)
+	case "pls":
+		return p.writePLS(playlistPath)
+	default:
+		return fmt.Errorf("unsupported playlist format: %s", format)
+	}
+}
+
+// identifiers returns the URIs other tools can match a track by.
+func (t PlaylistTrack) identifiers() []string {
+	var ids []string
+	if t.SpotifyURL != "" {
+		ids = append(ids, t.SpotifyURL)
+	}
+	if t.ISRC != "" {
+		ids = append(ids, "isrc:"+t.ISRC)
+	}
+	return ids
+}
+
+// location returns the track's file as a URI relative to the playlist.
+func (t PlaylistTrack) location(playlistPath string) string {
+	if t.Path == "" {
+		return ""
+	}
+	return (&url.URL{Path: playlistRelPath(playlistPath, t.Path)}).String()
+}
+
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"playlist"`
+	Version    string      `xml:"version,attr"`
+	Namespace  string      `xml:"xmlns,attr"`
+	Title      string      `xml:"title,omitempty"`
+	Creator    string      `xml:"creator,omitempty"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Image      string      `xml:"image,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string   `xml:"location,omitempty"`
+	Identifier []string `xml:"identifier,omitempty"`
+	Title      string   `xml:"title,omitempty"`
+	Creator    string   `xml:"creator,omitempty"`
+	Album      string   `xml:"album,omitempty"`
+	TrackNum   int      `xml:"trackNum,omitempty"`
+	Duration   int      `xml:"duration,omitempty"`
+}
+
+func (p *PlaylistExport) writeXSPF(playlistPath string) error {
+	doc := xspfPlaylist{
+		Version:    "1",
+		Namespace:  "http://xspf.org/ns/0/",
+		Title:      p.Title,
+		Creator:    p.Creator,
+		Annotation: p.Annotation,
+		Image:      p.Image,
+	}
+	for _, track := range p.Tracks {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location:   track.location(playlistPath),
+			Identifier: track.identifiers(),
+			Title:      track.Title,
+			Creator:    track.Artist,
+			Album:      track.Album,
+			TrackNum:   track.TrackNum,
+			Duration:   track.DurationMS,
+		})
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode XSPF: %w", err)
+	}
+	return writePlaylistFile(playlistPath, xml.Header+string(data)+"\n")
+}
+
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title      string      `json:"title,omitempty"`
+	Creator    string      `json:"creator,omitempty"`
+	Annotation string      `json:"annotation,omitempty"`
+	Image      string      `json:"image,omitempty"`
+	Track      []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Location   []string `json:"location,omitempty"`
+	Identifier []string `json:"identifier,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Creator    string   `json:"creator,omitempty"`
+	Album      string   `json:"album,omitempty"`
+	TrackNum   int      `json:"trackNum,omitempty"`
+	Duration   int      `json:"duration,omitempty"`
+}
+
+func (p *PlaylistExport) writeJSPF(playlistPath string) error {
+	doc := jspfDocument{Playlist: jspfPlaylist{
+		Title:      p.Title,
+		Creator:    p.Creator,
+		Annotation: p.Annotation,
+		Image:      p.Image,
+		Track:      make([]jspfTrack, 0, len(p.Tracks)),
+	}}
+	for _, track := range p.Tracks {
+		var locations []string
+		if location := track.location(playlistPath); location != "" {
+			locations = []string{location}
+		}
+		doc.Playlist.Track = append(doc.Playlist.Track, jspfTrack{
+			Location:   locations,
+			Identifier: track.identifiers(),
+			Title:      track.Title,
+			Creator:    track.Artist,
+			Album:      track.Album,
+			TrackNum:   track.TrackNum,
+			Duration:   track.DurationMS,
+		})
+	}
+
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode JSPF: %w", err)
+	}
+	return writePlaylistFile(playlistPath, buf.String())
+}
+
+// writePLS writes a PLS playlist. Tracks that were not downloaded point at
+// their Spotify URL.
+func (p *PlaylistExport) writePLS(playlistPath string) error {
+	var b strings.Builder
+	b.WriteString("[playlist]\n")
+
+	n := 0
+	for _, track := range p.Tracks {
+		file := track.SpotifyURL
+		if track.Path != "" {
+			file = filepath.FromSlash(playlistRelPath(playlistPath, track.Path))
+		}
+		if file == "" {
+			continue
+		}
+		n++
+
+		title := track.Title
+		if track.Artist != "" {
+			title = track.Artist + " - " + track.Title
+		}
+		length := -1
+		if track.DurationMS > 0 {
+			length = int(math.Round(float64(track.DurationMS) / 1000))
+		}
+
+		fmt.Fprintf(&b, "File%d=%s\nTitle%d=%s\nLength%d=%d\n", n, file, n, title, n, length)
+	}
+
+	fmt.Fprintf(&b, "NumberOfEntries=%d\nVersion=2\n", n)
+	return writePlaylistFile(playlistPath, b.String())
+}
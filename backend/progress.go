@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"sync"
@@ -31,6 +32,10 @@ type DownloadItem struct {
 	EndTime      int64          `json:"end_time"`
 	ErrorMessage string         `json:"error_message"`
 	FilePath     string         `json:"file_path"`
+	Provider     string         `json:"provider,omitempty"`
+	Quality      string         `json:"quality,omitempty"`
+	Percent      float64        `json:"percent"`
+	ETA          float64        `json:"eta"`
 }
 
 var (
@@ -49,26 +54,8 @@ var (
 	totalDownloadedLock sync.RWMutex
 	sessionStartTime    int64
 	sessionStartLock    sync.RWMutex
-
-	// Global progress callback for SSE broadcasting
-	globalProgressCallback     func(itemID string, mbDownloaded, speedMBps float64)
-	globalProgressCallbackLock sync.RWMutex
 )
 
-// SetGlobalProgressCallback sets the callback for progress updates
-func SetGlobalProgressCallback(callback func(itemID string, mbDownloaded, speedMBps float64)) {
-	globalProgressCallbackLock.Lock()
-	globalProgressCallback = callback
-	globalProgressCallbackLock.Unlock()
-}
-
-// GetGlobalProgressCallback gets the current progress callback
-func GetGlobalProgressCallback() func(itemID string, mbDownloaded, speedMBps float64) {
-	globalProgressCallbackLock.RLock()
-	defer globalProgressCallbackLock.RUnlock()
-	return globalProgressCallback
-}
-
 type ProgressInfo struct {
 	IsDownloading bool    `json:"is_downloading"`
 	MBDownloaded  float64 `json:"mb_downloaded"`
@@ -100,6 +87,14 @@ func GetDownloadProgress() ProgressInfo {
 	speed := currentSpeed
 	speedLock.RUnlock()
 
+	// Downloads with their own reporter are summed so concurrent items
+	// do not overwrite each other
+	if active, mb, mbps := activeTransfers(); active > 0 {
+		downloading = true
+		progress = mb
+		speed = mbps
+	}
+
 	return ProgressInfo{
 		IsDownloading: downloading,
 		MBDownloaded:  progress,
@@ -107,6 +102,21 @@ func GetDownloadProgress() ProgressInfo {
 	}
 }
 
+// activeTransfers sums the progress and speed of all items being downloaded.
+func activeTransfers() (count int, mbDownloaded, speedMBps float64) {
+	downloadQueueLock.RLock()
+	defer downloadQueueLock.RUnlock()
+
+	for _, item := range downloadQueue {
+		if item.Status == StatusDownloading {
+			count++
+			mbDownloaded += item.Progress
+			speedMBps += item.Speed
+		}
+	}
+	return count, mbDownloaded, speedMBps
+}
+
 func SetDownloadSpeed(mbps float64) {
 	speedLock.Lock()
 	currentSpeed = mbps
@@ -131,50 +141,190 @@ func SetDownloading(downloading bool) {
 	}
 }
 
+// ProgressUpdate is a snapshot of a single item's transfer. TotalMB and
+// Percent are zero and ETA is -1 while the size is unknown.
+type ProgressUpdate struct {
+	ItemID       string  `json:"item_id"`
+	MBDownloaded float64 `json:"mb_downloaded"`
+	TotalMB      float64 `json:"total_mb"`
+	Percent      float64 `json:"percent"`
+	SpeedMBps    float64 `json:"speed_mbps"`
+	ETA          float64 `json:"eta"`
+}
+
+// ProgressReporter tracks the transfer of one download item and forwards
+// throttled updates to the queue and to onUpdate. Each download gets its own
+// reporter, so concurrent downloads do not overwrite each other. All methods
+// are no-ops on a nil reporter.
+type ProgressReporter struct {
+	itemID   string
+	onUpdate func(ProgressUpdate)
+
+	mu         sync.Mutex
+	downloaded int64
+	total      int64
+	speed      float64
+	lastTime   time.Time
+	lastBytes  int64
+	lastReport time.Time
+}
+
+const progressReportInterval = 250 * time.Millisecond
+
+func NewProgressReporter(itemID string, onUpdate func(ProgressUpdate)) *ProgressReporter {
+	return &ProgressReporter{
+		itemID:   itemID,
+		onUpdate: onUpdate,
+	}
+}
+
+type progressReporterKey struct{}
+
+// WithProgressReporter returns a context that carries r to the download code.
+func WithProgressReporter(ctx context.Context, r *ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressReporterKey{}, r)
+}
+
+func progressFromContext(ctx context.Context) *ProgressReporter {
+	r, _ := ctx.Value(progressReporterKey{}).(*ProgressReporter)
+	return r
+}
+
+// Start begins a new transfer of total bytes (0 if unknown) of which offset
+// bytes are already on disk, e.g. when resuming a partial file.
+func (r *ProgressReporter) Start(offset, total int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.downloaded = offset
+	r.total = total
+	r.speed = 0
+	r.lastTime = time.Now()
+	r.lastBytes = offset
+	r.lastReport = time.Time{}
+	r.mu.Unlock()
+}
+
+// SetTotal updates the expected size of the transfer, e.g. as segment
+// sizes become known.
+func (r *ProgressReporter) SetTotal(total int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.total = total
+	r.mu.Unlock()
+}
+
+// Add records n newly written bytes.
+func (r *ProgressReporter) Add(n int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.downloaded += n
+	now := time.Now()
+
+	if elapsed := now.Sub(r.lastTime).Seconds(); elapsed >= progressReportInterval.Seconds() {
+		current := float64(r.downloaded-r.lastBytes) / (1024 * 1024) / elapsed
+		if r.speed == 0 {
+			r.speed = current
+		} else {
+			// Smooth the speed so the ETA does not jump around
+			r.speed = 0.3*current + 0.7*r.speed
+		}
+		r.lastTime = now
+		r.lastBytes = r.downloaded
+	}
+
+	finished := r.total > 0 && r.downloaded >= r.total
+	if !finished && now.Sub(r.lastReport) < progressReportInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastReport = now
+	update := r.snapshot()
+	r.mu.Unlock()
+
+	r.report(update)
+}
+
+// Snapshot returns the current state of the transfer.
+func (r *ProgressReporter) Snapshot() ProgressUpdate {
+	if r == nil {
+		return ProgressUpdate{ETA: -1}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot()
+}
+
+func (r *ProgressReporter) snapshot() ProgressUpdate {
+	update := ProgressUpdate{
+		ItemID:       r.itemID,
+		MBDownloaded: float64(r.downloaded) / (1024 * 1024),
+		SpeedMBps:    r.speed,
+		ETA:          -1,
+	}
+
+	if r.total > 0 {
+		update.TotalMB = float64(r.total) / (1024 * 1024)
+		update.Percent = min(float64(r.downloaded)/float64(r.total)*100, 100)
+		if r.speed > 0 {
+			update.ETA = max(update.TotalMB-update.MBDownloaded, 0) / r.speed
+		}
+	}
+	return update
+}
+
+func (r *ProgressReporter) report(update ProgressUpdate) {
+	if r.itemID != "" {
+		updateItemTransfer(update)
+	}
+	if r.onUpdate != nil {
+		r.onUpdate(update)
+	}
+}
+
+// ProgressWriter prints download progress to the console and forwards the
+// written bytes to an optional per-item reporter. Writers created for a
+// download context are throttled by the global bandwidth limit.
 type ProgressWriter struct {
-	writer           io.Writer
-	total            int64
-	lastPrinted      int64
-	startTime        int64
-	lastTime         int64
-	lastBytes        int64
-	itemID           string
-	progressCallback func(itemID string, mbDownloaded, speedMBps float64)
+	ctx         context.Context
+	writer      io.Writer
+	total       int64
+	lastPrinted int64
+	startTime   int64
+	lastTime    int64
+	lastBytes   int64
+	reporter    *ProgressReporter
 }
 
 func NewProgressWriter(writer io.Writer) *ProgressWriter {
 	now := getCurrentTimeMillis()
 	return &ProgressWriter{
-		writer:           writer,
-		total:            0,
-		lastPrinted:      0,
-		startTime:        now,
-		lastTime:         now,
-		lastBytes:        0,
-		itemID:           "",
-		progressCallback: nil,
+		writer:      writer,
+		total:       0,
+		lastPrinted: 0,
+		startTime:   now,
+		lastTime:    now,
+		lastBytes:   0,
 	}
 }
 
-func NewProgressWriterWithID(writer io.Writer, itemID string) *ProgressWriter {
-	pw := NewProgressWriter(writer)
-	pw.itemID = itemID
-	return pw
-}
-
-func NewProgressWriterWithCallback(writer io.Writer, itemID string, callback func(string, float64, float64)) *ProgressWriter {
+func NewProgressWriterWithReporter(writer io.Writer, reporter *ProgressReporter) *ProgressWriter {
 	pw := NewProgressWriter(writer)
-	pw.itemID = itemID
-	pw.progressCallback = callback
+	pw.reporter = reporter
 	return pw
 }
 
-// NewProgressWriterWithIDAndGlobalCallback creates a writer that uses the global callback
-func NewProgressWriterWithIDAndGlobalCallback(writer io.Writer, itemID string) *ProgressWriter {
-	pw := NewProgressWriter(writer)
-	pw.itemID = itemID
-	// Use global callback if available
-	pw.progressCallback = GetGlobalProgressCallback()
+// newDownloadWriter wraps w for a download: progress goes to the reporter
+// carried by ctx and writes wait for the bandwidth limit.
+func newDownloadWriter(ctx context.Context, w io.Writer) *ProgressWriter {
+	pw := NewProgressWriterWithReporter(w, progressFromContext(ctx))
+	pw.ctx = ctx
 	return pw
 }
 
@@ -183,8 +333,15 @@ func getCurrentTimeMillis() int64 {
 }
 
 func (pw *ProgressWriter) Write(p []byte) (int, error) {
+	if pw.ctx != nil {
+		if err := bandwidthLimit.WaitN(pw.ctx, len(p)); err != nil {
+			return 0, err
+		}
+	}
+
 	n, err := pw.writer.Write(p)
 	pw.total += int64(n)
+	pw.reporter.Add(int64(n))
 
 	if pw.total-pw.lastPrinted >= 256*1024 {
 		mbDownloaded := float64(pw.total) / (1024 * 1024)
@@ -193,26 +350,13 @@ func (pw *ProgressWriter) Write(p []byte) (int, error) {
 		timeDiff := float64(now-pw.lastTime) / 1000.0
 		bytesDiff := float64(pw.total - pw.lastBytes)
 
-		var speedMBps float64
 		if timeDiff > 0 {
-			speedMBps = (bytesDiff / (1024 * 1024)) / timeDiff
-			SetDownloadSpeed(speedMBps)
+			speedMBps := (bytesDiff / (1024 * 1024)) / timeDiff
 			fmt.Printf("\rDownloaded: %.2f MB (%.2f MB/s)", mbDownloaded, speedMBps)
 		} else {
 			fmt.Printf("\rDownloaded: %.2f MB", mbDownloaded)
 		}
 
-		SetDownloadProgress(mbDownloaded)
-
-		if pw.itemID != "" {
-			UpdateItemProgress(pw.itemID, mbDownloaded, speedMBps)
-
-			// Call progress callback if provided
-			if pw.progressCallback != nil {
-				pw.progressCallback(pw.itemID, mbDownloaded, speedMBps)
-			}
-		}
-
 		pw.lastPrinted = pw.total
 		pw.lastTime = now
 		pw.lastBytes = pw.total
@@ -229,6 +373,12 @@ func AddToQueue(id, trackName, artistName, albumName, spotifyID string) {
 	downloadQueueLock.Lock()
 	defer downloadQueueLock.Unlock()
 
+	for i := range downloadQueue {
+		if downloadQueue[i].ID == id {
+			return
+		}
+	}
+
 	item := DownloadItem{
 		ID:         id,
 		TrackName:  trackName,
@@ -261,6 +411,8 @@ func StartDownloadItem(id string) {
 			downloadQueue[i].Status = StatusDownloading
 			downloadQueue[i].StartTime = time.Now().Unix()
 			downloadQueue[i].Progress = 0
+			downloadQueue[i].Percent = 0
+			downloadQueue[i].ETA = -1
 			break
 		}
 	}
@@ -283,6 +435,37 @@ func UpdateItemProgress(id string, progress, speed float64) {
 	}
 }
 
+// updateItemTransfer stores a reporter snapshot on the matching queue item.
+func updateItemTransfer(update ProgressUpdate) {
+	downloadQueueLock.Lock()
+	defer downloadQueueLock.Unlock()
+
+	for i := range downloadQueue {
+		if downloadQueue[i].ID == update.ItemID {
+			downloadQueue[i].Progress = update.MBDownloaded
+			downloadQueue[i].TotalSize = update.TotalMB
+			downloadQueue[i].Speed = update.SpeedMBps
+			downloadQueue[i].Percent = update.Percent
+			downloadQueue[i].ETA = update.ETA
+			break
+		}
+	}
+}
+
+// SetDownloadItemSource records the provider and quality used for an item.
+func SetDownloadItemSource(id, provider, quality string) {
+	downloadQueueLock.Lock()
+	defer downloadQueueLock.Unlock()
+
+	for i := range downloadQueue {
+		if downloadQueue[i].ID == id {
+			downloadQueue[i].Provider = provider
+			downloadQueue[i].Quality = quality
+			break
+		}
+	}
+}
+
 func GetCurrentItemID() string {
 	currentItemLock.RLock()
 	defer currentItemLock.RUnlock()
@@ -300,6 +483,9 @@ func CompleteDownloadItem(id, filePath string, finalSize float64) {
 			downloadQueue[i].FilePath = filePath
 			downloadQueue[i].Progress = finalSize
 			downloadQueue[i].TotalSize = finalSize
+			downloadQueue[i].Percent = 100
+			downloadQueue[i].ETA = 0
+			downloadQueue[i].Speed = 0
 
 			totalDownloadedLock.Lock()
 			totalDownloaded += finalSize
@@ -323,6 +509,20 @@ func FailDownloadItem(id, errorMsg string) {
 	}
 }
 
+func CancelDownloadItem(id string) {
+	downloadQueueLock.Lock()
+	defer downloadQueueLock.Unlock()
+
+	for i := range downloadQueue {
+		if downloadQueue[i].ID == id {
+			downloadQueue[i].Status = StatusSkipped
+			downloadQueue[i].EndTime = time.Now().Unix()
+			downloadQueue[i].ErrorMessage = "Cancelled"
+			break
+		}
+	}
+}
+
 func SkipDownloadItem(id, filePath string) {
 	downloadQueueLock.Lock()
 	defer downloadQueueLock.Unlock()
@@ -348,9 +548,17 @@ func GetDownloadQueue() DownloadQueueInfo {
 	downloading := isDownloading
 	downloadingLock.RUnlock()
 
-	speedLock.RLock()
-	speed := currentSpeed
-	speedLock.RUnlock()
+	speed := 0.0
+	for _, item := range downloadQueue {
+		if item.Status == StatusDownloading {
+			speed += item.Speed
+		}
+	}
+	if speed == 0 {
+		speedLock.RLock()
+		speed = currentSpeed
+		speedLock.RUnlock()
+	}
 
 	totalDownloadedLock.RLock()
 	total := totalDownloaded
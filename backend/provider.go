@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TrackRequest carries everything a provider needs to download and tag a
+// single track. Metadata fields come from Spotify.
+type TrackRequest struct {
+	SpotifyID            string `json:"spotify_id"`
+	OutputDir            string `json:"output_dir"`
+	Quality              string `json:"quality"`
+	FilenameFormat       string `json:"filename_format"`
+	PlaylistName         string `json:"playlist_name,omitempty"`
+	PlaylistOwner        string `json:"playlist_owner,omitempty"`
+	IncludeTrackNumber   bool   `json:"include_track_number"`
+	Position             int    `json:"position"`
+	UseAlbumTrackNumber  bool   `json:"use_album_track_number"`
+	TrackName            string `json:"track_name"`
+	ArtistName           string `json:"artist_name"`
+	AlbumName            string `json:"album_name"`
+	AlbumArtist          string `json:"album_artist"`
+	ReleaseDate          string `json:"release_date"`
+	CoverURL             string `json:"cover_url"`
+	EmbedMaxQualityCover bool   `json:"embed_max_quality_cover"`
+	TrackNumber          int    `json:"track_number"`
+	DiscNumber           int    `json:"disc_number"`
+	TotalTracks          int    `json:"total_tracks"`
+	TotalDiscs           int    `json:"total_discs"`
+	Copyright            string `json:"copyright"`
+	Publisher            string `json:"publisher"`
+	SpotifyURL           string `json:"spotify_url"`
+	AllowFallback        bool   `json:"allow_fallback"`
+	UseFirstArtistOnly   bool   `json:"use_first_artist_only"`
+	ISRC                 string `json:"isrc,omitempty"`
+	Explicit             bool   `json:"explicit,omitempty"`
+	Duration             int    `json:"duration,omitempty"`
+	// RequestedQuality is the quality the download was requested in, kept
+	// when a fallback step replaces Quality with the provider's own code.
+	// Templates render it as {quality} so filenames agree with folders.
+	RequestedQuality string `json:"requested_quality,omitempty"`
+}
+
+// ResolvedTrack identifies a track on a provider's side.
+type ResolvedTrack struct {
+	Provider string `json:"provider"`
+	ID       string `json:"id"`
+	URL      string `json:"url,omitempty"`
+	ISRC     string `json:"isrc,omitempty"`
+	// Match is set when the provider scored its catalogue against the request
+	Match *TrackMatch `json:"match,omitempty"`
+}
+
+// DownloadResult describes the file produced by a provider. TrackID is the
+// provider's ID of the downloaded track.
+type DownloadResult struct {
+	FilePath      string          `json:"file_path"`
+	AlreadyExists bool            `json:"already_exists"`
+	Provider      string          `json:"provider"`
+	Quality       string          `json:"quality"`
+	TrackID       string          `json:"track_id,omitempty"`
+	Match         *TrackMatch     `json:"match,omitempty"`
+	Verification  *VerifyResult   `json:"verification,omitempty"`
+	Verdict       *QualityVerdict `json:"verdict,omitempty"`
+	ReplayGain    *ReplayGain     `json:"replaygain,omitempty"`
+}
+
+// Provider is a download source. Qualities lists the supported quality codes
+// from best to worst. Resolve maps a Spotify track to the provider's
+// catalogue and Fetch downloads and tags the resolved track.
+type Provider interface {
+	Name() string
+	Qualities() []string
+	Resolve(ctx context.Context, req TrackRequest) (*ResolvedTrack, error)
+	Fetch(ctx context.Context, track *ResolvedTrack, req TrackRequest) (*DownloadResult, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+func init() {
+	RegisterProvider(NewTidalDownloader(""))
+	RegisterProvider(NewQobuzDownloader())
+	RegisterProvider(NewAmazonDownloader())
+}
+
+// RegisterProvider makes a provider available under its name, replacing any
+// provider previously registered with the same name.
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[strings.ToLower(p.Name())] = p
+}
+
+func GetProvider(name string) (Provider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported service: %s", name)
+	}
+	return p, nil
+}
+
+// ProviderNames returns the names of all registered providers in sorted order.
+func ProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DownloadWithProvider resolves and fetches a track with the given provider.
+// The fetch waits while the provider is at its concurrent download limit.
+// Tracks blacklisted as fake lossless are not fetched.
+func DownloadWithProvider(ctx context.Context, p Provider, req TrackRequest) (*DownloadResult, error) {
+	track, err := p.Resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if IsSourceBlacklisted(p.Name(), track.ID, "SpotiFLAC") {
+		return nil, fmt.Errorf("%s track %s is blacklisted as fake lossless", p.Name(), track.ID)
+	}
+
+	release, err := acquireDownloadSlot(ctx, p.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	result, err := p.Fetch(ctx, track, req)
+	if err != nil {
+		return nil, err
+	}
+	if result.TrackID == "" {
+		result.TrackID = track.ID
+	}
+	if result.Match == nil {
+		result.Match = track.Match
+	}
+	return result, nil
+}
+
+// lookupISRCAsync fetches the ISRC for a Spotify track URL in the background.
+// Receiving from the returned channel yields an empty string when the lookup
+// fails, ctx is done or no URL is given.
+func lookupISRCAsync(ctx context.Context, spotifyURL string) <-chan string {
+	isrcChan := make(chan string, 1)
+	if spotifyURL == "" {
+		close(isrcChan)
+		return isrcChan
+	}
+
+	go func() {
+		var isrc string
+		parts := strings.Split(spotifyURL, "/")
+		if len(parts) > 0 {
+			sID := strings.Split(parts[len(parts)-1], "?")[0]
+			if sID != "" {
+				client := GetSongLinkClient()
+				if val, err := client.GetISRC(ctx, sID); err == nil {
+					isrc = val
+				}
+			}
+		}
+		isrcChan <- isrc
+	}()
+	return isrcChan
+}
+
+func metadataFromRequest(req TrackRequest, isrc string) Metadata {
+	trackNumberToEmbed := req.TrackNumber
+	if trackNumberToEmbed == 0 {
+		trackNumberToEmbed = 1
+	}
+
+	return Metadata{
+		Title:       req.TrackName,
+		Artist:      req.ArtistName,
+		Album:       req.AlbumName,
+		AlbumArtist: req.AlbumArtist,
+		Date:        req.ReleaseDate,
+		TrackNumber: trackNumberToEmbed,
+		TotalTracks: req.TotalTracks,
+		DiscNumber:  req.DiscNumber,
+		TotalDiscs:  req.TotalDiscs,
+		URL:         req.SpotifyURL,
+		Copyright:   req.Copyright,
+		Publisher:   req.Publisher,
+		Description: "https://github.com/afkarxyz/SpotiFLAC",
+		ISRC:        isrc,
+	}
+}
@@ -1,14 +1,16 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -40,6 +42,7 @@ type QobuzTrack struct {
 	MaximumBitDepth     int     `json:"maximum_bit_depth"`
 	MaximumSamplingRate float64 `json:"maximum_sampling_rate"`
 	Hires               bool    `json:"hires"`
+	ParentalWarning     bool    `json:"parental_warning"`
 	HiresStreamable     bool    `json:"hires_streamable"`
 	ReleaseDateOriginal string  `json:"release_date_original"`
 	Performer           struct {
@@ -64,24 +67,43 @@ type QobuzTrack struct {
 	} `json:"album"`
 }
 
+// errTrackNotFound is returned when a service has no match for a track.
+var errTrackNotFound = errors.New("track not found")
+
 type QobuzStreamResponse struct {
 	URL string `json:"url"`
 }
 
 func NewQobuzDownloader() *QobuzDownloader {
 	return &QobuzDownloader{
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
-		appID: "798273057",
+		client: newProviderClient("qobuz", 60*time.Second),
+		appID:  "798273057",
+	}
+}
+
+func (q *QobuzDownloader) searchByISRC(ctx context.Context, isrc string) (*QobuzTrack, error) {
+	tracks, err := q.searchTracks(ctx, isrc, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("%w for ISRC: %s", errTrackNotFound, isrc)
 	}
+	return &tracks[0], nil
 }
 
-func (q *QobuzDownloader) searchByISRC(isrc string) (*QobuzTrack, error) {
+// searchTracks returns up to limit tracks matching query, which may be an
+// ISRC or free text.
+func (q *QobuzDownloader) searchTracks(ctx context.Context, query string, limit int) ([]QobuzTrack, error) {
 	apiBase := "https://www.qobuz.com/api.json/0.2/track/search?query="
-	url := fmt.Sprintf("%s%s&limit=1&app_id=%s", apiBase, isrc, q.appID)
+	searchURL := fmt.Sprintf("%s%s&limit=%d&app_id=%s", apiBase, url.QueryEscape(query), limit, q.appID)
 
-	resp, err := q.client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := q.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search track: %w", err)
 	}
@@ -111,11 +133,7 @@ func (q *QobuzDownloader) searchByISRC(isrc string) (*QobuzTrack, error) {
 		return nil, fmt.Errorf("failed to decode response: %w (response: %s)", err, bodyStr)
 	}
 
-	if len(searchResp.Tracks.Items) == 0 {
-		return nil, fmt.Errorf("track not found for ISRC: %s", isrc)
-	}
-
-	return &searchResp.Tracks.Items[0], nil
+	return searchResp.Tracks.Items, nil
 }
 
 func decodeXOR(data []byte) string {
@@ -142,14 +160,14 @@ func (q *QobuzDownloader) mapJumoQuality(quality string) int {
 	}
 }
 
-func (q *QobuzDownloader) DownloadFromJumo(trackID int64, quality string) (string, error) {
+func (q *QobuzDownloader) DownloadFromJumo(ctx context.Context, trackID int64, quality string) (string, error) {
 	formatID := q.mapJumoQuality(quality)
 	region := "US"
 	url := fmt.Sprintf("https://jumo-dl.pages.dev/get?track_id=%d&format_id=%d&region=%s", trackID, formatID, region)
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := newProviderClient("qobuz", 30*time.Second)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -191,9 +209,13 @@ func (q *QobuzDownloader) DownloadFromJumo(trackID int64, quality string) (strin
 	return "", fmt.Errorf("URL not found in Jumo response")
 }
 
-func (q *QobuzDownloader) DownloadFromStandard(apiBase string, trackID int64, quality string) (string, error) {
+func (q *QobuzDownloader) DownloadFromStandard(ctx context.Context, apiBase string, trackID int64, quality string) (string, error) {
 	apiURL := fmt.Sprintf("%s%d&quality=%s", apiBase, trackID, quality)
-	resp, err := q.client.Get(apiURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := q.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -229,7 +251,8 @@ func (q *QobuzDownloader) DownloadFromStandard(apiBase string, trackID int64, qu
 	return "", fmt.Errorf("invalid response")
 }
 
-func (q *QobuzDownloader) GetDownloadURL(trackID int64, quality string, allowFallback bool) (string, error) {
+// GetDownloadURL returns a stream URL and the quality code it was obtained with.
+func (q *QobuzDownloader) GetDownloadURL(ctx context.Context, trackID int64, quality string, allowFallback bool) (string, string, error) {
 	qualityCode := quality
 	if qualityCode == "" || qualityCode == "5" {
 		qualityCode = "6"
@@ -244,27 +267,27 @@ func (q *QobuzDownloader) GetDownloadURL(trackID int64, quality string, allowFal
 	}
 
 	downloadFunc := func(qual string) (string, error) {
-		type Provider struct {
+		type streamAPI struct {
 			Name string
 			Func func() (string, error)
 		}
 
-		var providers []Provider
+		var providers []streamAPI
 
 		for _, api := range standardAPIs {
 			currentAPI := api
-			providers = append(providers, Provider{
+			providers = append(providers, streamAPI{
 				Name: "Standard(" + currentAPI + ")",
 				Func: func() (string, error) {
-					return q.DownloadFromStandard(currentAPI, trackID, qual)
+					return q.DownloadFromStandard(ctx, currentAPI, trackID, qual)
 				},
 			})
 		}
 
-		providers = append(providers, Provider{
+		providers = append(providers, streamAPI{
 			Name: "Jumo-DL",
 			Func: func() (string, error) {
-				return q.DownloadFromJumo(trackID, qual)
+				return q.DownloadFromJumo(ctx, trackID, qual)
 			},
 		})
 
@@ -273,6 +296,9 @@ func (q *QobuzDownloader) GetDownloadURL(trackID int64, quality string, allowFal
 
 		var lastErr error
 		for _, p := range providers {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
 
 			fmt.Printf("Trying Provider: %s (Quality: %s)...\n", p.Name, qual)
 
@@ -290,7 +316,11 @@ func (q *QobuzDownloader) GetDownloadURL(trackID int64, quality string, allowFal
 
 	url, err := downloadFunc(qualityCode)
 	if err == nil {
-		return url, nil
+		return url, qualityCode, nil
+	}
+
+	if ctx.Err() != nil {
+		return "", "", ctx.Err()
 	}
 
 	currentQuality := qualityCode
@@ -300,7 +330,7 @@ func (q *QobuzDownloader) GetDownloadURL(trackID int64, quality string, allowFal
 		url, err := downloadFunc("7")
 		if err == nil {
 			fmt.Println("✓ Success with fallback quality 7")
-			return url, nil
+			return url, "7", nil
 		}
 
 		currentQuality = "7"
@@ -311,47 +341,21 @@ func (q *QobuzDownloader) GetDownloadURL(trackID int64, quality string, allowFal
 		url, err := downloadFunc("6")
 		if err == nil {
 			fmt.Println("✓ Success with fallback quality 6")
-			return url, nil
+			return url, "6", nil
 		}
 	}
 
-	return "", fmt.Errorf("all APIs and fallbacks failed. Last error: %v", err)
+	return "", "", fmt.Errorf("all APIs and fallbacks failed. Last error: %v", err)
 }
 
-func (q *QobuzDownloader) DownloadFile(url, filepath string) error {
+func (q *QobuzDownloader) DownloadFile(ctx context.Context, url, filepath string) error {
 	fmt.Println("Starting file download...")
 
-	downloadClient := &http.Client{
-		Timeout: 5 * time.Minute,
-	}
-
-	resp, err := downloadClient.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
-	}
-
 	fmt.Printf("Creating file: %s\n", filepath)
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer out.Close()
-
 	fmt.Println("Downloading...")
 
-	pw := NewProgressWriter(out)
-	_, err = io.Copy(pw, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
-	return nil
+	_, err := downloadToFile(ctx, newTransferClient(), url, filepath)
+	return err
 }
 
 func (q *QobuzDownloader) DownloadCoverArt(coverURL, filepath string) error {
@@ -379,111 +383,138 @@ func (q *QobuzDownloader) DownloadCoverArt(coverURL, filepath string) error {
 	return err
 }
 
-func buildQobuzFilename(title, artist, album, albumArtist, releaseDate string, trackNumber, discNumber int, format string, includeTrackNumber bool, position int, useAlbumTrackNumber bool) string {
-	var filename string
+func (q *QobuzDownloader) Name() string {
+	return "qobuz"
+}
+
+func (q *QobuzDownloader) Qualities() []string {
+	return []string{"27", "7", "6"}
+}
 
-	numberToUse := position
-	if useAlbumTrackNumber && trackNumber > 0 {
-		numberToUse = trackNumber
+func (q *QobuzDownloader) Resolve(ctx context.Context, req TrackRequest) (*ResolvedTrack, error) {
+	if req.SpotifyID == "" {
+		return nil, fmt.Errorf("spotify ID is required for Qobuz download")
 	}
 
-	year := ""
-	if len(releaseDate) >= 4 {
-		year = releaseDate[:4]
+	songlinkClient := GetSongLinkClient()
+	isrc, err := songlinkClient.GetISRC(ctx, req.SpotifyID)
+	if err != nil && req.TrackName == "" {
+		return nil, fmt.Errorf("failed to get ISRC: %v", err)
+	}
+	if err != nil {
+		fmt.Printf("⚠ ISRC lookup failed, searching by title: %v\n", err)
 	}
 
-	if strings.Contains(format, "{") {
-		filename = format
-		filename = strings.ReplaceAll(filename, "{title}", title)
-		filename = strings.ReplaceAll(filename, "{artist}", artist)
-		filename = strings.ReplaceAll(filename, "{album}", album)
-		filename = strings.ReplaceAll(filename, "{album_artist}", albumArtist)
-		filename = strings.ReplaceAll(filename, "{year}", year)
+	matchReq := req
+	if matchReq.ISRC == "" {
+		matchReq.ISRC = isrc
+	}
 
-		if discNumber > 0 {
-			filename = strings.ReplaceAll(filename, "{disc}", fmt.Sprintf("%d", discNumber))
-		} else {
-			filename = strings.ReplaceAll(filename, "{disc}", "")
+	var candidates []QobuzTrack
+	if isrc != "" {
+		fmt.Printf("Fetching track info for ISRC: %s\n", isrc)
+		if candidates, err = q.searchTracks(ctx, isrc, 10); err != nil {
+			return nil, err
 		}
+	}
 
-		if numberToUse > 0 {
-			filename = strings.ReplaceAll(filename, "{track}", fmt.Sprintf("%02d", numberToUse))
-		} else {
-
-			filename = regexp.MustCompile(`\{track\}\.\s*`).ReplaceAllString(filename, "")
-			filename = regexp.MustCompile(`\{track\}\s*-\s*`).ReplaceAllString(filename, "")
-			filename = regexp.MustCompile(`\{track\}\s*`).ReplaceAllString(filename, "")
-		}
-	} else {
-
-		switch format {
-		case "artist-title":
-			filename = fmt.Sprintf("%s - %s", artist, title)
-		case "title":
-			filename = title
-		default:
-			filename = fmt.Sprintf("%s - %s", title, artist)
+	track, match, err := q.bestCandidate(matchReq, candidates)
+	if err != nil && req.TrackName != "" {
+		// The ISRC may belong to another release; fall back to a text search
+		query := strings.TrimSpace(req.TrackName + " " + req.ArtistName)
+		if more, searchErr := q.searchTracks(ctx, query, 10); searchErr == nil && len(more) > 0 {
+			candidates = append(candidates, more...)
+			track, match, err = q.bestCandidate(matchReq, candidates)
 		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		if includeTrackNumber && position > 0 {
-			filename = fmt.Sprintf("%02d. %s", numberToUse, filename)
-		}
+	qualityInfo := "Standard"
+	if track.Hires {
+		qualityInfo = fmt.Sprintf("Hi-Res (%d-bit / %.1f kHz)", track.MaximumBitDepth, track.MaximumSamplingRate)
 	}
+	fmt.Printf("Quality: %s\n", qualityInfo)
 
-	return filename + ".flac"
+	if track.ISRC != "" {
+		isrc = track.ISRC
+	}
+	return &ResolvedTrack{
+		Provider: q.Name(),
+		ID:       fmt.Sprintf("%d", track.ID),
+		ISRC:     isrc,
+		Match:    match,
+	}, nil
 }
 
-func (q *QobuzDownloader) DownloadTrack(spotifyID, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool, useFirstArtistOnly bool) (string, error) {
-	var deezerISRC string
-	if spotifyID != "" {
-		songlinkClient := NewSongLinkClient()
-		isrc, err := songlinkClient.GetISRC(spotifyID)
-		if err != nil {
-			return "", fmt.Errorf("failed to get ISRC: %v", err)
+// bestCandidate picks the search result that best matches the request.
+func (q *QobuzDownloader) bestCandidate(req TrackRequest, tracks []QobuzTrack) (*QobuzTrack, *TrackMatch, error) {
+	candidates := make([]MatchCandidate, len(tracks))
+	for i, t := range tracks {
+		candidates[i] = MatchCandidate{
+			ID:         fmt.Sprintf("%d", t.ID),
+			Title:      t.Title,
+			Version:    t.Version,
+			Artists:    []string{t.Performer.Name},
+			Album:      t.Album.Title,
+			ISRC:       t.ISRC,
+			DurationMS: t.Duration * 1000,
 		}
-		deezerISRC = isrc
-	} else {
-		return "", fmt.Errorf("spotify ID is required for Qobuz download")
 	}
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("%w on Qobuz", errTrackNotFound)
+	}
+
+	i, match, err := BestMatch(q.Name(), req, candidates)
+	if err != nil {
+		return nil, match, err
+	}
+	return &tracks[i], match, nil
+}
 
-	return q.DownloadTrackWithISRC(deezerISRC, spotifyID, outputDir, quality, filenameFormat, includeTrackNumber, position, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, useAlbumTrackNumber, spotifyCoverURL, embedMaxQualityCover, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks, spotifyTotalDiscs, spotifyCopyright, spotifyPublisher, spotifyURL, allowFallback, useFirstArtistOnly)
+func (q *QobuzDownloader) Fetch(ctx context.Context, track *ResolvedTrack, req TrackRequest) (*DownloadResult, error) {
+	var trackID int64
+	if _, err := fmt.Sscanf(track.ID, "%d", &trackID); err != nil {
+		return nil, fmt.Errorf("invalid Qobuz track ID: %s", track.ID)
+	}
+	return q.downloadTrackByID(ctx, trackID, track.ISRC, req)
 }
 
-func (q *QobuzDownloader) DownloadTrackWithISRC(deezerISRC, spotifyID, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool, useFirstArtistOnly bool) (string, error) {
+func (q *QobuzDownloader) DownloadTrackWithISRC(ctx context.Context, deezerISRC string, req TrackRequest) (*DownloadResult, error) {
 	fmt.Printf("Fetching track info for ISRC: %s\n", deezerISRC)
 
+	track, err := q.searchByISRC(ctx, deezerISRC)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.downloadTrackByID(ctx, track.ID, deezerISRC, req)
+}
+
+func (q *QobuzDownloader) downloadTrackByID(ctx context.Context, trackID int64, isrc string, req TrackRequest) (*DownloadResult, error) {
+	outputDir := req.OutputDir
 	if outputDir != "." {
 		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return "", fmt.Errorf("failed to create output directory: %w", err)
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
 		}
 	}
 
-	track, err := q.searchByISRC(deezerISRC)
-	if err != nil {
-		return "", err
-	}
-
-	artists := spotifyArtistName
-	trackTitle := spotifyTrackName
-	albumTitle := spotifyAlbumName
+	artists := req.ArtistName
+	trackTitle := req.TrackName
+	albumTitle := req.AlbumName
 
 	fmt.Printf("Found track: %s - %s\n", artists, trackTitle)
 	fmt.Printf("Album: %s\n", albumTitle)
 
-	qualityInfo := "Standard"
-	if track.Hires {
-		qualityInfo = fmt.Sprintf("Hi-Res (%d-bit / %.1f kHz)", track.MaximumBitDepth, track.MaximumSamplingRate)
-	}
-	fmt.Printf("Quality: %s\n", qualityInfo)
-
 	fmt.Println("Getting download URL...")
-	downloadURL, err := q.GetDownloadURL(track.ID, quality, allowFallback)
+	downloadURL, quality, err := q.GetDownloadURL(ctx, trackID, req.Quality, req.AllowFallback)
 	if err != nil {
-		return "", fmt.Errorf("failed to get download URL: %w", err)
+		return nil, fmt.Errorf("failed to get download URL: %w", err)
 	}
 
 	if downloadURL == "" {
-		return "", fmt.Errorf("received empty download URL")
+		return nil, fmt.Errorf("received empty download URL")
 	}
 
 	urlPreview := downloadURL
@@ -492,38 +523,31 @@ func (q *QobuzDownloader) DownloadTrackWithISRC(deezerISRC, spotifyID, outputDir
 	}
 	fmt.Printf("Download URL obtained: %s\n", urlPreview)
 
-	safeArtist := sanitizeFilename(artists)
-	safeAlbumArtist := sanitizeFilename(spotifyAlbumArtist)
-
-	if useFirstArtistOnly {
-		safeArtist = sanitizeFilename(GetFirstArtist(artists))
-		safeAlbumArtist = sanitizeFilename(GetFirstArtist(spotifyAlbumArtist))
+	filenameData := filenameDataForRequest(req)
+	if filenameData.ISRC == "" {
+		filenameData.ISRC = isrc
 	}
-
-	safeTitle := sanitizeFilename(trackTitle)
-	safeAlbum := sanitizeFilename(albumTitle)
-
-	filename := buildQobuzFilename(safeTitle, safeArtist, safeAlbum, safeAlbumArtist, spotifyReleaseDate, spotifyTrackNumber, spotifyDiscNumber, filenameFormat, includeTrackNumber, position, useAlbumTrackNumber)
+	filename := BuildFilename(req.FilenameFormat, filenameData, req.IncludeTrackNumber, ".flac")
 	filepath := filepath.Join(outputDir, filename)
 
 	if fileInfo, err := os.Stat(filepath); err == nil && fileInfo.Size() > 0 {
 		fmt.Printf("File already exists: %s (%.2f MB)\n", filepath, float64(fileInfo.Size())/(1024*1024))
-		return "EXISTS:" + filepath, nil
+		return &DownloadResult{FilePath: filepath, AlreadyExists: true, Provider: q.Name()}, nil
 	}
 
 	fmt.Printf("Downloading FLAC file to: %s\n", filepath)
-	if err := q.DownloadFile(downloadURL, filepath); err != nil {
-		return "", fmt.Errorf("failed to download file: %w", err)
+	if err := q.DownloadFile(ctx, downloadURL, filepath); err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
 	}
 
 	fmt.Printf("Downloaded: %s\n", filepath)
 
 	coverPath := ""
 
-	if spotifyCoverURL != "" {
+	if req.CoverURL != "" {
 		coverPath = filepath + ".cover.jpg"
 		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
+		if err := coverClient.DownloadCoverToPath(req.CoverURL, coverPath, req.EmbedMaxQualityCover); err != nil {
 			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
 			coverPath = ""
 		} else {
@@ -534,32 +558,10 @@ func (q *QobuzDownloader) DownloadTrackWithISRC(deezerISRC, spotifyID, outputDir
 
 	fmt.Println("Embedding metadata and cover art...")
 
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
-	}
-
-	metadata := Metadata{
-		Title:       trackTitle,
-		Artist:      artists,
-		Album:       albumTitle,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
-		ISRC:        deezerISRC,
-	}
-
-	if err := EmbedMetadata(filepath, metadata, coverPath); err != nil {
-		return "", fmt.Errorf("failed to embed metadata: %w", err)
+	if err := EmbedMetadata(filepath, metadataFromRequest(req, isrc), coverPath); err != nil {
+		return nil, fmt.Errorf("failed to embed metadata: %w", err)
 	}
 
 	fmt.Println("Metadata embedded successfully!")
-	return filepath, nil
+	return &DownloadResult{FilePath: filepath, Provider: q.Name(), Quality: quality}, nil
 }
@@ -0,0 +1,357 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenBucket limits a rate to rate tokens per second with bursts of up to
+// burst tokens. A rate of 0 disables the limit. The rate can be changed while
+// the bucket is in use.
+type TokenBucket struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	changed chan struct{}
+}
+
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	b := &TokenBucket{}
+	b.SetRate(rate, burst)
+	return b
+}
+
+// SetRate changes the rate and burst. The bucket starts full and callers
+// already waiting wait again at the new rate.
+func (b *TokenBucket) SetRate(rate, burst float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = rate
+	b.burst = max(burst, 1)
+	b.tokens = b.burst
+	b.last = time.Now()
+	if b.changed != nil {
+		close(b.changed)
+	}
+	b.changed = make(chan struct{})
+}
+
+// WaitN takes n tokens, blocking until they are available or ctx is done.
+// Tokens are reserved up front, so concurrent callers are served in order.
+// A caller whose ctx is done gives its reservation back.
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	for {
+		b.mu.Lock()
+		if b.rate <= 0 {
+			b.mu.Unlock()
+			return nil
+		}
+
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		b.tokens -= float64(n)
+
+		var wait time.Duration
+		if b.tokens < 0 {
+			wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			return nil
+		case <-changed:
+			// SetRate refilled the bucket, which dropped the reservation
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			b.mu.Lock()
+			if b.changed == changed {
+				b.tokens = min(b.burst, b.tokens+float64(n))
+			}
+			b.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// limitedReadSize caps a single read of a limitedReader, so concurrent
+// transfers share the limit in small steps.
+const limitedReadSize = 32 * 1024
+
+// limitedReader waits for tokens from bucket for every byte read.
+type limitedReader struct {
+	ctx    context.Context
+	r      io.Reader
+	bucket *TokenBucket
+}
+
+func newLimitedReader(ctx context.Context, r io.Reader, bucket *TokenBucket) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, bucket: bucket}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedReadSize {
+		p = p[:limitedReadSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.bucket.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// concurrencyLimit caps how many callers hold a slot at once. A limit of 0
+// means unlimited.
+type concurrencyLimit struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	changed chan struct{}
+}
+
+func newConcurrencyLimit() *concurrencyLimit {
+	return &concurrencyLimit{changed: make(chan struct{})}
+}
+
+// broadcast wakes all waiters. The caller must hold mu.
+func (l *concurrencyLimit) broadcast() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *concurrencyLimit) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.limit <= 0 || l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *concurrencyLimit) release() {
+	l.mu.Lock()
+	l.active--
+	l.broadcast()
+	l.mu.Unlock()
+}
+
+func (l *concurrencyLimit) setLimit(limit int) {
+	l.mu.Lock()
+	l.limit = limit
+	l.broadcast()
+	l.mu.Unlock()
+}
+
+// ProviderLimits are the limits applied to one provider. Zero values mean
+// unlimited.
+type ProviderLimits struct {
+	MaxConcurrent     int `json:"maxConcurrent"`
+	RequestsPerMinute int `json:"requestsPerMinute"`
+}
+
+type providerLimiter struct {
+	downloads *concurrencyLimit
+	requests  *TokenBucket
+}
+
+var (
+	// bandwidthLimit throttles all file downloads, in bytes per second
+	bandwidthLimit = NewTokenBucket(0, 0)
+
+	providerLimitersMu sync.Mutex
+	providerLimiters   = map[string]*providerLimiter{}
+)
+
+func getProviderLimiter(name string) *providerLimiter {
+	name = strings.ToLower(name)
+	providerLimitersMu.Lock()
+	defer providerLimitersMu.Unlock()
+	l, ok := providerLimiters[name]
+	if !ok {
+		l = &providerLimiter{downloads: newConcurrencyLimit(), requests: NewTokenBucket(0, 0)}
+		providerLimiters[name] = l
+	}
+	return l
+}
+
+func (l *providerLimiter) set(limits ProviderLimits) {
+	l.downloads.setLimit(limits.MaxConcurrent)
+	// Allow a few requests back to back, then space them out evenly
+	rpm := float64(limits.RequestsPerMinute)
+	l.requests.SetRate(rpm/60, max(1, rpm/10))
+}
+
+// ApplyRateLimits configures the bandwidth and provider limits from the
+// "bandwidthLimit" (KB/s) and "providerLimits" settings, e.g.
+// {"tidal": {"maxConcurrent": 2, "requestsPerMinute": 60}}. Providers that
+// are not listed are unlimited.
+func ApplyRateLimits(settings map[string]interface{}) {
+	kbps, _ := settings["bandwidthLimit"].(float64)
+	if kbps > 0 {
+		// One second of traffic may be sent in a burst
+		bandwidthLimit.SetRate(kbps*1024, kbps*1024)
+	} else {
+		bandwidthLimit.SetRate(0, 0)
+	}
+
+	configured, _ := settings["providerLimits"].(map[string]interface{})
+	limits := map[string]ProviderLimits{}
+	for _, name := range ProviderNames() {
+		limits[name] = ProviderLimits{}
+	}
+	for name, value := range configured {
+		var l ProviderLimits
+		if m, ok := value.(map[string]interface{}); ok {
+			if v, ok := m["maxConcurrent"].(float64); ok {
+				l.MaxConcurrent = int(v)
+			}
+			if v, ok := m["requestsPerMinute"].(float64); ok {
+				l.RequestsPerMinute = int(v)
+			}
+		}
+		limits[strings.ToLower(name)] = l
+	}
+	for name, l := range limits {
+		getProviderLimiter(name).set(l)
+	}
+
+	if kbps > 0 || len(configured) > 0 {
+		bandwidth := "unlimited"
+		if kbps > 0 {
+			bandwidth = fmt.Sprintf("%.0f KB/s", kbps)
+		}
+		fmt.Printf("Rate limits: bandwidth %s, %d provider limit(s)\n", bandwidth, len(configured))
+	}
+}
+
+// providerTransport waits for the provider's request limit before every
+// request.
+type providerTransport struct {
+	provider string
+	base     http.RoundTripper
+}
+
+func (t *providerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := getProviderLimiter(t.provider).requests.WaitN(req.Context(), 1); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newProviderClient returns an HTTP client whose requests count against the
+// provider's requests-per-minute limit. File transfers use newTransferClient
+// instead, so segmented downloads are not throttled per segment.
+func newProviderClient(provider string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &providerTransport{provider: provider, base: http.DefaultTransport},
+	}
+}
+
+const (
+	// transferHeaderTimeout bounds the wait for a file server's response
+	// headers.
+	transferHeaderTimeout = 30 * time.Second
+	// transferIdleTimeout is how long a read of a file transfer may wait for
+	// data before the request is aborted and resumed.
+	transferIdleTimeout = 60 * time.Second
+)
+
+var transferBaseTransport = func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = transferHeaderTimeout
+	return transport
+}()
+
+// newTransferClient returns an HTTP client for file transfers. It has no
+// overall timeout, as a throttled download of a large file may take longer
+// than any fixed limit. Transfers end when their context is cancelled or when
+// the server stalls on the headers or the body.
+func newTransferClient() *http.Client {
+	return &http.Client{Transport: &idleTimeoutTransport{base: transferBaseTransport}}
+}
+
+// idleTimeoutTransport aborts a response whose body stops delivering data.
+// Only the time spent inside Read counts, so waiting for bandwidth between
+// reads does not.
+type idleTimeoutTransport struct {
+	base http.RoundTripper
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	body := &idleTimeoutBody{ReadCloser: resp.Body, cancel: cancel}
+	body.timer = time.AfterFunc(transferIdleTimeout, func() {
+		body.stalled.Store(true)
+		cancel()
+	})
+	body.timer.Stop()
+	resp.Body = body
+	return resp, nil
+}
+
+type idleTimeoutBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(transferIdleTimeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && b.stalled.Load() {
+		err = fmt.Errorf("no data received for %s", transferIdleTimeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// acquireDownloadSlot blocks until the provider is below its concurrent
+// download limit. The returned function releases the slot.
+func acquireDownloadSlot(ctx context.Context, provider string) (func(), error) {
+	l := getProviderLimiter(provider)
+	if err := l.downloads.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return l.downloads.release, nil
+}
@@ -0,0 +1,255 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	id3v2 "github.com/bogem/id3v2/v2"
+	"github.com/go-flac/flacvorbis"
+	"github.com/go-flac/go-flac"
+)
+
+// replayGainReference is the ReplayGain 2.0 target loudness in LUFS.
+const replayGainReference = -18.0
+
+// ReplayGain holds the gains (dB) that bring a track or album to the
+// ReplayGain 2.0 reference and their linear peaks. Album values are only set
+// when HasAlbum is true.
+type ReplayGain struct {
+	TrackGain float64 `json:"track_gain"`
+	TrackPeak float64 `json:"track_peak"`
+	HasAlbum  bool    `json:"has_album"`
+	AlbumGain float64 `json:"album_gain,omitempty"`
+	AlbumPeak float64 `json:"album_peak,omitempty"`
+}
+
+// NewReplayGain computes ReplayGain from a track's loudness and, if album is
+// not nil, the album's.
+func NewReplayGain(track, album *Loudness) *ReplayGain {
+	rg := &ReplayGain{
+		TrackGain: round2(replayGainReference - track.Integrated),
+		TrackPeak: math.Round(track.peak*1e6) / 1e6,
+	}
+	if album != nil {
+		rg.HasAlbum = true
+		rg.AlbumGain = round2(replayGainReference - album.Integrated)
+		rg.AlbumPeak = math.Round(album.peak*1e6) / 1e6
+	}
+	return rg
+}
+
+// Tags returns the ReplayGain tags in the form foobar2000 and most players
+// read them.
+func (rg *ReplayGain) Tags() [][2]string {
+	tags := [][2]string{
+		{"REPLAYGAIN_TRACK_GAIN", fmt.Sprintf("%.2f dB", rg.TrackGain)},
+		{"REPLAYGAIN_TRACK_PEAK", fmt.Sprintf("%.6f", rg.TrackPeak)},
+	}
+	if rg.HasAlbum {
+		tags = append(tags,
+			[2]string{"REPLAYGAIN_ALBUM_GAIN", fmt.Sprintf("%.2f dB", rg.AlbumGain)},
+			[2]string{"REPLAYGAIN_ALBUM_PEAK", fmt.Sprintf("%.6f", rg.AlbumPeak)},
+		)
+	}
+	return tags
+}
+
+// setReplayGainTag fills rg from a tag read back from a file.
+func setReplayGainTag(rg *ReplayGain, key, value string) {
+	number, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "dB")), 64)
+	if err != nil {
+		return
+	}
+	switch strings.ToUpper(key) {
+	case "REPLAYGAIN_TRACK_GAIN":
+		rg.TrackGain = number
+	case "REPLAYGAIN_TRACK_PEAK":
+		rg.TrackPeak = number
+	case "REPLAYGAIN_ALBUM_GAIN":
+		rg.AlbumGain = number
+		rg.HasAlbum = true
+	case "REPLAYGAIN_ALBUM_PEAK":
+		rg.AlbumPeak = number
+	}
+}
+
+// WriteReplayGain stores ReplayGain tags in a FLAC, MP3 or M4A file. Other
+// tags, including album values that rg does not carry, are kept.
+func WriteReplayGain(path string, rg *ReplayGain) error {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".flac":
+		return writeFLACReplayGain(path, rg)
+	case ".mp3":
+		return writeMP3ReplayGain(path, rg)
+	case ".m4a":
+		return writeM4AFreeform(path, rg.Tags())
+	default:
+		return fmt.Errorf("unsupported file format for ReplayGain: %s", ext)
+	}
+}
+
+func writeFLACReplayGain(path string, rg *ReplayGain) error {
+	f, err := flac.ParseFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+
+	tags := rg.Tags()
+	replaced := map[string]bool{}
+	for _, tag := range tags {
+		replaced[tag[0]] = true
+	}
+
+	var cmtIdx = -1
+	cmt := flacvorbis.New()
+	for idx, block := range f.Meta {
+		if block.Type != flac.VorbisComment {
+			continue
+		}
+		cmtIdx = idx
+		if existing, err := flacvorbis.ParseFromMetaDataBlock(*block); err == nil {
+			cmt.Vendor = existing.Vendor
+			for _, comment := range existing.Comments {
+				name, _, _ := strings.Cut(comment, "=")
+				if !replaced[strings.ToUpper(name)] {
+					cmt.Comments = append(cmt.Comments, comment)
+				}
+			}
+		}
+		break
+	}
+
+	for _, tag := range tags {
+		_ = cmt.Add(tag[0], tag[1])
+	}
+
+	cmtBlock := cmt.Marshal()
+	if cmtIdx < 0 {
+		f.Meta = append(f.Meta, &cmtBlock)
+	} else {
+		f.Meta[cmtIdx] = &cmtBlock
+	}
+
+	if err := f.Save(path); err != nil {
+		return fmt.Errorf("failed to save FLAC file: %w", err)
+	}
+	return nil
+}
+
+func writeMP3ReplayGain(path string, rg *ReplayGain) error {
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return fmt.Errorf("failed to open MP3 file: %w", err)
+	}
+	defer tag.Close()
+
+	addReplayGainFrames(tag, rg)
+
+	if err := tag.Save(); err != nil {
+		return fmt.Errorf("failed to save MP3 tags: %w", err)
+	}
+	return nil
+}
+
+// addReplayGainFrames replaces the ReplayGain TXXX frames of an ID3v2 tag.
+func addReplayGainFrames(tag *id3v2.Tag, rg *ReplayGain) {
+	tags := rg.Tags()
+	replaced := map[string]bool{}
+	for _, t := range tags {
+		replaced[t[0]] = true
+	}
+
+	frames := tag.GetFrames("TXXX")
+	tag.DeleteFrames("TXXX")
+	for _, frame := range frames {
+		if udtf, ok := frame.(id3v2.UserDefinedTextFrame); ok && replaced[strings.ToUpper(udtf.Description)] {
+			continue
+		}
+		tag.AddFrame("TXXX", frame)
+	}
+	for _, t := range tags {
+		tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			Description: t[0],
+			Value:       t[1],
+		})
+	}
+}
+
+// ApplyTrackReplayGain measures a file and writes its track ReplayGain tags.
+func ApplyTrackReplayGain(ctx context.Context, path string) (*Loudness, *ReplayGain, error) {
+	loudness, err := MeasureLoudness(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rg := NewReplayGain(loudness, nil)
+	if err := WriteReplayGain(path, rg); err != nil {
+		return nil, nil, err
+	}
+	return loudness, rg, nil
+}
+
+// AlbumReplayGainResult is the outcome of tagging one track of an album.
+type AlbumReplayGainResult struct {
+	Path       string      `json:"path"`
+	Loudness   *Loudness   `json:"loudness,omitempty"`
+	ReplayGain *ReplayGain `json:"replaygain,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// ApplyAlbumReplayGain measures every track of an album and writes track and
+// album ReplayGain tags to each. Tracks that cannot be measured are reported
+// and left out of the album loudness.
+func ApplyAlbumReplayGain(ctx context.Context, paths []string) ([]AlbumReplayGainResult, *Loudness) {
+	results := make([]AlbumReplayGainResult, len(paths))
+	var measured []*Loudness
+	for i, path := range paths {
+		results[i].Path = path
+		loudness, err := MeasureLoudness(ctx, path)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Loudness = loudness
+		measured = append(measured, loudness)
+	}
+	if len(measured) == 0 {
+		return results, nil
+	}
+
+	album := AlbumLoudness(measured)
+	for i := range results {
+		if results[i].Loudness == nil {
+			continue
+		}
+		rg := NewReplayGain(results[i].Loudness, album)
+		if err := WriteReplayGain(results[i].Path, rg); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].ReplayGain = rg
+	}
+	return results, album
+}
+
+// tagReplayGain writes track ReplayGain tags to a download when the
+// "replayGain" setting is on. Album tags are written once the album's batch
+// has finished.
+func tagReplayGain(ctx context.Context, result *DownloadResult) {
+	settings, err := LoadSettings()
+	if err != nil || !SettingBool(settings, "replayGain", false) {
+		return
+	}
+
+	loudness, rg, err := ApplyTrackReplayGain(ctx, result.FilePath)
+	if err != nil {
+		fmt.Printf("⚠ Failed to write ReplayGain: %v\n", err)
+		return
+	}
+	result.ReplayGain = rg
+	fmt.Printf("✓ ReplayGain %+.2f dB (%.1f LUFS, LRA %.1f LU, %.1f dBTP)\n", rg.TrackGain, loudness.Integrated, loudness.Range, loudness.TruePeak)
+}
@@ -0,0 +1,292 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxResumeAttempts = 3
+	partStateInterval = 1024 * 1024
+	downloadUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/144.0.0.0 Safari/537.36"
+)
+
+// partState is the sidecar written next to a .part file. For plain downloads
+// Offset is the number of bytes already on disk; for segmented downloads
+// Segment counts the completed segments and Offset is the file size after them,
+// Size sums the Content-Length the server declared for them (-1 once a
+// segment came without one) and Quality is the stream quality the segments
+// belong to.
+type partState struct {
+	URL      string `json:"url"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size,omitempty"`
+	Segment  int    `json:"segment,omitempty"`
+	Segments int    `json:"segments,omitempty"`
+	Quality  string `json:"quality,omitempty"`
+}
+
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("download failed with status %d", e.StatusCode)
+}
+
+func partStatePath(path string) string {
+	return path + ".json"
+}
+
+func loadPartState(statePath string) *partState {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+	var state partState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	return &state
+}
+
+func savePartState(statePath string, state *partState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, data, 0644)
+}
+
+// removePartFiles deletes a partial download and its sidecar.
+func removePartFiles(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partStatePath(partPath))
+}
+
+// CleanStaleParts removes .part files under root that have not been written
+// to for maxAge, together with their sidecars, and returns how many were
+// removed. Downloads that are still running touch their part file regularly.
+func CleanStaleParts(root string, maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".part") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		removePartFiles(path)
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// sameResource reports whether two URLs point at the same file. Signed
+// stream URLs change their query string on every request, so only the
+// scheme, host and path are compared.
+func sameResource(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ua.Scheme == ub.Scheme && ua.Host == ub.Host && ua.Path == ub.Path
+}
+
+// parseContentRangeTotal returns the complete length from a header such as
+// "bytes 100-999/1000", or -1 when it is unknown.
+func parseContentRangeTotal(header string) int64 {
+	idx := strings.LastIndex(header, "/")
+	if idx == -1 {
+		return -1
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(header[idx+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+// partWriter tracks the bytes written to a .part file and periodically
+// persists the offset so an interrupted download can be resumed.
+type partWriter struct {
+	w         io.Writer
+	state     *partState
+	statePath string
+	unsaved   int64
+}
+
+func (pw *partWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.state.Offset += int64(n)
+	pw.unsaved += int64(n)
+	if pw.unsaved >= partStateInterval {
+		pw.flush()
+	}
+	return n, err
+}
+
+func (pw *partWriter) flush() {
+	pw.unsaved = 0
+	savePartState(pw.statePath, pw.state)
+}
+
+// downloadToFile downloads url to outputPath through a .part file. When a
+// previous attempt left a partial file for the same resource, the transfer
+// continues with a Range request. Dropped connections are resumed up to
+// maxResumeAttempts times; cancelling ctx removes the partial file.
+func downloadToFile(ctx context.Context, client *http.Client, fileURL, outputPath string) (int64, error) {
+	partPath := outputPath + ".part"
+
+	var lastErr error
+	for attempt := 1; attempt <= maxResumeAttempts; attempt++ {
+		if attempt > 1 {
+			fmt.Printf("\nConnection lost, resuming download (attempt %d/%d)...\n", attempt, maxResumeAttempts)
+		}
+
+		size, err := downloadPart(ctx, client, fileURL, partPath, true)
+		if err == nil {
+			if err := os.Rename(partPath, outputPath); err != nil {
+				return 0, fmt.Errorf("failed to move downloaded file: %w", err)
+			}
+			os.Remove(partStatePath(partPath))
+			return size, nil
+		}
+
+		if ctx.Err() != nil {
+			// Keep the partial file unless the job itself was cancelled
+			if jobCancelled(ctx) {
+				removePartFiles(partPath)
+			}
+			return 0, ctx.Err()
+		}
+
+		lastErr = err
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			break
+		}
+	}
+
+	return 0, lastErr
+}
+
+func downloadPart(ctx context.Context, client *http.Client, fileURL, partPath string, allowRestart bool) (int64, error) {
+	statePath := partStatePath(partPath)
+
+	var offset int64
+	state := loadPartState(statePath)
+	if state != nil && sameResource(state.URL, fileURL) {
+		if info, err := os.Stat(partPath); err == nil && info.Size() >= state.Offset {
+			offset = state.Offset
+		}
+	}
+	if offset == 0 {
+		state = &partState{}
+	}
+	state.URL = fileURL
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", downloadUserAgent)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		total := parseContentRangeTotal(resp.Header.Get("Content-Range"))
+		if state.Size > 0 && total > 0 && total != state.Size {
+			// The resource changed since the partial download was made
+			if !allowRestart {
+				return 0, fmt.Errorf("remote file changed during download")
+			}
+			resp.Body.Close()
+			removePartFiles(partPath)
+			return downloadPart(ctx, client, fileURL, partPath, false)
+		}
+		if total > 0 {
+			state.Size = total
+		}
+		fmt.Printf("Resuming download at %.2f MB\n", float64(offset)/(1024*1024))
+	case http.StatusOK:
+		offset = 0
+		state.Size = 0
+		if resp.ContentLength > 0 {
+			state.Size = resp.ContentLength
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if state.Size > 0 && offset == state.Size {
+			return offset, nil
+		}
+		return 0, &httpStatusError{StatusCode: resp.StatusCode}
+	default:
+		return 0, &httpStatusError{StatusCode: resp.StatusCode}
+	}
+
+	var out *os.File
+	if offset > 0 {
+		if err := os.Truncate(partPath, offset); err != nil {
+			return 0, fmt.Errorf("failed to prepare partial file: %w", err)
+		}
+		out, err = os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0644)
+	} else {
+		out, err = os.Create(partPath)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	state.Offset = offset
+	if err := savePartState(statePath, state); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("failed to save download state: %w", err)
+	}
+
+	reporter := progressFromContext(ctx)
+	reporter.Start(offset, state.Size)
+
+	cw := &partWriter{w: out, state: state, statePath: statePath}
+	pw := newDownloadWriter(ctx, cw)
+	_, copyErr := io.Copy(pw, resp.Body)
+	closeErr := out.Close()
+	cw.flush()
+
+	if copyErr != nil {
+		return 0, fmt.Errorf("failed to write file: %w", copyErr)
+	}
+	if closeErr != nil {
+		return 0, fmt.Errorf("failed to write file: %w", closeErr)
+	}
+	if state.Size > 0 && state.Offset != state.Size {
+		return 0, fmt.Errorf("incomplete download: got %d of %d bytes", state.Offset, state.Size)
+	}
+
+	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(state.Offset)/(1024*1024))
+	return state.Offset, nil
+}
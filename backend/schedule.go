@@ -0,0 +1,223 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Schedule runs an action whenever its cron expression fires. Target
+// optionally limits sync actions to one subscription or watched artist.
+type Schedule struct {
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Cron      string `json:"cron"`
+	Action    string `json:"action"`
+	Target    string `json:"target,omitempty"`
+	Enabled   bool   `json:"enabled"`
+	CreatedAt int64  `json:"created_at"`
+	LastRunAt int64  `json:"last_run_at,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// DownloadWindow limits when queued jobs may start. Start and End are local
+// "HH:MM" times; a window whose end is before its start spans midnight.
+type DownloadWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks that Start and End are valid times.
+func (w DownloadWindow) Validate() error {
+	if _, err := parseClock(w.Start); err != nil {
+		return err
+	}
+	_, err := parseClock(w.End)
+	return err
+}
+
+// Contains reports whether t falls inside the window. A window whose start
+// equals its end is always open.
+func (w DownloadWindow) Contains(t time.Time) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return true
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return true
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	switch {
+	case start == end:
+		return true
+	case start < end:
+		return now >= start && now < end
+	default:
+		return now >= start || now < end
+	}
+}
+
+// scheduleFile is the on-disk layout of schedules.json.
+type scheduleFile struct {
+	Schedules   []Schedule      `json:"schedules"`
+	Window      *DownloadWindow `json:"download_window,omitempty"`
+	QueuePaused bool            `json:"queue_paused,omitempty"`
+}
+
+// ScheduleStore keeps schedules, the download window and the queue's paused
+// state in a JSON file so they survive restarts.
+type ScheduleStore struct {
+	path string
+
+	mu   sync.Mutex
+	data scheduleFile
+}
+
+// NewScheduleStore loads the schedules file at path. A missing file yields
+// an empty store.
+func NewScheduleStore(path string) (*ScheduleStore, error) {
+	st := &ScheduleStore{path: path, data: scheduleFile{Schedules: []Schedule{}}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &st.data); err != nil {
+		return nil, fmt.Errorf("failed to parse schedules: %w", err)
+	}
+	if st.data.Schedules == nil {
+		st.data.Schedules = []Schedule{}
+	}
+	return st, nil
+}
+
+// save writes the file atomically. The caller must hold mu.
+func (st *ScheduleStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(st.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := st.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write schedules: %w", err)
+	}
+	if err := os.Rename(tmpPath, st.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write schedules: %w", err)
+	}
+	return nil
+}
+
+// List returns all schedules in creation order.
+func (st *ScheduleStore) List() []Schedule {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]Schedule{}, st.data.Schedules...)
+}
+
+// Get returns a schedule, or nil when it does not exist.
+func (st *ScheduleStore) Get(id string) *Schedule {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, sched := range st.data.Schedules {
+		if sched.ID == id {
+			return &sched
+		}
+	}
+	return nil
+}
+
+// Add stores a new schedule and assigns its ID.
+func (st *ScheduleStore) Add(sched *Schedule) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sched.ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), len(st.data.Schedules)+1)
+	sched.CreatedAt = time.Now().Unix()
+	st.data.Schedules = append(st.data.Schedules, *sched)
+	return st.save()
+}
+
+// Save overwrites an existing schedule.
+func (st *ScheduleStore) Save(sched *Schedule) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i := range st.data.Schedules {
+		if st.data.Schedules[i].ID == sched.ID {
+			st.data.Schedules[i] = *sched
+			return st.save()
+		}
+	}
+	return fmt.Errorf("schedule not found")
+}
+
+// Delete removes a schedule.
+func (st *ScheduleStore) Delete(id string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i := range st.data.Schedules {
+		if st.data.Schedules[i].ID == id {
+			st.data.Schedules = append(st.data.Schedules[:i], st.data.Schedules[i+1:]...)
+			return true, st.save()
+		}
+	}
+	return false, nil
+}
+
+// Window returns the download window, or nil when downloads may run at any
+// time.
+func (st *ScheduleStore) Window() *DownloadWindow {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.data.Window == nil {
+		return nil
+	}
+	window := *st.data.Window
+	return &window
+}
+
+// SetWindow replaces the download window. nil removes it.
+func (st *ScheduleStore) SetWindow(window *DownloadWindow) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.data.Window = window
+	return st.save()
+}
+
+// QueuePaused reports whether the download queue was paused.
+func (st *ScheduleStore) QueuePaused() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.data.QueuePaused
+}
+
+// SetQueuePaused records whether the download queue is paused.
+func (st *ScheduleStore) SetQueuePaused(paused bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.data.QueuePaused == paused {
+		return nil
+	}
+	st.data.QueuePaused = paused
+	return st.save()
+}
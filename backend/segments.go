@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	segmentWorkers    = 4
+	segmentMaxRetries = 3
+	segmentBackoff    = 500 * time.Millisecond
+)
+
+// segmentFetcher downloads the segments of a DASH stream concurrently while
+// handing them to the caller strictly in order. At most window segments are
+// fetched but not yet consumed, which bounds memory use. Response bodies are
+// read through limit, so all workers together stay under the bandwidth limit.
+type segmentFetcher struct {
+	client     *http.Client
+	limit      *TokenBucket
+	workers    int
+	window     int
+	maxRetries int
+	backoff    time.Duration
+}
+
+func newSegmentFetcher(client *http.Client) *segmentFetcher {
+	return &segmentFetcher{
+		client:     client,
+		limit:      bandwidthLimit,
+		workers:    segmentWorkers,
+		window:     segmentWorkers * 2,
+		maxRetries: segmentMaxRetries,
+		backoff:    segmentBackoff,
+	}
+}
+
+// segmentResult is a fetched segment. declared is its Content-Length, or -1
+// when the server did not send one.
+type segmentResult struct {
+	data     []byte
+	declared int64
+	err      error
+}
+
+// fetch downloads urls[start:] and calls onSegment for each segment in
+// index order with its data and declared length. The first error from a
+// segment or from onSegment stops all outstanding requests and is returned.
+func (f *segmentFetcher) fetch(ctx context.Context, urls []string, start int, onSegment func(index int, data []byte, declared int64) error) error {
+	if start >= len(urls) {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan segmentResult, len(urls))
+	for i := start; i < len(urls); i++ {
+		results[i] = make(chan segmentResult, 1)
+	}
+
+	slots := make(chan struct{}, max(f.window, 1))
+	jobs := make(chan int)
+
+	// Dispatch in order, waiting for a free slot before each segment
+	go func() {
+		defer close(jobs)
+		for i := start; i < len(urls); i++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < max(f.workers, 1); w++ {
+		go func() {
+			for i := range jobs {
+				data, declared, err := f.fetchSegment(ctx, urls[i])
+				results[i] <- segmentResult{data: data, declared: declared, err: err}
+			}
+		}()
+	}
+
+	for i := start; i < len(urls); i++ {
+		var res segmentResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if res.err != nil {
+			return fmt.Errorf("failed to download %s: %w", segmentName(i), res.err)
+		}
+		if err := onSegment(i, res.data, res.declared); err != nil {
+			return err
+		}
+		<-slots
+	}
+	return nil
+}
+
+// fetchSegment downloads a single segment, retrying network errors, server
+// errors and rate limits with exponential backoff.
+func (f *segmentFetcher) fetchSegment(ctx context.Context, url string) ([]byte, int64, error) {
+	delay := f.backoff
+	var lastErr error
+	for attempt := 0; attempt <= f.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, 0, ctx.Err()
+			}
+			delay *= 2
+		}
+
+		data, declared, err := f.get(ctx, url)
+		if err == nil {
+			return data, declared, nil
+		}
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+
+		lastErr = err
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode != http.StatusTooManyRequests && statusErr.StatusCode < 500 {
+			break
+		}
+	}
+	return nil, 0, lastErr
+}
+
+func (f *segmentFetcher) get(ctx context.Context, url string) ([]byte, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", downloadUserAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, 0, &httpStatusError{StatusCode: resp.StatusCode}
+	}
+
+	declared := int64(-1)
+	if n, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		declared = n
+	}
+
+	data, err := io.ReadAll(newLimitedReader(ctx, resp.Body, f.limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("empty segment")
+	}
+	if declared >= 0 && declared != int64(len(data)) {
+		return nil, 0, fmt.Errorf("short segment: got %d of %d bytes", len(data), declared)
+	}
+	return data, declared, nil
+}
+
+func segmentName(index int) string {
+	if index == 0 {
+		return "init segment"
+	}
+	return fmt.Sprintf("segment %d", index)
+}
+
+// downloadSegments writes the init segment and all media segments of a DASH
+// manifest to tempPath. Progress is recorded in a sidecar after every
+// segment, so a later attempt at the same stream and quality continues from
+// the last completed segment.
+func downloadSegments(ctx context.Context, client *http.Client, initURL string, mediaURLs []string, quality, tempPath string) error {
+	statePath := partStatePath(tempPath)
+
+	// Segment 0 is the init segment, 1..n are media segments
+	urls := append([]string{initURL}, mediaURLs...)
+	totalSegments := len(urls)
+
+	state := loadPartState(statePath)
+	resumable := state != nil && state.Segment > 0 && state.Segments == totalSegments &&
+		state.Quality == quality && sameResource(state.URL, initURL)
+	if resumable {
+		if info, err := os.Stat(tempPath); err != nil || info.Size() < state.Offset {
+			resumable = false
+		}
+	}
+	if !resumable {
+		// Segments of another stream must not be appended to this one
+		state = &partState{Segments: totalSegments, Quality: quality}
+	} else if state.Size == 0 {
+		// Sidecars written before declared sizes were recorded
+		state.Size = -1
+	}
+	state.URL = initURL
+
+	var out *os.File
+	var err error
+	if state.Segment > 0 {
+		if err := os.Truncate(tempPath, state.Offset); err != nil {
+			return fmt.Errorf("failed to prepare temp file: %w", err)
+		}
+		out, err = os.OpenFile(tempPath, os.O_WRONLY|os.O_APPEND, 0644)
+		fmt.Printf("Resuming at segment %d/%d...\n", state.Segment, totalSegments)
+	} else {
+		out, err = os.Create(tempPath)
+		fmt.Printf("Downloading %d segments...\n", totalSegments)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	reporter := progressFromContext(ctx)
+	reporter.Start(state.Offset, 0)
+
+	err = newSegmentFetcher(client).fetch(ctx, urls, state.Segment, func(index int, data []byte, declared int64) error {
+		if _, err := out.Write(data); err != nil {
+			// Drop the incomplete segment so the sidecar offset stays valid
+			out.Truncate(state.Offset)
+			return fmt.Errorf("failed to write %s: %w", segmentName(index), err)
+		}
+
+		state.Segment = index + 1
+		state.Offset += int64(len(data))
+		if declared < 0 {
+			state.Size = -1
+		} else if state.Size >= 0 {
+			state.Size += declared
+		}
+		savePartState(statePath, state)
+
+		// Segment sizes are similar, so the average gives a usable total
+		if state.Segment == totalSegments {
+			reporter.SetTotal(state.Offset)
+		} else {
+			reporter.SetTotal(state.Offset / int64(state.Segment) * int64(totalSegments))
+		}
+		reporter.Add(int64(len(data)))
+
+		fmt.Printf("\rDownloading: %.2f MB (%d/%d segments)", float64(state.Offset)/(1024*1024), index+1, totalSegments)
+		return nil
+	})
+	closeErr := out.Close()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			// Keep the partial file unless the job itself was cancelled
+			if jobCancelled(ctx) {
+				removePartFiles(tempPath)
+			}
+			return ctx.Err()
+		}
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write temp file: %w", closeErr)
+	}
+
+	// The file must add up to the lengths the server declared for the
+	// segments, across resumed attempts too
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to read temp file: %w", err)
+	}
+	if state.Size >= 0 && info.Size() != state.Size {
+		removePartFiles(tempPath)
+		return fmt.Errorf("assembled size mismatch: the server declared %d bytes, got %d", state.Size, info.Size())
+	}
+	os.Remove(statePath)
+
+	fmt.Printf("\rDownloaded: %.2f MB (Complete)          \n", float64(state.Offset)/(1024*1024))
+	return nil
+}
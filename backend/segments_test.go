@@ -0,0 +1,311 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// segmentServer serves an init segment at /init.mp4 and media segments at
+// /seg/<n>.m4s. Segment 0 is the init segment.
+type segmentServer struct {
+	*httptest.Server
+	segments [][]byte
+
+	mu       sync.Mutex
+	requests map[int]int
+	// failures is how many times a segment answers 500 before it succeeds
+	failures map[int]int
+	// truncated is how many times a segment declares its full length but
+	// sends only half of it
+	truncated map[int]int
+	// empty is how many times a segment answers 200 without a body
+	empty map[int]int
+	// onServe runs before a segment is written
+	onServe func(index int)
+}
+
+func newSegmentServer(t *testing.T, count int) *segmentServer {
+	s := &segmentServer{requests: map[int]int{}, failures: map[int]int{}, truncated: map[int]int{}, empty: map[int]int{}}
+	for i := 0; i < count; i++ {
+		s.segments = append(s.segments, bytes.Repeat([]byte{byte('a' + i%26)}, 1000+i*37))
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *segmentServer) serve(w http.ResponseWriter, r *http.Request) {
+	index := 0
+	if r.URL.Path != "/init.mp4" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/seg/"), ".m4s"))
+		if err != nil || n < 1 || n >= len(s.segments) {
+			http.NotFound(w, r)
+			return
+		}
+		index = n
+	}
+
+	s.mu.Lock()
+	s.requests[index]++
+	fail := s.failures[index] > 0
+	if fail {
+		s.failures[index]--
+	}
+	truncate := s.truncated[index] > 0
+	if truncate {
+		s.truncated[index]--
+	}
+	empty := s.empty[index] > 0
+	if empty {
+		s.empty[index]--
+	}
+	onServe := s.onServe
+	s.mu.Unlock()
+
+	switch {
+	case fail:
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+		return
+	case empty:
+		w.Header().Set("Content-Length", "0")
+		return
+	case truncate:
+		// The server closes the connection when the body falls short
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.segments[index])))
+		w.Write(s.segments[index][:len(s.segments[index])/2])
+		return
+	}
+	if onServe != nil {
+		onServe(index)
+	}
+	// Later segments answer first, so writes only stay in order if the
+	// fetcher reorders them
+	time.Sleep(time.Duration(len(s.segments)-index) * time.Millisecond)
+	w.Write(s.segments[index])
+}
+
+func (s *segmentServer) requestCount(index int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[index]
+}
+
+// manifest returns a base64 DASH manifest for the server's segments.
+func (s *segmentServer) manifest() string {
+	mpd := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static">
+  <Period>
+    <AdaptationSet mimeType="audio/mp4" contentType="audio">
+      <Representation id="0" codecs="flac" bandwidth="1411200" audioSamplingRate="44100">
+        <SegmentTemplate timescale="44100" initialization="%[1]s/init.mp4" media="%[1]s/seg/$Number$.m4s" startNumber="1">
+          <SegmentTimeline>
+            <S d="176128" r="%[2]d"/>
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`, s.URL, len(s.segments)-2)
+	return base64.StdEncoding.EncodeToString([]byte(mpd))
+}
+
+func (s *segmentServer) assembled(upTo int) []byte {
+	return bytes.Join(s.segments[:upTo], nil)
+}
+
+func parseTestManifest(t *testing.T, s *segmentServer) (string, []string) {
+	t.Helper()
+	directURL, initURL, mediaURLs, _, err := parseManifest(s.manifest())
+	if err != nil {
+		t.Fatalf("parseManifest: %v", err)
+	}
+	if directURL != "" {
+		t.Fatalf("parseManifest returned a direct URL: %s", directURL)
+	}
+	if len(mediaURLs) != len(s.segments)-1 {
+		t.Fatalf("parseManifest returned %d media segments, want %d", len(mediaURLs), len(s.segments)-1)
+	}
+	return initURL, mediaURLs
+}
+
+func TestDownloadSegmentsWritesInOrder(t *testing.T) {
+	s := newSegmentServer(t, 20)
+	initURL, mediaURLs := parseTestManifest(t, s)
+	tempPath := filepath.Join(t.TempDir(), "track.m4a.tmp")
+
+	if err := downloadSegments(context.Background(), s.Client(), initURL, mediaURLs, "LOSSLESS", tempPath); err != nil {
+		t.Fatalf("downloadSegments: %v", err)
+	}
+
+	got, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, s.assembled(len(s.segments))) {
+		t.Fatalf("assembled file differs from the segments in order (%d bytes)", len(got))
+	}
+	if fileExists(partStatePath(tempPath)) {
+		t.Error("sidecar was not removed after a complete download")
+	}
+}
+
+func TestDownloadSegmentsRetriesFailedSegment(t *testing.T) {
+	s := newSegmentServer(t, 6)
+	s.failures[3] = 2
+	initURL, mediaURLs := parseTestManifest(t, s)
+	tempPath := filepath.Join(t.TempDir(), "track.m4a.tmp")
+
+	if err := downloadSegments(context.Background(), s.Client(), initURL, mediaURLs, "LOSSLESS", tempPath); err != nil {
+		t.Fatalf("downloadSegments: %v", err)
+	}
+
+	if n := s.requestCount(3); n != 3 {
+		t.Errorf("segment 3 was requested %d times, want 3", n)
+	}
+	got, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, s.assembled(len(s.segments))) {
+		t.Fatal("assembled file differs after a retried segment")
+	}
+}
+
+func TestDownloadSegmentsRetriesIncompleteSegments(t *testing.T) {
+	s := newSegmentServer(t, 6)
+	s.truncated[2] = 1
+	s.empty[4] = 1
+	initURL, mediaURLs := parseTestManifest(t, s)
+	tempPath := filepath.Join(t.TempDir(), "track.m4a.tmp")
+
+	if err := downloadSegments(context.Background(), s.Client(), initURL, mediaURLs, "LOSSLESS", tempPath); err != nil {
+		t.Fatalf("downloadSegments: %v", err)
+	}
+
+	for _, index := range []int{2, 4} {
+		if n := s.requestCount(index); n != 2 {
+			t.Errorf("segment %d was requested %d times, want 2", index, n)
+		}
+	}
+	got, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, s.assembled(len(s.segments))) {
+		t.Fatal("assembled file differs after incomplete segments")
+	}
+}
+
+func TestDownloadSegmentsResumesFromState(t *testing.T) {
+	s := newSegmentServer(t, 10)
+	initURL, mediaURLs := parseTestManifest(t, s)
+	tempPath := filepath.Join(t.TempDir(), "track.m4a.tmp")
+
+	// A previous attempt wrote four segments, plus part of a fifth that the
+	// sidecar does not cover
+	done := 4
+	partial := append(s.assembled(done), []byte("partial")...)
+	if err := os.WriteFile(tempPath, partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	savePartState(partStatePath(tempPath), &partState{
+		URL:      initURL,
+		Offset:   int64(len(s.assembled(done))),
+		Size:     int64(len(s.assembled(done))),
+		Segment:  done,
+		Segments: len(s.segments),
+		Quality:  "LOSSLESS",
+	})
+
+	if err := downloadSegments(context.Background(), s.Client(), initURL, mediaURLs, "LOSSLESS", tempPath); err != nil {
+		t.Fatalf("downloadSegments: %v", err)
+	}
+
+	for i := 0; i < len(s.segments); i++ {
+		want := 1
+		if i < done {
+			want = 0
+		}
+		if n := s.requestCount(i); n != want {
+			t.Errorf("segment %d was requested %d times, want %d", i, n, want)
+		}
+	}
+	got, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, s.assembled(len(s.segments))) {
+		t.Fatal("resumed file differs from the segments in order")
+	}
+}
+
+func TestDownloadSegmentsRestartsOtherQuality(t *testing.T) {
+	s := newSegmentServer(t, 5)
+	initURL, mediaURLs := parseTestManifest(t, s)
+	tempPath := filepath.Join(t.TempDir(), "track.m4a.tmp")
+
+	if err := os.WriteFile(tempPath, s.assembled(2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	savePartState(partStatePath(tempPath), &partState{
+		URL:      initURL,
+		Offset:   int64(len(s.assembled(2))),
+		Segment:  2,
+		Segments: len(s.segments),
+		Quality:  "HI_RES",
+	})
+
+	if err := downloadSegments(context.Background(), s.Client(), initURL, mediaURLs, "LOSSLESS", tempPath); err != nil {
+		t.Fatalf("downloadSegments: %v", err)
+	}
+	if n := s.requestCount(0); n != 1 {
+		t.Errorf("init segment was requested %d times, want 1", n)
+	}
+	got, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, s.assembled(len(s.segments))) {
+		t.Fatal("restarted file differs from the segments in order")
+	}
+}
+
+func TestDownloadSegmentsChecksAssembledSize(t *testing.T) {
+	s := newSegmentServer(t, 5)
+	initURL, mediaURLs := parseTestManifest(t, s)
+	tempPath := filepath.Join(t.TempDir(), "track.m4a.tmp")
+
+	// Something else writes past the end of the file while the last segment
+	// is fetched
+	s.onServe = func(index int) {
+		if index != len(s.segments)-1 {
+			return
+		}
+		f, err := os.OpenFile(tempPath, os.O_WRONLY, 0644)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		f.WriteAt([]byte("garbage"), 1<<20)
+		f.Close()
+	}
+
+	err := downloadSegments(context.Background(), s.Client(), initURL, mediaURLs, "LOSSLESS", tempPath)
+	if err == nil || !strings.Contains(err.Error(), "assembled size mismatch") {
+		t.Fatalf("downloadSegments returned %v, want a size mismatch", err)
+	}
+	if fileExists(tempPath) || fileExists(partStatePath(tempPath)) {
+		t.Error("part files were kept after a size mismatch")
+	}
+}
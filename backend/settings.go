@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func getSettingsPath() (string, error) {
+	configPath, err := GetFFmpegDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, "settings.json"), nil
+}
+
+// LoadSettings reads settings.json. A missing file yields empty settings.
+func LoadSettings() (map[string]interface{}, error) {
+	settingsFile, err := getSettingsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(settingsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]interface{}{}, nil
+		}
+		return nil, err
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %w", err)
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	return settings, nil
+}
+
+func SaveSettings(settings map[string]interface{}) error {
+	settingsFile, err := getSettingsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(settingsFile), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(settingsFile, data, 0644)
+}
+
+// SettingString returns a string setting or the fallback when it is missing.
+func SettingString(settings map[string]interface{}, key, fallback string) string {
+	if v, ok := settings[key].(string); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// SettingBool returns a boolean setting or the fallback when it is missing.
+func SettingBool(settings map[string]interface{}, key string, fallback bool) bool {
+	if v, ok := settings[key].(bool); ok {
+		return v
+	}
+	return fallback
+}
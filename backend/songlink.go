@@ -1,21 +1,48 @@
 package backend
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	songLinkCacheBucket = "SongLinkCache"
+	songLinkCacheTTL    = 7 * 24 * time.Hour
+	// song.link allows about 10 requests per minute without an API key
+	songLinkInterval = 7 * time.Second
 )
 
+// SongLinkClient resolves Spotify tracks to other platforms through
+// song.link. There is a single client, shared by all callers, so its rate
+// limit holds across concurrent downloads. Responses are cached in the
+// history database, and concurrent lookups of the same track share one
+// request.
 type SongLinkClient struct {
-	client           *http.Client
-	lastAPICallTime  time.Time
-	apiCallCount     int
-	apiCallResetTime time.Time
+	client  *http.Client
+	limiter *TokenBucket
+	appName string
+
+	mu    sync.Mutex
+	calls map[string]*songLinkCall
+}
+
+// songLinkCall is a song.link request that other lookups of the same key
+// wait for.
+type songLinkCall struct {
+	done  chan struct{}
+	links map[string]string
+	err   error
 }
 
 type SongLinkURLs struct {
@@ -24,212 +51,219 @@ type SongLinkURLs struct {
 	ISRC      string `json:"isrc"`
 }
 
-type TrackAvailability struct {
-	SpotifyID string `json:"spotify_id"`
-	Tidal     bool   `json:"tidal"`
-	Amazon    bool   `json:"amazon"`
-	Qobuz     bool   `json:"qobuz"`
-	TidalURL  string `json:"tidal_url,omitempty"`
-	AmazonURL string `json:"amazon_url,omitempty"`
-	QobuzURL  string `json:"qobuz_url,omitempty"`
+type SongLinkResponse struct {
+	LinksByPlatform map[string]struct {
+		URL string `json:"url"`
+	} `json:"linksByPlatform"`
 }
 
-func NewSongLinkClient() *SongLinkClient {
-	return &SongLinkClient{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		apiCallResetTime: time.Now(),
-	}
+// songLinkEntry is a cached song.link lookup. ISRC and Services are filled
+// in lazily by the lookups that need them.
+type songLinkEntry struct {
+	Links     map[string]string          `json:"links"`
+	ISRC      string                     `json:"isrc,omitempty"`
+	Services  map[string]*ServiceQuality `json:"services,omitempty"`
+	FetchedAt int64                      `json:"fetched_at"`
 }
 
-func (s *SongLinkClient) GetAllURLsFromSpotify(spotifyTrackID string, region string) (*SongLinkURLs, error) {
-
-	now := time.Now()
-	if now.Sub(s.apiCallResetTime) >= time.Minute {
-		s.apiCallCount = 0
-		s.apiCallResetTime = now
-	}
+var (
+	songLinkOnce   sync.Once
+	songLinkClient *SongLinkClient
+)
 
-	if s.apiCallCount >= 9 {
-		waitTime := time.Minute - now.Sub(s.apiCallResetTime)
-		if waitTime > 0 {
-			fmt.Printf("Rate limit reached, waiting %v...\n", waitTime.Round(time.Second))
-			time.Sleep(waitTime)
-			s.apiCallCount = 0
-			s.apiCallResetTime = time.Now()
+// GetSongLinkClient returns the shared song.link client.
+func GetSongLinkClient() *SongLinkClient {
+	songLinkOnce.Do(func() {
+		songLinkClient = &SongLinkClient{
+			client: &http.Client{
+				Timeout: 30 * time.Second,
+			},
+			limiter: NewTokenBucket(1/songLinkInterval.Seconds(), 1),
+			appName: "SpotiFLAC",
+			calls:   map[string]*songLinkCall{},
 		}
-	}
+	})
+	return songLinkClient
+}
 
-	if !s.lastAPICallTime.IsZero() {
-		timeSinceLastCall := now.Sub(s.lastAPICallTime)
-		minDelay := 7 * time.Second
-		if timeSinceLastCall < minDelay {
-			waitTime := minDelay - timeSinceLastCall
-			fmt.Printf("Rate limiting: waiting %v...\n", waitTime.Round(time.Second))
-			time.Sleep(waitTime)
-		}
+func songLinkCacheKey(spotifyTrackID, region string) string {
+	if region == "" {
+		return spotifyTrackID
 	}
+	return spotifyTrackID + "@" + strings.ToUpper(region)
+}
 
-	spotifyBase, _ := base64.StdEncoding.DecodeString("aHR0cHM6Ly9vcGVuLnNwb3RpZnkuY29tL3RyYWNrLw==")
-	spotifyURL := fmt.Sprintf("%s%s", string(spotifyBase), spotifyTrackID)
-
-	apiBase, _ := base64.StdEncoding.DecodeString("aHR0cHM6Ly9hcGkuc29uZy5saW5rL3YxLWFscGhhLjEvbGlua3M/dXJsPQ==")
-	apiURL := fmt.Sprintf("%s%s", string(apiBase), url.QueryEscape(spotifyURL))
-
-	if region != "" {
-		apiURL += fmt.Sprintf("&userCountry=%s", region)
+func (s *SongLinkClient) ensureDB() error {
+	if historyDB == nil {
+		return InitHistoryDB(s.appName)
 	}
+	return nil
+}
 
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// cached returns the cache entry for key unless it is missing or expired.
+func (s *SongLinkClient) cached(key string) *songLinkEntry {
+	if err := s.ensureDB(); err != nil {
+		return nil
 	}
-
-	fmt.Println("Getting streaming URLs from song.link...")
-
-	maxRetries := 3
-	var resp *http.Response
-	for i := 0; i < maxRetries; i++ {
-		resp, err = s.client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get URLs: %w", err)
+	var entry *songLinkEntry
+	historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(songLinkCacheBucket))
+		if b == nil {
+			return nil
 		}
-
-		s.lastAPICallTime = time.Now()
-		s.apiCallCount++
-
-		if resp.StatusCode == 429 {
-			resp.Body.Close()
-			if i < maxRetries-1 {
-				waitTime := 15 * time.Second
-				fmt.Printf("Rate limited by API, waiting %v before retry...\n", waitTime)
-				time.Sleep(waitTime)
-				continue
-			}
-			return nil, fmt.Errorf("API rate limit exceeded after %d retries", maxRetries)
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
 		}
-
-		if resp.StatusCode != 200 {
-			resp.Body.Close()
-			return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+		var e songLinkEntry
+		if err := json.Unmarshal(v, &e); err == nil && time.Since(time.Unix(e.FetchedAt, 0)) < songLinkCacheTTL {
+			entry = &e
 		}
+		return nil
+	})
+	return entry
+}
 
-		break
-	}
-	defer resp.Body.Close()
-
-	var songLinkResp struct {
-		LinksByPlatform map[string]struct {
-			URL string `json:"url"`
-		} `json:"linksByPlatform"`
+func (s *SongLinkClient) store(key string, entry *songLinkEntry) {
+	if err := s.ensureDB(); err != nil {
+		return
 	}
-
-	body, err := io.ReadAll(resp.Body)
+	buf, err := json.Marshal(entry)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	if len(body) == 0 {
-		return nil, fmt.Errorf("API returned empty response")
+		return
 	}
-
-	if err := json.Unmarshal(body, &songLinkResp); err != nil {
-
-		bodyStr := string(body)
-		if len(bodyStr) > 200 {
-			bodyStr = bodyStr[:200] + "..."
+	err = historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(songLinkCacheBucket))
+		if err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("failed to decode response: %w (response: %s)", err, bodyStr)
-	}
-
-	urls := &SongLinkURLs{}
-
-	if tidalLink, ok := songLinkResp.LinksByPlatform["tidal"]; ok && tidalLink.URL != "" {
-		urls.TidalURL = tidalLink.URL
-		fmt.Printf("✓ Tidal URL found\n")
+		return b.Put([]byte(key), buf)
+	})
+	if err != nil {
+		fmt.Printf("⚠ Failed to cache song.link response: %v\n", err)
 	}
+}
 
-	if amazonLink, ok := songLinkResp.LinksByPlatform["amazonMusic"]; ok && amazonLink.URL != "" {
-		amazonURL := amazonLink.URL
+// lookup returns the song.link entry for a track, from the cache when it is
+// fresh and from the API otherwise. Each caller gets its own copy of the
+// entry.
+func (s *SongLinkClient) lookup(ctx context.Context, spotifyTrackID, region string) (*songLinkEntry, error) {
+	key := songLinkCacheKey(spotifyTrackID, region)
+	for {
+		if entry := s.cached(key); entry != nil {
+			return entry, nil
+		}
 
-		if len(amazonURL) > 0 {
-			urls.AmazonURL = amazonURL
-			fmt.Printf("✓ Amazon URL found\n")
+		s.mu.Lock()
+		call, running := s.calls[key]
+		if !running {
+			call = &songLinkCall{done: make(chan struct{})}
+			s.calls[key] = call
 		}
-	}
+		s.mu.Unlock()
 
-	if deezerLink, ok := songLinkResp.LinksByPlatform["deezer"]; ok && deezerLink.URL != "" {
-		if isrc, err := getDeezerISRC(deezerLink.URL); err == nil && isrc != "" {
-			urls.ISRC = isrc
+		if running {
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// The request was cancelled by its own caller, not by this one
+			if call.err != nil && ctx.Err() == nil && errors.Is(call.err, context.Canceled) {
+				continue
+			}
+		} else {
+			call.links, call.err = s.fetchLinks(ctx, spotifyTrackID, region)
+			if call.err == nil {
+				s.store(key, &songLinkEntry{Links: call.links, FetchedAt: time.Now().Unix()})
+			}
+			s.mu.Lock()
+			delete(s.calls, key)
+			s.mu.Unlock()
+			close(call.done)
 		}
-	}
 
-	if urls.TidalURL == "" && urls.AmazonURL == "" {
-		return nil, fmt.Errorf("no streaming URLs found")
+		if call.err != nil {
+			return nil, call.err
+		}
+		return &songLinkEntry{Links: call.links, FetchedAt: time.Now().Unix()}, nil
 	}
-
-	return urls, nil
 }
 
-func (s *SongLinkClient) CheckTrackAvailability(spotifyTrackID string) (*TrackAvailability, error) {
-
-	now := time.Now()
-	if now.Sub(s.apiCallResetTime) >= time.Minute {
-		s.apiCallCount = 0
-		s.apiCallResetTime = now
-	}
-
-	if s.apiCallCount >= 9 {
-		waitTime := time.Minute - now.Sub(s.apiCallResetTime)
-		if waitTime > 0 {
-			fmt.Printf("Rate limit reached, waiting %v...\n", waitTime.Round(time.Second))
-			time.Sleep(waitTime)
-			s.apiCallCount = 0
-			s.apiCallResetTime = time.Now()
+// update applies change to the cached entry of a track, e.g. to add a
+// resolved ISRC. The entry is read and written in one transaction, so
+// concurrent updates of different fields do not overwrite each other. An
+// entry that has expired or was never stored is left alone.
+func (s *SongLinkClient) update(spotifyTrackID, region string, change func(entry *songLinkEntry)) {
+	if err := s.ensureDB(); err != nil {
+		return
+	}
+	key := []byte(songLinkCacheKey(spotifyTrackID, region))
+	err := historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(songLinkCacheBucket))
+		if b == nil {
+			return nil
 		}
-	}
-
-	if !s.lastAPICallTime.IsZero() {
-		timeSinceLastCall := now.Sub(s.lastAPICallTime)
-		minDelay := 7 * time.Second
-		if timeSinceLastCall < minDelay {
-			waitTime := minDelay - timeSinceLastCall
-			fmt.Printf("Rate limiting: waiting %v...\n", waitTime.Round(time.Second))
-			time.Sleep(waitTime)
+		v := b.Get(key)
+		if v == nil {
+			return nil
 		}
+		var entry songLinkEntry
+		if err := json.Unmarshal(v, &entry); err != nil || time.Since(time.Unix(entry.FetchedAt, 0)) >= songLinkCacheTTL {
+			return nil
+		}
+		change(&entry)
+		buf, err := json.Marshal(&entry)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, buf)
+	})
+	if err != nil {
+		fmt.Printf("⚠ Failed to cache song.link response: %v\n", err)
 	}
+}
 
+// fetchLinks calls the song.link API and returns the URL for each platform.
+func (s *SongLinkClient) fetchLinks(ctx context.Context, spotifyTrackID, region string) (map[string]string, error) {
 	spotifyBase, _ := base64.StdEncoding.DecodeString("aHR0cHM6Ly9vcGVuLnNwb3RpZnkuY29tL3RyYWNrLw==")
 	spotifyURL := fmt.Sprintf("%s%s", string(spotifyBase), spotifyTrackID)
 
 	apiBase, _ := base64.StdEncoding.DecodeString("aHR0cHM6Ly9hcGkuc29uZy5saW5rL3YxLWFscGhhLjEvbGlua3M/dXJsPQ==")
 	apiURL := fmt.Sprintf("%s%s", string(apiBase), url.QueryEscape(spotifyURL))
 
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	if region != "" {
+		apiURL += fmt.Sprintf("&userCountry=%s", region)
 	}
 
-	fmt.Printf("Checking availability for track: %s\n", spotifyTrackID)
-
 	maxRetries := 3
 	var resp *http.Response
 	for i := 0; i < maxRetries; i++ {
-		resp, err = s.client.Do(req)
+		if err := s.limiter.WaitN(ctx, 1); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check availability: %w", err)
+			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
+		req.Header.Set("User-Agent", downloadUserAgent)
 
-		s.lastAPICallTime = time.Now()
-		s.apiCallCount++
+		resp, err = s.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query song.link: %w", err)
+		}
 
 		if resp.StatusCode == 429 {
 			resp.Body.Close()
 			if i < maxRetries-1 {
 				waitTime := 15 * time.Second
 				fmt.Printf("Rate limited by API, waiting %v before retry...\n", waitTime)
-				time.Sleep(waitTime)
+				select {
+				case <-time.After(waitTime):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
 				continue
 			}
 			return nil, fmt.Errorf("API rate limit exceeded after %d retries", maxRetries)
@@ -244,12 +278,6 @@ func (s *SongLinkClient) CheckTrackAvailability(spotifyTrackID string) (*TrackAv
 	}
 	defer resp.Body.Close()
 
-	var songLinkResp struct {
-		LinksByPlatform map[string]struct {
-			URL string `json:"url"`
-		} `json:"linksByPlatform"`
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
@@ -259,8 +287,8 @@ func (s *SongLinkClient) CheckTrackAvailability(spotifyTrackID string) (*TrackAv
 		return nil, fmt.Errorf("API returned empty response")
 	}
 
+	var songLinkResp SongLinkResponse
 	if err := json.Unmarshal(body, &songLinkResp); err != nil {
-
 		bodyStr := string(body)
 		if len(bodyStr) > 200 {
 			bodyStr = bodyStr[:200] + "..."
@@ -268,154 +296,90 @@ func (s *SongLinkClient) CheckTrackAvailability(spotifyTrackID string) (*TrackAv
 		return nil, fmt.Errorf("failed to decode response: %w (response: %s)", err, bodyStr)
 	}
 
-	availability := &TrackAvailability{
-		SpotifyID: spotifyTrackID,
-	}
-
-	if tidalLink, ok := songLinkResp.LinksByPlatform["tidal"]; ok && tidalLink.URL != "" {
-		availability.Tidal = true
-		availability.TidalURL = tidalLink.URL
-	}
-
-	if amazonLink, ok := songLinkResp.LinksByPlatform["amazonMusic"]; ok && amazonLink.URL != "" {
-		availability.Amazon = true
-		availability.AmazonURL = amazonLink.URL
-	}
-
-	if deezerLink, ok := songLinkResp.LinksByPlatform["deezer"]; ok && deezerLink.URL != "" {
-		deezerURL := deezerLink.URL
-
-		deezerISRC, err := getDeezerISRC(deezerURL)
-		if err == nil && deezerISRC != "" {
-			qobuzAvailable := checkQobuzAvailability(deezerISRC)
-			availability.Qobuz = qobuzAvailable
+	links := make(map[string]string, len(songLinkResp.LinksByPlatform))
+	for platform, link := range songLinkResp.LinksByPlatform {
+		if link.URL != "" {
+			links[platform] = link.URL
 		}
 	}
-
-	return availability, nil
+	return links, nil
 }
 
-func checkQobuzAvailability(isrc string) bool {
-	client := &http.Client{Timeout: 10 * time.Second}
-	appID := "798273057"
-
-	apiBase, _ := base64.StdEncoding.DecodeString("aHR0cHM6Ly93d3cucW9idXouY29tL2FwaS5qc29uLzAuMi90cmFjay9zZWFyY2g/cXVlcnk9")
-	searchURL := fmt.Sprintf("%s%s&limit=1&app_id=%s", string(apiBase), isrc, appID)
-
-	resp, err := client.Get(searchURL)
+// PlatformURL returns the link of a Spotify track on platform, using the
+// names of song.link's linksByPlatform (e.g. "tidal", "amazonMusic").
+func (s *SongLinkClient) PlatformURL(ctx context.Context, spotifyTrackID, platform string) (string, error) {
+	entry, err := s.lookup(ctx, spotifyTrackID, "")
 	if err != nil {
-		return false
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return false
-	}
-
-	var searchResp struct {
-		Tracks struct {
-			Total int `json:"total"`
-		} `json:"tracks"`
+		return "", err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
-		return false
+	link := entry.Links[platform]
+	if link == "" {
+		return "", fmt.Errorf("%s link not found", platform)
 	}
-
-	return searchResp.Tracks.Total > 0
+	return link, nil
 }
 
-func (s *SongLinkClient) GetDeezerURLFromSpotify(spotifyTrackID string) (string, error) {
+func (s *SongLinkClient) GetAllURLsFromSpotify(ctx context.Context, spotifyTrackID string, region string) (*SongLinkURLs, error) {
+	fmt.Println("Getting streaming URLs from song.link...")
 
-	now := time.Now()
-	if now.Sub(s.apiCallResetTime) >= time.Minute {
-		s.apiCallCount = 0
-		s.apiCallResetTime = now
+	entry, err := s.lookup(ctx, spotifyTrackID, region)
+	if err != nil {
+		return nil, err
 	}
 
-	if s.apiCallCount >= 9 {
-		waitTime := time.Minute - now.Sub(s.apiCallResetTime)
-		if waitTime > 0 {
-			fmt.Printf("Rate limit reached, waiting %v...\n", waitTime.Round(time.Second))
-			time.Sleep(waitTime)
-			s.apiCallCount = 0
-			s.apiCallResetTime = time.Now()
-		}
+	urls := &SongLinkURLs{
+		TidalURL:  entry.Links["tidal"],
+		AmazonURL: entry.Links["amazonMusic"],
+	}
+	if urls.TidalURL != "" {
+		fmt.Printf("✓ Tidal URL found\n")
 	}
+	if urls.AmazonURL != "" {
+		fmt.Printf("✓ Amazon URL found\n")
+	}
+	urls.ISRC, _ = s.isrcForEntry(ctx, spotifyTrackID, region, entry)
 
-	if !s.lastAPICallTime.IsZero() {
-		timeSinceLastCall := now.Sub(s.lastAPICallTime)
-		minDelay := 7 * time.Second
-		if timeSinceLastCall < minDelay {
-			waitTime := minDelay - timeSinceLastCall
-			fmt.Printf("Rate limiting: waiting %v...\n", waitTime.Round(time.Second))
-			time.Sleep(waitTime)
-		}
+	if urls.TidalURL == "" && urls.AmazonURL == "" {
+		return nil, fmt.Errorf("no streaming URLs found")
 	}
 
-	spotifyBase, _ := base64.StdEncoding.DecodeString("aHR0cHM6Ly9vcGVuLnNwb3RpZnkuY29tL3RyYWNrLw==")
-	spotifyURL := fmt.Sprintf("%s%s", string(spotifyBase), spotifyTrackID)
+	return urls, nil
+}
 
-	apiBase, _ := base64.StdEncoding.DecodeString("aHR0cHM6Ly9hcGkuc29uZy5saW5rL3YxLWFscGhhLjEvbGlua3M/dXJsPQ==")
-	apiURL := fmt.Sprintf("%s%s", string(apiBase), url.QueryEscape(spotifyURL))
+func (s *SongLinkClient) GetDeezerURLFromSpotify(ctx context.Context, spotifyTrackID string) (string, error) {
+	fmt.Println("Getting Deezer URL from song.link...")
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+	deezerURL, err := s.PlatformURL(ctx, spotifyTrackID, "deezer")
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", err
 	}
 
-	fmt.Println("Getting Deezer URL from song.link...")
-
-	maxRetries := 3
-	var resp *http.Response
-	for i := 0; i < maxRetries; i++ {
-		resp, err = s.client.Do(req)
-		if err != nil {
-			return "", fmt.Errorf("failed to get Deezer URL: %w", err)
-		}
-
-		s.lastAPICallTime = time.Now()
-		s.apiCallCount++
-
-		if resp.StatusCode == 429 {
-			resp.Body.Close()
-			if i < maxRetries-1 {
-				waitTime := 15 * time.Second
-				fmt.Printf("Rate limited by API, waiting %v before retry...\n", waitTime)
-				time.Sleep(waitTime)
-				continue
-			}
-			return "", fmt.Errorf("API rate limit exceeded after %d retries", maxRetries)
-		}
-
-		if resp.StatusCode != 200 {
-			resp.Body.Close()
-			return "", fmt.Errorf("API returned status %d", resp.StatusCode)
-		}
-
-		break
-	}
-	defer resp.Body.Close()
+	fmt.Printf("Found Deezer URL: %s\n", deezerURL)
+	return deezerURL, nil
+}
 
-	var songLinkResp struct {
-		LinksByPlatform map[string]struct {
-			URL string `json:"url"`
-		} `json:"linksByPlatform"`
+// isrcForEntry returns the ISRC of a looked-up track, resolving it through
+// Deezer and caching it on first use.
+func (s *SongLinkClient) isrcForEntry(ctx context.Context, spotifyTrackID, region string, entry *songLinkEntry) (string, error) {
+	if entry.ISRC != "" {
+		return entry.ISRC, nil
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&songLinkResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	deezerURL := entry.Links["deezer"]
+	if deezerURL == "" {
+		return "", errors.New("deezer link not found")
 	}
-
-	deezerLink, ok := songLinkResp.LinksByPlatform["deezer"]
-	if !ok || deezerLink.URL == "" {
-		return "", fmt.Errorf("deezer link not found")
+	isrc, err := getDeezerISRC(ctx, deezerURL)
+	if err != nil {
+		return "", err
 	}
-
-	deezerURL := deezerLink.URL
-	fmt.Printf("Found Deezer URL: %s\n", deezerURL)
-	return deezerURL, nil
+	entry.ISRC = isrc
+	s.update(spotifyTrackID, region, func(cached *songLinkEntry) {
+		cached.ISRC = isrc
+	})
+	return isrc, nil
 }
 
-func getDeezerISRC(deezerURL string) (string, error) {
+func getDeezerISRC(ctx context.Context, deezerURL string) (string, error) {
 
 	var trackID string
 	if strings.Contains(deezerURL, "/track/") {
@@ -432,8 +396,12 @@ func getDeezerISRC(deezerURL string) (string, error) {
 
 	apiURL := fmt.Sprintf("https://api.deezer.com/track/%s", trackID)
 
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return "", err
+	}
 	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(apiURL)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to call Deezer API: %w", err)
 	}
@@ -460,10 +428,10 @@ func getDeezerISRC(deezerURL string) (string, error) {
 	return deezerTrack.ISRC, nil
 }
 
-func (s *SongLinkClient) GetISRC(spotifyID string) (string, error) {
-	deezerURL, err := s.GetDeezerURLFromSpotify(spotifyID)
+func (s *SongLinkClient) GetISRC(ctx context.Context, spotifyID string) (string, error) {
+	entry, err := s.lookup(ctx, spotifyID, "")
 	if err != nil {
 		return "", err
 	}
-	return getDeezerISRC(deezerURL)
+	return s.isrcForEntry(ctx, spotifyID, "", entry)
 }
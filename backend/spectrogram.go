@@ -0,0 +1,291 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Channels a spectrogram can be drawn of. Mid is the mono mix.
+const (
+	SpectrogramMid   = "mid"
+	SpectrogramLeft  = "left"
+	SpectrogramRight = "right"
+	SpectrogramSide  = "side"
+)
+
+// Frequency axes of a spectrogram.
+const (
+	SpectrogramLog    = "log"
+	SpectrogramLinear = "linear"
+)
+
+const (
+	// spectrogramMinFreq is the lowest frequency of a logarithmic axis.
+	spectrogramMinFreq = 20.0
+	// spectrogramRangeDB is the range drawn, from 0 dBFS down. It is fixed so
+	// that images of different files can be compared.
+	spectrogramRangeDB = 120.0
+	// spectrogramCacheSize is the size the spectrogram cache is trimmed to,
+	// dropping the least recently used images first.
+	spectrogramCacheSize = 256 << 20
+)
+
+// spectrogramColormaps are colour stops from silence to full scale.
+var spectrogramColormaps = map[string][]uint32{
+	"magma":   {0x000004, 0x180f3d, 0x440f76, 0x721f81, 0x9e2f7f, 0xcd4071, 0xf1605d, 0xfd9668, 0xfeca8d, 0xfcfdbf},
+	"inferno": {0x000004, 0x1b0c41, 0x4a0c6b, 0x781c6d, 0xa52c60, 0xcf4446, 0xed6925, 0xfb9b06, 0xf7d13d, 0xfcffa4},
+	"viridis": {0x440154, 0x482878, 0x3e4989, 0x31688e, 0x26828e, 0x1f9e89, 0x35b779, 0x6ece58, 0xb5de2b, 0xfde725},
+	"spek":    {0x000000, 0x2b0054, 0x6b0070, 0xb10047, 0xe63b00, 0xff9a00, 0xffe94a, 0xffffff},
+	"gray":    {0x000000, 0xffffff},
+}
+
+// SpectrogramOptions select how a spectrogram is drawn. Empty fields take
+// their defaults: 1024x512, magma, a log frequency axis and the mid channel.
+type SpectrogramOptions struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Colormap string `json:"colormap"`
+	Scale    string `json:"scale"`
+	Channel  string `json:"channel"`
+}
+
+// Normalize fills in the defaults and checks the options.
+func (o *SpectrogramOptions) Normalize() error {
+	if o.Width == 0 {
+		o.Width = 1024
+	}
+	if o.Height == 0 {
+		o.Height = 512
+	}
+	if o.Colormap == "" {
+		o.Colormap = "magma"
+	}
+	if o.Scale == "" {
+		o.Scale = SpectrogramLog
+	}
+	if o.Channel == "" {
+		o.Channel = SpectrogramMid
+	}
+
+	if o.Width < 64 || o.Width > 4096 || o.Height < 64 || o.Height > 2048 {
+		return fmt.Errorf("size must be between 64x64 and 4096x2048")
+	}
+	if _, ok := spectrogramColormaps[o.Colormap]; !ok {
+		return fmt.Errorf("unknown colormap: %s", o.Colormap)
+	}
+	if o.Scale != SpectrogramLog && o.Scale != SpectrogramLinear {
+		return fmt.Errorf("scale must be log or linear")
+	}
+	switch o.Channel {
+	case SpectrogramMid, SpectrogramLeft, SpectrogramRight, SpectrogramSide:
+	default:
+		return fmt.Errorf("channel must be left, right, mid or side")
+	}
+	return nil
+}
+
+// SpectrogramPNG renders the spectrogram of an audio file and returns the
+// path of the PNG in cacheDir. Images are keyed by the SHA-256 of the file
+// and the options, so each file is only decoded once per set of options.
+// The cache is kept under spectrogramCacheSize.
+func SpectrogramPNG(ctx context.Context, path, cacheDir string, opts SpectrogramOptions) (string, error) {
+	if err := opts.Normalize(); err != nil {
+		return "", err
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	cached := filepath.Join(cacheDir, fmt.Sprintf("%s-%dx%d-%s-%s-%s.png", hash, opts.Width, opts.Height, opts.Colormap, opts.Scale, opts.Channel))
+	if fileExists(cached) {
+		// The modification time orders the cache by last use
+		now := time.Now()
+		os.Chtimes(cached, now, now)
+		return cached, nil
+	}
+
+	img, err := RenderSpectrogram(ctx, path, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create spectrogram cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(cacheDir, "spectrogram-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create spectrogram file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = png.Encode(tmp, img)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write spectrogram: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", fmt.Errorf("failed to write spectrogram: %w", err)
+	}
+	trimSpectrogramCache(cacheDir, cached, spectrogramCacheSize)
+	return cached, nil
+}
+
+// trimSpectrogramCache removes the least recently used images from cacheDir
+// until the rest fit in maxBytes. keep, the image just written, is never
+// removed.
+func trimSpectrogramCache(cacheDir, keep string, maxBytes int64) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+
+	type cachedImage struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var images []cachedImage
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".png" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		images = append(images, cachedImage{filepath.Join(cacheDir, entry.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	slices.SortFunc(images, func(a, b cachedImage) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, img := range images {
+		if total <= maxBytes {
+			break
+		}
+		if img.path == keep {
+			continue
+		}
+		if err := os.Remove(img.path); err == nil {
+			total -= img.size
+		}
+	}
+}
+
+// RenderSpectrogram decodes an audio file and draws its spectrogram, time
+// from left to right and frequency from the bottom up.
+func RenderSpectrogram(ctx context.Context, path string, opts SpectrogramOptions) (*image.RGBA, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+
+	decoder, err := openAudio(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+
+	format := decoder.Format()
+	if opts.Channel == SpectrogramSide && format.channels < 2 {
+		return nil, fmt.Errorf("the side channel needs a stereo file")
+	}
+
+	// Each slice is reduced to one level per image row while decoding, so
+	// memory grows with the image and not with the FFT size
+	rows := spectrogramRows(float64(format.sampleRate)/2, spectrumFFTSize/2, opts)
+	reduce := func(magnitudes []float64) []float64 {
+		levels := make([]float64, len(rows))
+		for y, bins := range rows {
+			levels[y] = slices.Max(magnitudes[bins[0]:bins[1]])
+		}
+		return levels
+	}
+
+	analysis, err := analyzeDecoder(decoder, analysisOptions{spectrum: true, slices: opts.Width, channel: opts.Channel, reduce: reduce})
+	if err != nil {
+		return nil, err
+	}
+	spectrum := analysis.spectrum
+	if len(spectrum.TimeSlices) == 0 {
+		return nil, fmt.Errorf("track is too short for a spectrogram")
+	}
+
+	// A full scale sine peaks at a quarter of the FFT length behind the Hann
+	// window
+	reference := 20 * math.Log10(float64(int64(1)<<(format.sampleBits-1))*spectrumFFTSize/4)
+	stops := spectrogramColormaps[opts.Colormap]
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	for x := 0; x < opts.Width; x++ {
+		slice := spectrum.TimeSlices[x*len(spectrum.TimeSlices)/opts.Width]
+		for y, level := range slice.Magnitudes {
+			img.SetRGBA(x, y, colormapColor(stops, 1+(level-reference)/spectrogramRangeDB))
+		}
+	}
+	return img, nil
+}
+
+// spectrogramRows returns the range of frequency bins each row of the image
+// covers, top row first. Rows narrower than a bin show the bin they fall in.
+func spectrogramRows(maxFreq float64, freqBins int, opts SpectrogramOptions) [][2]int {
+	binHz := maxFreq / float64(freqBins)
+	frequency := func(t float64) float64 {
+		if opts.Scale == SpectrogramLog {
+			return spectrogramMinFreq * math.Pow(maxFreq/spectrogramMinFreq, t)
+		}
+		return t * maxFreq
+	}
+
+	rows := make([][2]int, opts.Height)
+	for y := range rows {
+		high := frequency(1 - float64(y)/float64(opts.Height))
+		low := frequency(1 - float64(y+1)/float64(opts.Height))
+		first := min(freqBins-1, int(low/binHz))
+		last := min(freqBins, max(first+1, int(math.Ceil(high/binHz))))
+		rows[y] = [2]int{first, last}
+	}
+	return rows
+}
+
+// colormapColor interpolates between colour stops; t runs from 0 to 1.
+func colormapColor(stops []uint32, t float64) color.RGBA {
+	pos := min(1, max(0, t)) * float64(len(stops)-1)
+	i := min(int(pos), len(stops)-2)
+	frac := pos - float64(i)
+	channel := func(shift uint) uint8 {
+		a := float64(stops[i] >> shift & 0xff)
+		b := float64(stops[i+1] >> shift & 0xff)
+		return uint8(math.Round(a + (b-a)*frac))
+	}
+	return color.RGBA{R: channel(16), G: channel(8), B: channel(0), A: 255}
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -1,11 +1,11 @@
 package backend
 
 import (
-	"fmt"
+	"context"
 	"math"
+	"math/bits"
 	"math/cmplx"
-
-	"github.com/mewkiz/flac"
+	"sync"
 )
 
 type SpectrumData struct {
@@ -21,161 +21,175 @@ type TimeSlice struct {
 	Magnitudes []float64 `json:"magnitudes"`
 }
 
-func AnalyzeSpectrum(filepath string) (*SpectrumData, error) {
+const (
+	// spectrumFFTSize is the FFT length of a time slice.
+	spectrumFFTSize = 8192
+	// spectrumSlices is how many time slices are spread over a track unless
+	// asked otherwise.
+	spectrumSlices = 300
+)
 
-	stream, err := flac.ParseFile(filepath)
+func AnalyzeSpectrum(ctx context.Context, filepath string) (*SpectrumData, error) {
+	analysis, err := analyzeAudio(ctx, filepath, analysisOptions{spectrum: true})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse FLAC: %w", err)
+		return nil, err
 	}
-	defer stream.Close()
+	return analysis.spectrum, nil
+}
 
-	info := stream.Info
-	sampleRate := int(info.SampleRate)
-	channels := int(info.NChannels)
+// sampleStats describes the raw samples a spectrum was computed from. usedBits
+// is lower than bitsPerSample when the low bits are zero padding.
+type sampleStats struct {
+	bitsPerSample int
+	usedBits      int
+}
 
-	samples, err := readSamples(stream, channels)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read samples: %w", err)
-	}
+// spectrumBuilder computes time slices while samples stream in, keeping only
+// the samples of the slice being filled. Slices are spread evenly when the
+// length of the track is known. Otherwise they are taken back to back, and
+// every other one is dropped whenever there are too many.
+type spectrumBuilder struct {
+	plan       *fftPlan
+	sampleRate int
+	hop        int64
+	count      int
+	thin       bool
+	pos        int64
+	window     []float64
+	filled     int
+	buf        []complex128
+	slices     []TimeSlice
+	// reduce, if set, replaces the magnitudes of a slice before it is kept,
+	// so the full FFT output is never stored
+	reduce     func(magnitudes []float64) []float64
+	magnitudes []float64
+}
 
-	if len(samples) == 0 {
-		return nil, fmt.Errorf("no audio samples found")
+// newSpectrumBuilder spreads count slices over a track of totalSamples.
+func newSpectrumBuilder(sampleRate int, totalSamples int64, count int) *spectrumBuilder {
+	b := &spectrumBuilder{
+		plan:       spectrumPlan(),
+		sampleRate: sampleRate,
+		hop:        spectrumFFTSize,
+		count:      count,
+		thin:       totalSamples <= 0,
+		window:     make([]float64, spectrumFFTSize),
+		buf:        make([]complex128, spectrumFFTSize),
 	}
-
-	return calculateSpectrum(samples, sampleRate), nil
+	if totalSamples > 0 {
+		b.hop = max(spectrumFFTSize, totalSamples/int64(count))
+	}
+	return b
 }
 
-func readSamples(stream *flac.Stream, channels int) ([]float64, error) {
-	var allSamples []float64
-	maxSamples := 10 * 1024 * 1024
-
-	for {
-		frame, err := stream.ParseNext()
-		if err != nil {
-
-			break
-		}
-
-		for i := 0; i < frame.Subframes[0].NSamples; i++ {
-			var sample float64
-
-			for ch := 0; ch < channels; ch++ {
-				sample += float64(frame.Subframes[ch].Samples[i])
-			}
-			sample /= float64(channels)
-
-			allSamples = append(allSamples, sample)
-
-			if len(allSamples) >= maxSamples {
-				return allSamples, nil
-			}
+// add feeds the next sample, mixed down to mono.
+func (b *spectrumBuilder) add(sample float64) {
+	// Slice i starts at i*hop; hop is never shorter than a slice
+	next := int64(len(b.slices)) * b.hop
+	if b.pos >= next && (b.thin || len(b.slices) < b.count) {
+		b.window[b.filled] = sample
+		b.filled++
+		if b.filled == len(b.window) {
+			b.addSlice(next)
+			b.filled = 0
 		}
 	}
-
-	return allSamples, nil
+	b.pos++
 }
 
-func calculateSpectrum(samples []float64, sampleRate int) *SpectrumData {
-	fftSize := 8192
-	numTimeSlices := 300
-
-	duration := float64(len(samples)) / float64(sampleRate)
-
-	samplesPerSlice := len(samples) / numTimeSlices
-	if samplesPerSlice < fftSize {
-		samplesPerSlice = fftSize
-		numTimeSlices = len(samples) / fftSize
+func (b *spectrumBuilder) addSlice(start int64) {
+	for i, x := range b.window {
+		b.buf[i] = complex(x*b.plan.window[i], 0)
 	}
+	b.plan.transform(b.buf)
 
-	timeSlices := make([]TimeSlice, 0, numTimeSlices)
-	freqBins := fftSize / 2
-	maxFreq := float64(sampleRate) / 2.0
-
-	for i := 0; i < numTimeSlices; i++ {
-		startIdx := i * samplesPerSlice
-		if startIdx+fftSize > len(samples) {
-			break
-		}
-
-		window := samples[startIdx : startIdx+fftSize]
-
-		windowedSamples := applyHannWindow(window)
-
-		spectrum := fft(windowedSamples)
-
-		magnitudes := make([]float64, freqBins)
-		for j := 0; j < freqBins; j++ {
-			magnitude := cmplx.Abs(spectrum[j])
-
-			if magnitude < 1e-10 {
-				magnitude = 1e-10
-			}
-			magnitudes[j] = 20 * math.Log10(magnitude)
-		}
-
-		timeSlice := TimeSlice{
-			Time:       float64(startIdx) / float64(sampleRate),
-			Magnitudes: magnitudes,
+	magnitudes := b.magnitudes
+	if b.reduce == nil || magnitudes == nil {
+		magnitudes = make([]float64, len(b.buf)/2)
+	}
+	for j := range magnitudes {
+		magnitudes[j] = 20 * math.Log10(max(cmplx.Abs(b.buf[j]), 1e-10))
+	}
+	if b.reduce != nil {
+		// The full magnitudes are only scratch space
+		b.magnitudes = magnitudes
+		magnitudes = b.reduce(magnitudes)
+	}
+	b.slices = append(b.slices, TimeSlice{
+		Time:       float64(start) / float64(b.sampleRate),
+		Magnitudes: magnitudes,
+	})
+
+	if b.thin && len(b.slices) > b.count {
+		kept := b.slices[:0]
+		for i := 0; i < len(b.slices); i += 2 {
+			kept = append(kept, b.slices[i])
 		}
-		timeSlices = append(timeSlices, timeSlice)
+		clear(b.slices[len(kept):])
+		b.slices = kept
+		b.hop *= 2
 	}
+}
 
+func (b *spectrumBuilder) result() *SpectrumData {
 	return &SpectrumData{
-		TimeSlices: timeSlices,
-		SampleRate: sampleRate,
-		FreqBins:   freqBins,
-		Duration:   duration,
-		MaxFreq:    maxFreq,
+		TimeSlices: b.slices,
+		SampleRate: b.sampleRate,
+		FreqBins:   spectrumFFTSize / 2,
+		Duration:   float64(b.pos) / float64(b.sampleRate),
+		MaxFreq:    float64(b.sampleRate) / 2,
 	}
 }
 
-func applyHannWindow(samples []float64) []float64 {
-	n := len(samples)
-	windowed := make([]float64, n)
-
-	for i := 0; i < n; i++ {
-		window := 0.5 * (1.0 - math.Cos(2.0*math.Pi*float64(i)/float64(n-1)))
-		windowed[i] = samples[i] * window
-	}
-
-	return windowed
+// fftPlan holds the tables of an iterative radix-2 FFT of one size, along
+// with the Hann window applied before it.
+type fftPlan struct {
+	window   []float64
+	twiddles []complex128
+	reversed []int
 }
 
-func fft(samples []float64) []complex128 {
-	n := len(samples)
+var spectrumPlan = sync.OnceValue(func() *fftPlan {
+	return newFFTPlan(spectrumFFTSize)
+})
 
-	x := make([]complex128, n)
-	for i := 0; i < n; i++ {
-		x[i] = complex(samples[i], 0)
+// newFFTPlan precomputes the tables for n-point FFTs. n must be a power of two.
+func newFFTPlan(n int) *fftPlan {
+	p := &fftPlan{
+		window:   make([]float64, n),
+		twiddles: make([]complex128, n/2),
+		reversed: make([]int, n),
 	}
-
-	return fftRecursive(x)
+	for i := range p.window {
+		p.window[i] = 0.5 * (1.0 - math.Cos(2.0*math.Pi*float64(i)/float64(n-1)))
+	}
+	for k := range p.twiddles {
+		p.twiddles[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n)))
+	}
+	shift := bits.UintSize - bits.Len(uint(n-1))
+	for i := range p.reversed {
+		p.reversed[i] = int(bits.Reverse(uint(i)) >> shift)
+	}
+	return p
 }
 
-func fftRecursive(x []complex128) []complex128 {
+// transform replaces x with its discrete Fourier transform. len(x) must be
+// the size of the plan.
+func (p *fftPlan) transform(x []complex128) {
 	n := len(x)
-
-	if n <= 1 {
-		return x
-	}
-
-	even := make([]complex128, n/2)
-	odd := make([]complex128, n/2)
-
-	for i := 0; i < n/2; i++ {
-		even[i] = x[2*i]
-		odd[i] = x[2*i+1]
+	for i, j := range p.reversed {
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
 	}
-
-	evenFFT := fftRecursive(even)
-	oddFFT := fftRecursive(odd)
-
-	result := make([]complex128, n)
-	for k := 0; k < n/2; k++ {
-		t := cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n))) * oddFFT[k]
-		result[k] = evenFFT[k] + t
-		result[k+n/2] = evenFFT[k] - t
+	for size := 2; size <= n; size <<= 1 {
+		half, step := size/2, n/size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				even, odd := &x[start+k], &x[start+k+half]
+				t := p.twiddles[k*step] * *odd
+				*even, *odd = *even+t, *even-t
+			}
+		}
 	}
-
-	return result
 }
@@ -1152,6 +1152,38 @@ func (c *SpotifyMetadataClient) formatArtistDiscographyData(ctx context.Context,
 	}, nil
 }
 
+// GetArtistReleases returns an artist's name and discography without
+// fetching the tracks of every release.
+func (c *SpotifyMetadataClient) GetArtistReleases(ctx context.Context, spotifyURL string) (string, []DiscographyAlbumMetadata, error) {
+	parsed, err := parseSpotifyURI(spotifyURL)
+	if err != nil {
+		return "", nil, err
+	}
+	if parsed.Type != "artist" && parsed.Type != "artist_discography" {
+		return "", nil, fmt.Errorf("not an artist URL: %s", spotifyURL)
+	}
+
+	raw, err := c.fetchArtistDiscography(ctx, parsed)
+	if err != nil {
+		return "", nil, err
+	}
+
+	releases := make([]DiscographyAlbumMetadata, 0, len(raw.Discography.All))
+	for _, alb := range raw.Discography.All {
+		releases = append(releases, DiscographyAlbumMetadata{
+			ID:          alb.ID,
+			Name:        alb.Name,
+			AlbumType:   alb.Type,
+			ReleaseDate: alb.Date,
+			TotalTracks: alb.TotalTracks,
+			Artists:     raw.Name,
+			Images:      alb.Cover,
+			ExternalURL: fmt.Sprintf("https://open.spotify.com/album/%s", alb.ID),
+		})
+	}
+	return raw.Name, releases, nil
+}
+
 func parseDuration(durationStr string) int {
 	if durationStr == "" {
 		return 0
@@ -0,0 +1,321 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	subscriptionsBucket    = "PlaylistSubscriptions"
+	subscriptionRunsBucket = "SubscriptionRuns"
+	maxSubscriptionRuns    = 50
+)
+
+// Subscription is a followed Spotify playlist. Snapshot holds the tracks seen
+// by the last successful sync in playlist order.
+type Subscription struct {
+	ID              string              `json:"id"`
+	URL             string              `json:"url"`
+	Name            string              `json:"name"`
+	Owner           string              `json:"owner,omitempty"`
+	Service         string              `json:"service,omitempty"`
+	AudioFormat     string              `json:"audio_format,omitempty"`
+	IntervalMinutes int                 `json:"interval_minutes"`
+	ArchiveRemoved  bool                `json:"archive_removed"`
+	Enabled         bool                `json:"enabled"`
+	PlaylistDir     string              `json:"playlist_dir,omitempty"`
+	CreatedAt       int64               `json:"created_at"`
+	LastSyncAt      int64               `json:"last_sync_at,omitempty"`
+	LastError       string              `json:"last_error,omitempty"`
+	Snapshot        []SubscriptionTrack `json:"snapshot,omitempty"`
+}
+
+// SubscriptionTrack is a track in a subscription snapshot. Downloaded is set
+// when FilePath was written by one of the subscription's own jobs rather than
+// found already on disk.
+type SubscriptionTrack struct {
+	SpotifyID  string `json:"spotify_id"`
+	Name       string `json:"name"`
+	Artists    string `json:"artists"`
+	FilePath   string `json:"file_path,omitempty"`
+	Downloaded bool   `json:"downloaded,omitempty"`
+}
+
+// SyncRun records the outcome of one subscription sync. Retried counts the
+// tracks of earlier syncs that were queued again because they have no file.
+type SyncRun struct {
+	ID             string `json:"id"`
+	SubscriptionID string `json:"subscription_id"`
+	StartedAt      int64  `json:"started_at"`
+	FinishedAt     int64  `json:"finished_at"`
+	Added          int    `json:"added"`
+	Removed        int    `json:"removed"`
+	Retried        int    `json:"retried"`
+	Archived       int    `json:"archived"`
+	BatchID        string `json:"batch_id,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Due reports whether the subscription should be synced at now.
+func (s *Subscription) Due(now time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	interval := time.Duration(s.IntervalMinutes) * time.Minute
+	return s.LastSyncAt == 0 || now.Sub(time.Unix(s.LastSyncAt, 0)) >= interval
+}
+
+// DiffSnapshot returns the tracks of current that are not in previous and
+// the tracks of previous that are no longer in current, matched by Spotify ID.
+func DiffSnapshot(previous, current []SubscriptionTrack) (added, removed []SubscriptionTrack) {
+	seen := make(map[string]bool, len(previous))
+	for _, track := range previous {
+		seen[track.SpotifyID] = true
+	}
+	kept := make(map[string]bool, len(current))
+	for _, track := range current {
+		kept[track.SpotifyID] = true
+		if !seen[track.SpotifyID] {
+			added = append(added, track)
+		}
+	}
+	for _, track := range previous {
+		if !kept[track.SpotifyID] {
+			removed = append(removed, track)
+		}
+	}
+	return added, removed
+}
+
+// SubscriptionStore persists subscriptions and their sync runs in the
+// history database.
+type SubscriptionStore struct {
+	appName string
+}
+
+func NewSubscriptionStore(appName string) *SubscriptionStore {
+	return &SubscriptionStore{appName: appName}
+}
+
+func (st *SubscriptionStore) ensureDB() error {
+	if historyDB == nil {
+		return InitHistoryDB(st.appName)
+	}
+	return nil
+}
+
+// Add stores a new subscription and assigns its ID.
+func (st *SubscriptionStore) Add(sub *Subscription) error {
+	if err := st.ensureDB(); err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(subscriptionsBucket))
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var existing Subscription
+			if err := json.Unmarshal(v, &existing); err == nil && existing.URL == sub.URL {
+				return fmt.Errorf("playlist is already subscribed")
+			}
+		}
+
+		seq, _ := b.NextSequence()
+		sub.ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), seq)
+		sub.CreatedAt = time.Now().Unix()
+
+		buf, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(sub.ID), buf)
+	})
+}
+
+// Save overwrites an existing subscription.
+func (st *SubscriptionStore) Save(sub *Subscription) error {
+	if err := st.ensureDB(); err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(subscriptionsBucket))
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(sub.ID)) == nil {
+			return fmt.Errorf("subscription not found")
+		}
+		buf, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(sub.ID), buf)
+	})
+}
+
+// Get returns a subscription, or nil when it does not exist.
+func (st *SubscriptionStore) Get(id string) (*Subscription, error) {
+	if err := st.ensureDB(); err != nil {
+		return nil, err
+	}
+	var sub *Subscription
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(subscriptionsBucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		sub = &Subscription{}
+		return json.Unmarshal(v, sub)
+	})
+	return sub, err
+}
+
+// List returns all subscriptions, oldest first.
+func (st *SubscriptionStore) List() ([]Subscription, error) {
+	if err := st.ensureDB(); err != nil {
+		return nil, err
+	}
+	subs := []Subscription{}
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(subscriptionsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var sub Subscription
+			if err := json.Unmarshal(v, &sub); err == nil {
+				subs = append(subs, sub)
+			}
+			return nil
+		})
+	})
+
+	sort.SliceStable(subs, func(i, j int) bool {
+		return subs[i].CreatedAt < subs[j].CreatedAt
+	})
+	return subs, err
+}
+
+// Delete removes a subscription and its sync runs.
+func (st *SubscriptionStore) Delete(id string) (bool, error) {
+	if err := st.ensureDB(); err != nil {
+		return false, err
+	}
+	found := false
+	err := historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(subscriptionsBucket))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return nil
+		}
+		found = true
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+		if runs := tx.Bucket([]byte(subscriptionRunsBucket)); runs != nil && runs.Bucket([]byte(id)) != nil {
+			return runs.DeleteBucket([]byte(id))
+		}
+		return nil
+	})
+	return found, err
+}
+
+// AddRun records a sync run, keeping the most recent maxSubscriptionRuns
+// runs per subscription.
+func (st *SubscriptionStore) AddRun(run *SyncRun) error {
+	if err := st.ensureDB(); err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(subscriptionRunsBucket))
+		if err != nil {
+			return err
+		}
+		b, err := root.CreateBucketIfNotExists([]byte(run.SubscriptionID))
+		if err != nil {
+			return err
+		}
+
+		seq, _ := b.NextSequence()
+		run.ID = fmt.Sprintf("%020d", seq)
+		buf, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(run.ID), buf); err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for len(keys) > maxSubscriptionRuns {
+			if err := b.Delete(keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+}
+
+// SaveRun overwrites a run recorded by AddRun. Runs that were trimmed or
+// deleted with their subscription in the meantime are not recreated.
+func (st *SubscriptionStore) SaveRun(run *SyncRun) error {
+	if err := st.ensureDB(); err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(subscriptionRunsBucket))
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket([]byte(run.SubscriptionID))
+		if b == nil || b.Get([]byte(run.ID)) == nil {
+			return nil
+		}
+		buf, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(run.ID), buf)
+	})
+}
+
+// ListRuns returns the sync runs of a subscription, newest first.
+func (st *SubscriptionStore) ListRuns(id string) ([]SyncRun, error) {
+	if err := st.ensureDB(); err != nil {
+		return nil, err
+	}
+	runs := []SyncRun{}
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(subscriptionRunsBucket))
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var run SyncRun
+			if err := json.Unmarshal(v, &run); err == nil {
+				runs = append(runs, run)
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
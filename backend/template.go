@@ -0,0 +1,445 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Template is a parsed path or filename template. Besides plain placeholders
+// such as {artist} it supports fallbacks for empty fields ({album_artist|artist},
+// where the last alternative may be literal text), modifiers ({artist:first},
+// {title:lower}, {track:03}), optional sections that are dropped when a field
+// inside them is empty, and conditional sections:
+//
+//	{album_artist}/{album}{if total_discs>1}/Disc {disc}{end}
+//	{if playlist}{playlist}{else}{album}{end}
+//	<{track}. >{title} - {artist}< [{explicit}]>
+type Template struct {
+	nodes []templateNode
+}
+
+type templateNode interface{}
+
+type textNode string
+
+// fieldNode is a placeholder; alternatives are tried in order and may be
+// field names or, after the first one, literal text. Modifiers are applied to
+// whichever alternative is used.
+type fieldNode struct {
+	alternatives []string
+	modifiers    []string
+}
+
+// optionalNode is a <...> section, rendered only when every placeholder in it
+// has a value.
+type optionalNode struct {
+	body []templateNode
+}
+
+type ifNode struct {
+	cond     templateCond
+	then     []templateNode
+	elseBody []templateNode
+}
+
+type templateCond struct {
+	field  string
+	negate bool
+	op     string
+	value  string
+}
+
+// templateFields lists the placeholders available to templates.
+var templateFields = map[string]bool{
+	"title":        true,
+	"artist":       true,
+	"album":        true,
+	"album_artist": true,
+	"year":         true,
+	"date":         true,
+	"playlist":     true,
+	"creator":      true,
+	"track":        true,
+	"disc":         true,
+	"total_tracks": true,
+	"total_discs":  true,
+	"isrc":         true,
+	"label":        true,
+	"quality":      true,
+	"explicit":     true,
+}
+
+// templatePadding is the default zero-padding of numeric fields, used unless
+// a width modifier such as {track:03} is given.
+var templatePadding = map[string]int{
+	"track": 2,
+}
+
+var templateOperators = []string{">=", "<=", "!=", "==", ">", "<", "="}
+
+// ParseTemplate parses a template and reports syntax errors such as unknown
+// placeholders or modifiers, an {if} without {end} or an unbalanced <...>.
+func ParseTemplate(src string) (*Template, error) {
+	type frame struct {
+		ifNode   *ifNode
+		optional *optionalNode
+		inElse   bool
+	}
+
+	var root []templateNode
+	var stack []frame
+
+	appendNode := func(n templateNode) {
+		if len(stack) == 0 {
+			root = append(root, n)
+			return
+		}
+		top := &stack[len(stack)-1]
+		switch {
+		case top.optional != nil:
+			top.optional.body = append(top.optional.body, n)
+		case top.inElse:
+			top.ifNode.elseBody = append(top.ifNode.elseBody, n)
+		default:
+			top.ifNode.then = append(top.ifNode.then, n)
+		}
+	}
+	inIf := func() bool {
+		return len(stack) > 0 && stack[len(stack)-1].ifNode != nil
+	}
+
+	rest := src
+	for rest != "" {
+		open := strings.IndexAny(rest, "{<>")
+		if open == -1 {
+			appendNode(textNode(rest))
+			break
+		}
+		if open > 0 {
+			appendNode(textNode(rest[:open]))
+		}
+
+		switch rest[open] {
+		case '<':
+			node := &optionalNode{}
+			appendNode(node)
+			stack = append(stack, frame{optional: node})
+			rest = rest[open+1:]
+			continue
+		case '>':
+			if len(stack) == 0 || stack[len(stack)-1].optional == nil {
+				return nil, fmt.Errorf("unexpected '>' in template")
+			}
+			stack = stack[:len(stack)-1]
+			rest = rest[open+1:]
+			continue
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unclosed '{' in template")
+		}
+		tag := strings.TrimSpace(rest[open+1 : open+end])
+		rest = rest[open+end+1:]
+
+		switch {
+		case tag == "":
+			return nil, fmt.Errorf("empty placeholder in template")
+		case strings.HasPrefix(tag, "if "):
+			cond, err := parseTemplateCond(strings.TrimSpace(tag[3:]))
+			if err != nil {
+				return nil, err
+			}
+			node := &ifNode{cond: cond}
+			appendNode(node)
+			stack = append(stack, frame{ifNode: node})
+		case tag == "else":
+			if !inIf() || stack[len(stack)-1].inElse {
+				return nil, fmt.Errorf("unexpected {else} in template")
+			}
+			stack[len(stack)-1].inElse = true
+		case tag == "end":
+			if !inIf() {
+				return nil, fmt.Errorf("unexpected {end} in template")
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			node, err := parseTemplateField(tag)
+			if err != nil {
+				return nil, err
+			}
+			appendNode(node)
+		}
+	}
+
+	if len(stack) > 0 {
+		if stack[len(stack)-1].optional != nil {
+			return nil, fmt.Errorf("unclosed '<' in template")
+		}
+		return nil, fmt.Errorf("missing {end} in template")
+	}
+	return &Template{nodes: root}, nil
+}
+
+func parseTemplateField(tag string) (fieldNode, error) {
+	var modifiers []string
+	for {
+		idx := strings.LastIndexByte(tag, ':')
+		if idx == -1 {
+			break
+		}
+		modifier := strings.TrimSpace(tag[idx+1:])
+		if !isTemplateModifier(modifier) {
+			// Only the first alternative has to be a field, so a colon in
+			// literal fallback text is kept as is.
+			if !strings.Contains(tag[:idx], "|") {
+				return fieldNode{}, fmt.Errorf("unknown modifier %q in {%s}", modifier, tag)
+			}
+			break
+		}
+		modifiers = append([]string{modifier}, modifiers...)
+		tag = tag[:idx]
+	}
+
+	alternatives := strings.Split(tag, "|")
+	for i := range alternatives {
+		alternatives[i] = strings.TrimSpace(alternatives[i])
+	}
+	if !templateFields[alternatives[0]] {
+		return fieldNode{}, fmt.Errorf("unknown placeholder {%s}", alternatives[0])
+	}
+	return fieldNode{alternatives: alternatives, modifiers: modifiers}, nil
+}
+
+// isTemplateModifier reports whether m is lower, upper, title, first or a
+// zero-padding width such as 03.
+func isTemplateModifier(m string) bool {
+	switch m {
+	case "lower", "upper", "title", "first":
+		return true
+	}
+	if m == "" {
+		return false
+	}
+	for _, r := range m {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseTemplateCond(expr string) (templateCond, error) {
+	var cond templateCond
+	if strings.HasPrefix(expr, "!") {
+		cond.negate = true
+		expr = strings.TrimSpace(expr[1:])
+	}
+
+	cond.field = expr
+	for _, op := range templateOperators {
+		if idx := strings.Index(expr, op); idx != -1 {
+			cond.field = strings.TrimSpace(expr[:idx])
+			cond.op = op
+			cond.value = strings.TrimSpace(expr[idx+len(op):])
+			break
+		}
+	}
+
+	if !templateFields[cond.field] {
+		return cond, fmt.Errorf("unknown field %q in {if}", cond.field)
+	}
+	return cond, nil
+}
+
+// Render expands the template. Empty fields fall back to defaults, and
+// escape, if set, is applied to every substituted value.
+func (t *Template) Render(values, defaults map[string]string, escape func(string) string) string {
+	r := templateRenderer{values: values, defaults: defaults, escape: escape}
+	var b strings.Builder
+	r.render(&b, t.nodes)
+	return b.String()
+}
+
+type templateRenderer struct {
+	values   map[string]string
+	defaults map[string]string
+	escape   func(string) string
+}
+
+// render writes nodes to b and reports whether every placeholder had a value.
+func (r templateRenderer) render(b *strings.Builder, nodes []templateNode) bool {
+	complete := true
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case textNode:
+			b.WriteString(string(n))
+		case fieldNode:
+			value := n.value(r.values)
+			if value == "" {
+				complete = false
+				value = r.defaults[n.alternatives[0]]
+			}
+			value = n.format(value)
+			if r.escape != nil && value != "" {
+				value = r.escape(value)
+			}
+			b.WriteString(value)
+		case *optionalNode:
+			var section strings.Builder
+			if r.render(&section, n.body) {
+				b.WriteString(section.String())
+			}
+		case *ifNode:
+			body := n.elseBody
+			if n.cond.eval(r.values) {
+				body = n.then
+			}
+			if !r.render(b, body) {
+				complete = false
+			}
+		}
+	}
+	return complete
+}
+
+func (n fieldNode) value(values map[string]string) string {
+	for i, alt := range n.alternatives {
+		if templateFields[alt] {
+			if v := values[alt]; v != "" {
+				return v
+			}
+			continue
+		}
+		if i > 0 {
+			return alt
+		}
+	}
+	return ""
+}
+
+// format applies the placeholder's modifiers, or the field's default padding
+// when no width is given.
+func (n fieldNode) format(value string) string {
+	width := templatePadding[n.alternatives[0]]
+	for _, m := range n.modifiers {
+		switch m {
+		case "lower":
+			value = strings.ToLower(value)
+		case "upper":
+			value = strings.ToUpper(value)
+		case "title":
+			value = titleCase(value)
+		case "first":
+			value = GetFirstArtist(value)
+		default:
+			width, _ = strconv.Atoi(m)
+		}
+	}
+	return padNumber(value, width)
+}
+
+// padNumber left-pads value with zeros when it is a non-negative integer.
+func padNumber(value string, width int) string {
+	if width <= len(value) {
+		return value
+	}
+	if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+		return value
+	}
+	return strings.Repeat("0", width-len(value)) + value
+}
+
+// titleCase upper-cases the first letter of every word.
+func titleCase(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		isStart := unicode.IsSpace(prev) || prev == '(' || prev == '[' || prev == '-'
+		prev = r
+		if isStart {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
+
+func (c templateCond) eval(values map[string]string) bool {
+	value := values[c.field]
+
+	var result bool
+	if c.op == "" {
+		result = value != "" && value != "0"
+	} else {
+		result = compareTemplateValues(value, c.op, c.value)
+	}
+
+	if c.negate {
+		return !result
+	}
+	return result
+}
+
+// compareTemplateValues compares numerically when the right-hand side is a
+// number (an empty field counts as 0) and as strings otherwise.
+func compareTemplateValues(left, op, right string) bool {
+	r, rErr := strconv.ParseFloat(right, 64)
+	l, lErr := strconv.ParseFloat(left, 64)
+	if left == "" {
+		l, lErr = 0, nil
+	}
+
+	if rErr == nil && lErr == nil {
+		switch op {
+		case ">":
+			return l > r
+		case "<":
+			return l < r
+		case ">=":
+			return l >= r
+		case "<=":
+			return l <= r
+		case "!=":
+			return l != r
+		default:
+			return l == r
+		}
+	}
+
+	switch op {
+	case "!=":
+		return !strings.EqualFold(left, right)
+	case "==", "=":
+		return strings.EqualFold(left, right)
+	}
+	return false
+}
+
+// Uses reports whether any placeholder or condition refers to one of fields.
+func (t *Template) Uses(fields ...string) bool {
+	return templateNodesUse(t.nodes, fields)
+}
+
+func templateNodesUse(nodes []templateNode, fields []string) bool {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case fieldNode:
+			for _, alt := range n.alternatives {
+				if slices.Contains(fields, alt) {
+					return true
+				}
+			}
+		case *optionalNode:
+			if templateNodesUse(n.body, fields) {
+				return true
+			}
+		case *ifNode:
+			if slices.Contains(fields, n.cond.field) || templateNodesUse(n.then, fields) || templateNodesUse(n.elseBody, fields) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
@@ -53,9 +54,7 @@ type TidalBTSManifest struct {
 func NewTidalDownloader(apiURL string) *TidalDownloader {
 	if apiURL == "" {
 		downloader := &TidalDownloader{
-			client: &http.Client{
-				Timeout: 5 * time.Second,
-			},
+			client:     newProviderClient("tidal", 5*time.Second),
 			timeout:    5 * time.Second,
 			maxRetries: 3,
 			apiURL:     "",
@@ -68,9 +67,7 @@ func NewTidalDownloader(apiURL string) *TidalDownloader {
 	}
 
 	return &TidalDownloader{
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		client:     newProviderClient("tidal", 5*time.Second),
 		timeout:    5 * time.Second,
 		maxRetries: 3,
 		apiURL:     apiURL,
@@ -88,48 +85,13 @@ func (t *TidalDownloader) GetAvailableAPIs() ([]string, error) {
 	return apis, nil
 }
 
-func (t *TidalDownloader) GetTidalURLFromSpotify(spotifyTrackID string) (string, error) {
-
-	spotifyBase := "https://open.spotify.com/track/"
-	spotifyURL := fmt.Sprintf("%s%s", spotifyBase, spotifyTrackID)
-
-	apiBase := "https://api.song.link/v1-alpha.1/links?url="
-	apiURL := fmt.Sprintf("%s%s", apiBase, url.QueryEscape(spotifyURL))
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/144.0.0.0 Safari/537.36")
-
+func (t *TidalDownloader) GetTidalURLFromSpotify(ctx context.Context, spotifyTrackID string) (string, error) {
 	fmt.Println("Getting Tidal URL...")
 
-	resp, err := t.client.Do(req)
+	tidalURL, err := GetSongLinkClient().PlatformURL(ctx, spotifyTrackID, "tidal")
 	if err != nil {
 		return "", fmt.Errorf("failed to get Tidal URL: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("API returned status %d", resp.StatusCode)
-	}
-
-	var songLinkResp struct {
-		LinksByPlatform map[string]struct {
-			URL string `json:"url"`
-		} `json:"linksByPlatform"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&songLinkResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	tidalLink, ok := songLinkResp.LinksByPlatform["tidal"]
-	if !ok || tidalLink.URL == "" {
-		return "", fmt.Errorf("tidal link not found")
-	}
-
-	tidalURL := tidalLink.URL
 	fmt.Printf("Found Tidal URL: %s\n", tidalURL)
 	return tidalURL, nil
 }
@@ -153,13 +115,13 @@ func (t *TidalDownloader) GetTrackIDFromURL(tidalURL string) (int64, error) {
 	return trackID, nil
 }
 
-func (t *TidalDownloader) GetDownloadURL(trackID int64, quality string) (string, error) {
+func (t *TidalDownloader) GetDownloadURL(ctx context.Context, trackID int64, quality string) (string, error) {
 	fmt.Println("Fetching URL...")
 
 	url := fmt.Sprintf("%s/track/?id=%d&quality=%s", t.apiURL, trackID, quality)
 	fmt.Printf("Tidal API URL: %s\n", url)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		fmt.Printf("✗ failed to create request: %v\n", err)
 		return "", fmt.Errorf("failed to create request: %w", err)
@@ -218,93 +180,35 @@ func (t *TidalDownloader) GetDownloadURL(trackID int64, quality string) (string,
 	return "", fmt.Errorf("download URL not found in response")
 }
 
-func (t *TidalDownloader) DownloadFile(url, filepath string) error {
+func (t *TidalDownloader) DownloadFile(ctx context.Context, url, quality, filepath string) error {
 
 	if strings.HasPrefix(url, "MANIFEST:") {
-		return t.DownloadFromManifest(strings.TrimPrefix(url, "MANIFEST:"), filepath)
-	}
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/144.0.0.0 Safari/537.36")
-
-	resp, err := t.client.Do(req)
-
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
-	}
-
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return t.DownloadFromManifest(ctx, strings.TrimPrefix(url, "MANIFEST:"), quality, filepath)
 	}
-	defer out.Close()
 
-	pw := NewProgressWriter(out)
-	_, err = io.Copy(pw, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	if _, err := downloadToFile(ctx, newTransferClient(), url, filepath); err != nil {
+		return err
 	}
 
-	fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
-
 	fmt.Println("Download complete")
 	return nil
 }
 
-func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) error {
+func (t *TidalDownloader) DownloadFromManifest(ctx context.Context, manifestB64, quality, outputPath string) error {
 	directURL, initURL, mediaURLs, mimeType, err := parseManifest(manifestB64)
 	if err != nil {
 		return fmt.Errorf("failed to parse manifest: %w", err)
 	}
 
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-
-	doRequest := func(url string) (*http.Response, error) {
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/144.0.0.0 Safari/537.36")
-		return client.Do(req)
-	}
+	client := newTransferClient()
 
 	if directURL != "" && (strings.Contains(strings.ToLower(mimeType), "flac") || mimeType == "") {
 		fmt.Println("Downloading file...")
 
-		resp, err := doRequest(directURL)
-		if err != nil {
-			return fmt.Errorf("failed to download file: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("download failed with status %d", resp.StatusCode)
-		}
-
-		out, err := os.Create(outputPath)
-		if err != nil {
-			return fmt.Errorf("failed to create file: %w", err)
+		if _, err := downloadToFile(ctx, client, directURL, outputPath); err != nil {
+			return err
 		}
-		defer out.Close()
 
-		pw := NewProgressWriter(out)
-		_, err = io.Copy(pw, resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to write file: %w", err)
-		}
-
-		fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
 		fmt.Println("Download complete")
 		return nil
 	}
@@ -314,109 +218,13 @@ func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) e
 	if directURL != "" {
 		fmt.Printf("Downloading non-FLAC file (%s)...\n", mimeType)
 
-		resp, err := doRequest(directURL)
-		if err != nil {
-			return fmt.Errorf("failed to download file: %w", err)
+		if _, err := downloadToFile(ctx, client, directURL, tempPath); err != nil {
+			return fmt.Errorf("failed to download temp file: %w", err)
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("download failed with status %d", resp.StatusCode)
-		}
-
-		out, err := os.Create(tempPath)
-		if err != nil {
-			return fmt.Errorf("failed to create temp file: %w", err)
-		}
-
-		pw := NewProgressWriter(out)
-		_, err = io.Copy(pw, resp.Body)
-		out.Close()
-
-		if err != nil {
-			os.Remove(tempPath)
-			return fmt.Errorf("failed to write temp file: %w", err)
-		}
-
-		fmt.Printf("\rDownloaded: %.2f MB (Complete)\n", float64(pw.GetTotal())/(1024*1024))
-
 	} else {
-
-		fmt.Printf("Downloading %d segments...\n", len(mediaURLs)+1)
-
-		out, err := os.Create(tempPath)
-		if err != nil {
-			return fmt.Errorf("failed to create temp file: %w", err)
-		}
-
-		fmt.Print("Downloading init segment... ")
-		resp, err := doRequest(initURL)
-		if err != nil {
-			out.Close()
-			os.Remove(tempPath)
-			return fmt.Errorf("failed to download init segment: %w", err)
-		}
-		if resp.StatusCode != 200 {
-			resp.Body.Close()
-			out.Close()
-			os.Remove(tempPath)
-			return fmt.Errorf("init segment download failed with status %d", resp.StatusCode)
-		}
-		_, err = io.Copy(out, resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			out.Close()
-			os.Remove(tempPath)
-			return fmt.Errorf("failed to write init segment: %w", err)
+		if err := downloadSegments(ctx, client, initURL, mediaURLs, quality, tempPath); err != nil {
+			return err
 		}
-		fmt.Println("OK")
-
-		totalSegments := len(mediaURLs)
-		var totalBytes int64
-		lastTime := time.Now()
-		var lastBytes int64
-		for i, mediaURL := range mediaURLs {
-			resp, err := doRequest(mediaURL)
-			if err != nil {
-				out.Close()
-				os.Remove(tempPath)
-				return fmt.Errorf("failed to download segment %d: %w", i+1, err)
-			}
-			if resp.StatusCode != 200 {
-				resp.Body.Close()
-				out.Close()
-				os.Remove(tempPath)
-				return fmt.Errorf("segment %d download failed with status %d", i+1, resp.StatusCode)
-			}
-			n, err := io.Copy(out, resp.Body)
-			totalBytes += n
-			resp.Body.Close()
-			if err != nil {
-				out.Close()
-				os.Remove(tempPath)
-				return fmt.Errorf("failed to write segment %d: %w", i+1, err)
-			}
-
-			mbDownloaded := float64(totalBytes) / (1024 * 1024)
-			now := time.Now()
-			timeDiff := now.Sub(lastTime).Seconds()
-			var speedMBps float64
-			if timeDiff > 0.1 {
-				bytesDiff := float64(totalBytes - lastBytes)
-				speedMBps = (bytesDiff / (1024 * 1024)) / timeDiff
-				SetDownloadSpeed(speedMBps)
-				lastTime = now
-				lastBytes = totalBytes
-			}
-			SetDownloadProgress(mbDownloaded)
-
-			fmt.Printf("\rDownloading: %.2f MB (%d/%d segments)", mbDownloaded, i+1, totalSegments)
-		}
-
-		out.Close()
-
-		tempInfo, _ := os.Stat(tempPath)
-		fmt.Printf("\rDownloaded: %.2f MB (Complete)          \n", float64(tempInfo.Size())/(1024*1024))
 	}
 
 	fmt.Println("Converting to FLAC...")
@@ -429,11 +237,16 @@ func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) e
 		return fmt.Errorf("invalid ffmpeg executable: %w", err)
 	}
 
-	cmd := exec.Command(ffmpegPath, "-y", "-i", tempPath, "-vn", "-c:a", "flac", outputPath)
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-y", "-i", tempPath, "-vn", "-c:a", "flac", outputPath)
 	setHideWindow(cmd)
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			os.Remove(tempPath)
+			os.Remove(outputPath)
+			return ctx.Err()
+		}
 
 		m4aPath := strings.TrimSuffix(outputPath, ".flac") + ".m4a"
 		os.Rename(tempPath, m4aPath)
@@ -446,148 +259,112 @@ func (t *TidalDownloader) DownloadFromManifest(manifestB64, outputPath string) e
 	return nil
 }
 
-func (t *TidalDownloader) DownloadByURL(tidalURL, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool, useFirstArtistOnly bool) (string, error) {
-	if outputDir != "." {
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return "", fmt.Errorf("directory error: %w", err)
-		}
-	}
-
-	fmt.Printf("Using Tidal URL: %s\n", tidalURL)
+func (t *TidalDownloader) Name() string {
+	return "tidal"
+}
 
-	trackID, err := t.GetTrackIDFromURL(tidalURL)
-	if err != nil {
-		return "", err
-	}
+func (t *TidalDownloader) Qualities() []string {
+	return []string{"HI_RES_LOSSLESS", "HI_RES", "LOSSLESS"}
+}
 
-	if trackID == 0 {
-		return "", fmt.Errorf("no track ID found")
+// Resolve collects candidates from song.link, an ISRC search and, when none
+// of those match, a title search, and returns the best scoring one.
+func (t *TidalDownloader) Resolve(ctx context.Context, req TrackRequest) (*ResolvedTrack, error) {
+	apis, _ := t.GetAvailableAPIs()
+	var candidates []tidalTrack
+
+	// song.link can point at another version of the track, so its result is
+	// scored like any search result
+	if tidalURL, err := t.GetTidalURLFromSpotify(ctx, req.SpotifyID); err == nil {
+		if trackID, err := t.GetTrackIDFromURL(tidalURL); err == nil {
+			info, err := fetchTidalTrackInfo(ctx, apis, trackID)
+			if err == nil {
+				candidates = append(candidates, info.Data)
+			} else {
+				fmt.Printf("⚠ Failed to fetch Tidal track %d: %v\n", trackID, err)
+			}
+		}
 	}
-
-	artistName := spotifyArtistName
-	trackTitle := spotifyTrackName
-	albumTitle := spotifyAlbumName
-
-	artistNameForFile := sanitizeFilename(artistName)
-	albumArtistForFile := sanitizeFilename(spotifyAlbumArtist)
-
-	if useFirstArtistOnly {
-		artistNameForFile = sanitizeFilename(GetFirstArtist(artistName))
-		albumArtistForFile = sanitizeFilename(GetFirstArtist(spotifyAlbumArtist))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
 
-	trackTitleForFile := sanitizeFilename(trackTitle)
-	albumTitleForFile := sanitizeFilename(albumTitle)
-
-	filename := buildTidalFilename(trackTitleForFile, artistNameForFile, albumTitleForFile, albumArtistForFile, spotifyReleaseDate, spotifyTrackNumber, spotifyDiscNumber, filenameFormat, includeTrackNumber, position, useAlbumTrackNumber)
-	outputFilename := filepath.Join(outputDir, filename)
-
-	if fileInfo, err := os.Stat(outputFilename); err == nil && fileInfo.Size() > 0 {
-		fmt.Printf("File already exists: %s (%.2f MB)\n", outputFilename, float64(fileInfo.Size())/(1024*1024))
-		return "EXISTS:" + outputFilename, nil
+	matchReq := req
+	if matchReq.ISRC == "" && req.SpotifyID != "" {
+		matchReq.ISRC, _ = GetSongLinkClient().GetISRC(ctx, req.SpotifyID)
 	}
-
-	downloadURL, err := t.GetDownloadURL(trackID, quality)
-	if err != nil {
-		if quality == "HI_RES" && allowFallback {
-			fmt.Println("⚠ HI_RES unavailable/failed, falling back to LOSSLESS...")
-			downloadURL, err = t.GetDownloadURL(trackID, "LOSSLESS")
-			if err != nil {
-				return "", fmt.Errorf("failed to get download URL (HI_RES & LOSSLESS both failed): %w", err)
-			}
-		} else {
-			return "", err
+	if matchReq.ISRC != "" {
+		fmt.Printf("Searching Tidal for ISRC: %s\n", matchReq.ISRC)
+		more, err := searchTidalTracks(ctx, apis, "i", matchReq.ISRC)
+		if err != nil {
+			fmt.Printf("⚠ Tidal ISRC search failed: %v\n", err)
 		}
+		candidates = append(candidates, more...)
 	}
 
-	isrcChan := make(chan string, 1)
-	if spotifyURL != "" {
-		go func() {
-			var isrc string
-			parts := strings.Split(spotifyURL, "/")
-			if len(parts) > 0 {
-				sID := strings.Split(parts[len(parts)-1], "?")[0]
-				if sID != "" {
-					client := NewSongLinkClient()
-					if val, err := client.GetISRC(sID); err == nil {
-						isrc = val
-					}
-				}
-			}
-			isrcChan <- isrc
-		}()
-	} else {
-		close(isrcChan)
+	track, match, err := bestTidalCandidate(matchReq, candidates)
+	if err != nil && req.TrackName != "" && ctx.Err() == nil {
+		// The ISRC may belong to another release; fall back to a text search
+		query := strings.TrimSpace(req.TrackName + " " + req.ArtistName)
+		if more, searchErr := searchTidalTracks(ctx, apis, "s", query); searchErr == nil && len(more) > 0 {
+			candidates = append(candidates, more...)
+			track, match, err = bestTidalCandidate(matchReq, candidates)
+		}
 	}
-
-	fmt.Printf("Downloading to: %s\n", outputFilename)
-	if err := t.DownloadFile(downloadURL, outputFilename); err != nil {
-		return "", err
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
-
-	var isrc string
-	if spotifyURL != "" {
-		isrc = <-isrcChan
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("Adding metadata...")
-
-	coverPath := ""
-
-	if spotifyCoverURL != "" {
-		coverPath = outputFilename + ".cover.jpg"
-		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
-			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
-			coverPath = ""
-		} else {
-			defer os.Remove(coverPath)
-			fmt.Println("Spotify cover downloaded")
-		}
+	isrc := track.ISRC
+	if isrc == "" {
+		isrc = matchReq.ISRC
 	}
+	return &ResolvedTrack{
+		Provider: t.Name(),
+		ID:       fmt.Sprintf("%d", track.ID),
+		URL:      fmt.Sprintf("https://tidal.com/browse/track/%d", track.ID),
+		ISRC:     isrc,
+		Match:    match,
+	}, nil
+}
 
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
+// bestTidalCandidate picks the track that best matches the request.
+func bestTidalCandidate(req TrackRequest, tracks []tidalTrack) (*tidalTrack, *TrackMatch, error) {
+	if len(tracks) == 0 {
+		return nil, nil, fmt.Errorf("%w on Tidal", errTrackNotFound)
 	}
-
-	metadata := Metadata{
-		Title:       trackTitle,
-		Artist:      artistName,
-		Album:       albumTitle,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
-		ISRC:        isrc,
+	candidates := make([]MatchCandidate, len(tracks))
+	for i := range tracks {
+		candidates[i] = tracks[i].matchCandidate()
 	}
 
-	if err := EmbedMetadata(outputFilename, metadata, coverPath); err != nil {
-		fmt.Printf("Tagging failed: %v\n", err)
-	} else {
-		fmt.Println("Metadata saved")
+	i, match, err := BestMatch("tidal", req, candidates)
+	if err != nil {
+		return nil, match, err
 	}
+	return &tracks[i], match, nil
+}
 
-	fmt.Println("Done")
-	fmt.Println("✓ Downloaded successfully from Tidal")
-	return outputFilename, nil
+func (t *TidalDownloader) Fetch(ctx context.Context, track *ResolvedTrack, req TrackRequest) (*DownloadResult, error) {
+	return t.DownloadByURLWithFallback(ctx, track.URL, track.ISRC, req)
 }
 
-func (t *TidalDownloader) DownloadByURLWithFallback(tidalURL, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool, useFirstArtistOnly bool) (string, error) {
+// DownloadByURLWithFallback downloads a Tidal track, trying every available
+// API. isrc is the track's ISRC when already known; otherwise it is looked up
+// for the tags.
+func (t *TidalDownloader) DownloadByURLWithFallback(ctx context.Context, tidalURL, isrc string, req TrackRequest) (*DownloadResult, error) {
 	apis, err := t.GetAvailableAPIs()
 	if err != nil {
-		return "", fmt.Errorf("no APIs available for fallback: %w", err)
+		return nil, fmt.Errorf("no APIs available for fallback: %w", err)
 	}
 
+	outputDir := req.OutputDir
 	if outputDir != "." {
 		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return "", fmt.Errorf("directory error: %w", err)
+			return nil, fmt.Errorf("directory error: %w", err)
 		}
 	}
 
@@ -595,88 +372,65 @@ func (t *TidalDownloader) DownloadByURLWithFallback(tidalURL, outputDir, quality
 
 	trackID, err := t.GetTrackIDFromURL(tidalURL)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if trackID == 0 {
-		return "", fmt.Errorf("no track ID found")
+		return nil, fmt.Errorf("no track ID found")
 	}
 
-	artistName := spotifyArtistName
-	trackTitle := spotifyTrackName
-	albumTitle := spotifyAlbumName
-
-	artistNameForFile := sanitizeFilename(artistName)
-	albumArtistForFile := sanitizeFilename(spotifyAlbumArtist)
-
-	if useFirstArtistOnly {
-		artistNameForFile = sanitizeFilename(GetFirstArtist(artistName))
-		albumArtistForFile = sanitizeFilename(GetFirstArtist(spotifyAlbumArtist))
-	}
-
-	trackTitleForFile := sanitizeFilename(trackTitle)
-	albumTitleForFile := sanitizeFilename(albumTitle)
-
-	filename := buildTidalFilename(trackTitleForFile, artistNameForFile, albumTitleForFile, albumArtistForFile, spotifyReleaseDate, spotifyTrackNumber, spotifyDiscNumber, filenameFormat, includeTrackNumber, position, useAlbumTrackNumber)
-	outputFilename := filepath.Join(outputDir, filename)
+	outputFilename := filepath.Join(outputDir, tidalFilenameForRequest(req, isrc))
 
 	if fileInfo, err := os.Stat(outputFilename); err == nil && fileInfo.Size() > 0 {
 		fmt.Printf("File already exists: %s (%.2f MB)\n", outputFilename, float64(fileInfo.Size())/(1024*1024))
-		return "EXISTS:" + outputFilename, nil
+		return &DownloadResult{FilePath: outputFilename, AlreadyExists: true, Provider: t.Name()}, nil
 	}
 
-	successAPI, downloadURL, err := getDownloadURLRotated(apis, trackID, quality)
+	quality := req.Quality
+	successAPI, downloadURL, err := getDownloadURLRotated(ctx, apis, trackID, quality)
 	if err != nil {
-		if quality == "HI_RES" && allowFallback {
-			fmt.Println("⚠ HI_RES unavailable/failed on all APIs, falling back to LOSSLESS...")
-			successAPI, downloadURL, err = getDownloadURLRotated(apis, trackID, "LOSSLESS")
+		if strings.HasPrefix(quality, "HI_RES") && req.AllowFallback {
+			fmt.Printf("⚠ %s unavailable/failed on all APIs, falling back to LOSSLESS...\n", quality)
+			quality = "LOSSLESS"
+			successAPI, downloadURL, err = getDownloadURLRotated(ctx, apis, trackID, quality)
 			if err != nil {
-				return "", fmt.Errorf("failed to get download URL (HI_RES & LOSSLESS both failed): %w", err)
+				return nil, fmt.Errorf("failed to get download URL (%s & LOSSLESS both failed): %w", req.Quality, err)
 			}
 		} else {
-			return "", err
+			return nil, err
 		}
 	}
 
-	isrcChan := make(chan string, 1)
-	if spotifyURL != "" {
-		go func() {
-			var isrc string
-			parts := strings.Split(spotifyURL, "/")
-			if len(parts) > 0 {
-				sID := strings.Split(parts[len(parts)-1], "?")[0]
-				if sID != "" {
-					client := NewSongLinkClient()
-					if val, err := client.GetISRC(sID); err == nil {
-						isrc = val
-					}
-				}
-			}
-			isrcChan <- isrc
-		}()
-	} else {
-		close(isrcChan)
+	var isrcChan <-chan string
+	if isrc == "" {
+		isrcChan = lookupISRCAsync(ctx, req.SpotifyURL)
 	}
 
 	fmt.Printf("Downloading to: %s\n", outputFilename)
 	downloader := NewTidalDownloader(successAPI)
-	if err := downloader.DownloadFile(downloadURL, outputFilename); err != nil {
-		return "", err
+	if err := downloader.DownloadFile(ctx, downloadURL, quality, outputFilename); err != nil {
+		return nil, err
 	}
 
-	var isrc string
-	if spotifyURL != "" {
+	if isrcChan != nil {
 		isrc = <-isrcChan
 	}
+	t.tagDownloadedFile(outputFilename, req, isrc)
 
+	fmt.Println("Done")
+	fmt.Println("✓ Downloaded successfully from Tidal")
+	return &DownloadResult{FilePath: outputFilename, Provider: t.Name(), Quality: quality}, nil
+}
+
+func (t *TidalDownloader) tagDownloadedFile(outputFilename string, req TrackRequest, isrc string) {
 	fmt.Println("Adding metadata...")
 
 	coverPath := ""
 
-	if spotifyCoverURL != "" {
+	if req.CoverURL != "" {
 		coverPath = outputFilename + ".cover.jpg"
 		coverClient := NewCoverClient()
-		if err := coverClient.DownloadCoverToPath(spotifyCoverURL, coverPath, embedMaxQualityCover); err != nil {
+		if err := coverClient.DownloadCoverToPath(req.CoverURL, coverPath, req.EmbedMaxQualityCover); err != nil {
 			fmt.Printf("Warning: Failed to download Spotify cover: %v\n", err)
 			coverPath = ""
 		} else {
@@ -685,47 +439,19 @@ func (t *TidalDownloader) DownloadByURLWithFallback(tidalURL, outputDir, quality
 		}
 	}
 
-	trackNumberToEmbed := spotifyTrackNumber
-	if trackNumberToEmbed == 0 {
-		trackNumberToEmbed = 1
-	}
-
-	metadata := Metadata{
-		Title:       trackTitle,
-		Artist:      artistName,
-		Album:       albumTitle,
-		AlbumArtist: spotifyAlbumArtist,
-		Date:        spotifyReleaseDate,
-		TrackNumber: trackNumberToEmbed,
-		TotalTracks: spotifyTotalTracks,
-		DiscNumber:  spotifyDiscNumber,
-		TotalDiscs:  spotifyTotalDiscs,
-		URL:         spotifyURL,
-		Copyright:   spotifyCopyright,
-		Publisher:   spotifyPublisher,
-		Description: "https://github.com/afkarxyz/SpotiFLAC",
-		ISRC:        isrc,
-	}
-
-	if err := EmbedMetadata(outputFilename, metadata, coverPath); err != nil {
+	if err := EmbedMetadata(outputFilename, metadataFromRequest(req, isrc), coverPath); err != nil {
 		fmt.Printf("Tagging failed: %v\n", err)
 	} else {
 		fmt.Println("Metadata saved")
 	}
-
-	fmt.Println("Done")
-	fmt.Println("✓ Downloaded successfully from Tidal")
-	return outputFilename, nil
 }
 
-func (t *TidalDownloader) Download(spotifyTrackID, outputDir, quality, filenameFormat string, includeTrackNumber bool, position int, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate string, useAlbumTrackNumber bool, spotifyCoverURL string, embedMaxQualityCover bool, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks int, spotifyTotalDiscs int, spotifyCopyright, spotifyPublisher, spotifyURL string, allowFallback bool, useFirstArtistOnly bool) (string, error) {
-
-	tidalURL, err := t.GetTidalURLFromSpotify(spotifyTrackID)
-	if err != nil {
-		return "", fmt.Errorf("songlink couldn't find Tidal URL: %w", err)
+func tidalFilenameForRequest(req TrackRequest, isrc string) string {
+	filenameData := filenameDataForRequest(req)
+	if filenameData.ISRC == "" {
+		filenameData.ISRC = isrc
 	}
-
-	return t.DownloadByURLWithFallback(tidalURL, outputDir, quality, filenameFormat, includeTrackNumber, position, spotifyTrackName, spotifyArtistName, spotifyAlbumName, spotifyAlbumArtist, spotifyReleaseDate, useAlbumTrackNumber, spotifyCoverURL, embedMaxQualityCover, spotifyTrackNumber, spotifyDiscNumber, spotifyTotalTracks, spotifyTotalDiscs, spotifyCopyright, spotifyPublisher, spotifyURL, allowFallback, useFirstArtistOnly)
+	return BuildFilename(req.FilenameFormat, filenameData, req.IncludeTrackNumber, ".flac")
 }
 
 type SegmentTemplate struct {
@@ -890,7 +616,7 @@ func parseManifest(manifestB64 string) (directURL string, initURL string, mediaU
 	return "", initURL, mediaURLs, "", nil
 }
 
-func getDownloadURLRotated(apis []string, trackID int64, quality string) (string, string, error) {
+func getDownloadURLRotated(ctx context.Context, apis []string, trackID int64, quality string) (string, string, error) {
 	if len(apis) == 0 {
 		return "", "", fmt.Errorf("no APIs available")
 	}
@@ -904,14 +630,20 @@ func getDownloadURLRotated(apis []string, trackID int64, quality string) (string
 	var errors []string
 
 	for _, apiURL := range apis {
+		if err := ctx.Err(); err != nil {
+			return "", "", err
+		}
+
 		fmt.Printf("Trying API: %s\n", apiURL)
 
-		client := &http.Client{
-			Timeout: 15 * time.Second,
-		}
+		client := newProviderClient("tidal", 15*time.Second)
 
 		url := fmt.Sprintf("%s/track/?id=%d&quality=%s", apiURL, trackID, quality)
-		resp, err := client.Get(url)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return "", "", err
+		}
+		resp, err := client.Do(req)
 		if err != nil {
 			lastError = err
 			errors = append(errors, fmt.Sprintf("%s: %v", apiURL, err))
@@ -961,56 +693,108 @@ func getDownloadURLRotated(apis []string, trackID int64, quality string) (string
 	return "", "", fmt.Errorf("all %d APIs failed. Last error: %v", len(apis), lastError)
 }
 
-func buildTidalFilename(title, artist, album, albumArtist, releaseDate string, trackNumber, discNumber int, format string, includeTrackNumber bool, position int, useAlbumTrackNumber bool) string {
-	var filename string
+// tidalTrack is a track as returned by the Tidal APIs' info and search
+// endpoints.
+type tidalTrack struct {
+	ID            int64  `json:"id"`
+	Title         string `json:"title"`
+	Version       string `json:"version"`
+	ISRC          string `json:"isrc"`
+	Duration      int    `json:"duration"`
+	Explicit      bool   `json:"explicit"`
+	AudioQuality  string `json:"audioQuality"`
+	MediaMetadata struct {
+		Tags []string `json:"tags"`
+	} `json:"mediaMetadata"`
+	Artists []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		Title string `json:"title"`
+	} `json:"album"`
+}
+
+// tidalTrackInfo is the response of the info endpoint.
+type tidalTrackInfo struct {
+	Data tidalTrack `json:"data"`
+}
 
-	numberToUse := position
-	if useAlbumTrackNumber && trackNumber > 0 {
-		numberToUse = trackNumber
-	}
+// tidalSearchResult is the response of the search endpoint.
+type tidalSearchResult struct {
+	Data struct {
+		Items []tidalTrack `json:"items"`
+	} `json:"data"`
+}
 
-	year := ""
-	if len(releaseDate) >= 4 {
-		year = releaseDate[:4]
+func fetchTidalTrackInfo(ctx context.Context, apis []string, trackID int64) (*tidalTrackInfo, error) {
+	var info tidalTrackInfo
+	if err := tidalAPIGet(ctx, apis, fmt.Sprintf("/info/?id=%d", trackID), &info); err != nil {
+		return nil, err
 	}
+	return &info, nil
+}
 
-	if strings.Contains(format, "{") {
-		filename = format
-		filename = strings.ReplaceAll(filename, "{title}", title)
-		filename = strings.ReplaceAll(filename, "{artist}", artist)
-		filename = strings.ReplaceAll(filename, "{album}", album)
-		filename = strings.ReplaceAll(filename, "{album_artist}", albumArtist)
-		filename = strings.ReplaceAll(filename, "{year}", year)
+// searchTidalTracks searches the Tidal catalogue. field is "i" to look up an
+// ISRC and "s" for a text search.
+func searchTidalTracks(ctx context.Context, apis []string, field, query string) ([]tidalTrack, error) {
+	var result tidalSearchResult
+	path := fmt.Sprintf("/search/?%s=%s", field, url.QueryEscape(query))
+	if err := tidalAPIGet(ctx, apis, path, &result); err != nil {
+		return nil, err
+	}
+	return result.Data.Items, nil
+}
 
-		if discNumber > 0 {
-			filename = strings.ReplaceAll(filename, "{disc}", fmt.Sprintf("%d", discNumber))
-		} else {
-			filename = strings.ReplaceAll(filename, "{disc}", "")
-		}
+// matchCandidate describes the track for the matching engine.
+func (track *tidalTrack) matchCandidate() MatchCandidate {
+	c := MatchCandidate{
+		ID:         fmt.Sprintf("%d", track.ID),
+		Title:      track.Title,
+		Version:    track.Version,
+		Album:      track.Album.Title,
+		ISRC:       track.ISRC,
+		DurationMS: track.Duration * 1000,
+	}
+	for _, a := range track.Artists {
+		c.Artists = append(c.Artists, a.Name)
+	}
+	return c
+}
 
-		if numberToUse > 0 {
-			filename = strings.ReplaceAll(filename, "{track}", fmt.Sprintf("%02d", numberToUse))
-		} else {
+// tidalAPIGet decodes the response of the first Tidal API that answers
+// path successfully.
+func tidalAPIGet(ctx context.Context, apis []string, path string, v interface{}) error {
+	client := newProviderClient("tidal", 15*time.Second)
 
-			filename = regexp.MustCompile(`\{track\}\.\s*`).ReplaceAllString(filename, "")
-			filename = regexp.MustCompile(`\{track\}\s*-\s*`).ReplaceAllString(filename, "")
-			filename = regexp.MustCompile(`\{track\}\s*`).ReplaceAllString(filename, "")
+	var lastError error
+	for _, apiURL := range apis {
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL+path, nil)
+		if err != nil {
+			return err
 		}
-	} else {
-
-		switch format {
-		case "artist-title":
-			filename = fmt.Sprintf("%s - %s", artist, title)
-		case "title":
-			filename = title
-		default:
-			filename = fmt.Sprintf("%s - %s", title, artist)
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastError = err
+			continue
 		}
-
-		if includeTrackNumber && position > 0 {
-			filename = fmt.Sprintf("%02d. %s", numberToUse, filename)
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			lastError = fmt.Errorf("%s: HTTP %d", apiURL, resp.StatusCode)
+			continue
 		}
+		err = json.NewDecoder(resp.Body).Decode(v)
+		resp.Body.Close()
+		if err != nil {
+			lastError = fmt.Errorf("%s: failed to decode response: %w", apiURL, err)
+			continue
+		}
+		return nil
 	}
-
-	return filename + ".flac"
+	if lastError == nil {
+		lastError = fmt.Errorf("no APIs available")
+	}
+	return lastError
 }
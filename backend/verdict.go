@@ -0,0 +1,274 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Verdicts of the fake-lossless check. A transcode was encoded from a lossy
+// source; an upscaled file claims a higher bit depth or sample rate than its
+// source had.
+const (
+	VerdictGenuine      = "genuine"
+	VerdictTranscode    = "lossy_transcode"
+	VerdictUpscaled     = "upscaled"
+	VerdictInconclusive = "inconclusive"
+)
+
+// lossyShelves are the lowpass frequencies of common lossy encoders: 16 kHz
+// for 128 kbps MP3, 19 kHz for 192 kbps MP3 and 20 kHz for 320 kbps MP3, AAC
+// and Vorbis. Their confidence is lower the closer they are to the natural
+// end of a CD's spectrum.
+var lossyShelves = []struct {
+	Frequency  float64
+	Confidence float64
+}{
+	{16000, 0.95},
+	{19000, 0.85},
+	{20000, 0.7},
+}
+
+const (
+	// cutoffBandHz is the width of the bands the spectrum is averaged over
+	// before looking for the cutoff.
+	cutoffBandHz = 200.0
+	// cutoffThresholdDB is how far above the noise floor a band must be to
+	// count as content.
+	cutoffThresholdDB = 12.0
+	// cutoffRangeDB limits content to this far below a slice's loudest band.
+	// Anything lower is beneath the 16-bit noise floor and in 24-bit files
+	// mostly resampler leakage.
+	cutoffRangeDB = 90.0
+	// minSliceRangeDB separates music from silence: quieter slices are skipped.
+	minSliceRangeDB = 30.0
+	// shelfDropDB is the drop right above the cutoff that marks an encoder's
+	// lowpass rather than a natural roll-off.
+	shelfDropDB = 25.0
+	// shelfToleranceHz is how close a cutoff must be to a known shelf.
+	shelfToleranceHz = 500.0
+	// minActiveSlices is how many non-silent slices a verdict needs.
+	minActiveSlices = 5
+)
+
+// CutoffPoint is the highest frequency with content at a point in time.
+type CutoffPoint struct {
+	Time      float64 `json:"time"`
+	Frequency float64 `json:"frequency"`
+}
+
+// QualityVerdict is the result of checking a file for fake lossless audio.
+// Confidence (0 to 1) is the confidence in Verdict. Cutoff is the effective
+// upper frequency in Hz, ShelfDrop how steeply the spectrum falls above it in
+// dB, and Shelf the lossy encoder lowpass it matches, if any.
+type QualityVerdict struct {
+	Verdict          string        `json:"verdict"`
+	Confidence       float64       `json:"confidence"`
+	Cutoff           float64       `json:"cutoff"`
+	ShelfDrop        float64       `json:"shelf_drop"`
+	Shelf            float64       `json:"shelf,omitempty"`
+	SampleRate       int           `json:"sample_rate"`
+	SourceSampleRate int           `json:"source_sample_rate,omitempty"`
+	BitsPerSample    int           `json:"bits_per_sample"`
+	EffectiveBits    int           `json:"effective_bits"`
+	Reasons          []string      `json:"reasons,omitempty"`
+	Timeline         []CutoffPoint `json:"cutoff_timeline,omitempty"`
+}
+
+// Fake reports whether the file was found to be a transcode or upscaled.
+func (v *QualityVerdict) Fake() bool {
+	return v.Verdict == VerdictTranscode || v.Verdict == VerdictUpscaled
+}
+
+// AnalyzeQuality decodes a lossless file and judges whether it is genuine
+// lossless audio.
+func AnalyzeQuality(ctx context.Context, path string) (*QualityVerdict, error) {
+	decoder, err := openAudio(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	if format := decoder.Format(); !format.lossless() {
+		return nil, fmt.Errorf("%s is a lossy codec", format.codec)
+	}
+
+	analysis, err := analyzeDecoder(decoder, analysisOptions{spectrum: true})
+	if err != nil {
+		return nil, err
+	}
+	return judgeQuality(analysis.spectrum, analysis.stats), nil
+}
+
+// judgeQuality estimates the frequency cutoff of every slice of the spectrum
+// and looks for lossy encoder lowpasses, sample rate upscaling and zero
+// padded bit depth.
+func judgeQuality(spectrum *SpectrumData, stats sampleStats) *QualityVerdict {
+	v := &QualityVerdict{
+		SampleRate:    spectrum.SampleRate,
+		BitsPerSample: stats.bitsPerSample,
+		EffectiveBits: stats.usedBits,
+	}
+
+	findings := map[string]float64{}
+	found := func(verdict string, confidence float64, reason string) {
+		findings[verdict] = max(findings[verdict], confidence)
+		v.Reasons = append(v.Reasons, reason)
+	}
+
+	if stats.bitsPerSample > 16 && stats.usedBits > 0 && stats.usedBits <= 16 {
+		found(VerdictUpscaled, 0.95, fmt.Sprintf("%d-bit file only uses %d bits, the rest is zero padding", stats.bitsPerSample, stats.usedBits))
+	}
+
+	var cutoffs, drops []float64
+	if spectrum.FreqBins > 0 {
+		binHz := spectrum.MaxFreq / float64(spectrum.FreqBins)
+		for _, slice := range spectrum.TimeSlices {
+			cutoff, drop, ok := sliceCutoff(slice.Magnitudes, binHz)
+			if !ok {
+				continue
+			}
+			v.Timeline = append(v.Timeline, CutoffPoint{Time: math.Round(slice.Time*100) / 100, Frequency: cutoff})
+			cutoffs = append(cutoffs, cutoff)
+			drops = append(drops, drop)
+		}
+	}
+
+	nyquist := float64(spectrum.SampleRate) / 2
+	audible := min(nyquist, 22050.0)
+	if len(cutoffs) >= minActiveSlices {
+		// The brightest moments show where the spectrum really ends
+		v.Cutoff = percentile(slices.Sorted(slices.Values(cutoffs)), 0.95)
+
+		var nearDrops []float64
+		for i, cutoff := range cutoffs {
+			if math.Abs(cutoff-v.Cutoff) <= shelfToleranceHz {
+				nearDrops = append(nearDrops, drops[i])
+			}
+		}
+		v.ShelfDrop = math.Round(percentile(slices.Sorted(slices.Values(nearDrops)), 0.5)*10) / 10
+		steep := v.ShelfDrop >= shelfDropDB
+		// A lowpass cuts every loud passage at the same frequency
+		consistency := 0.7 + 0.3*min(1, float64(len(nearDrops))/float64(len(cutoffs))/0.5)
+
+		shelf, shelfConfidence := nearestShelf(v.Cutoff)
+		switch {
+		case shelf > 0 && steep && v.Cutoff < nyquist-shelfToleranceHz:
+			v.Shelf = shelf
+			found(VerdictTranscode, shelfConfidence*consistency, fmt.Sprintf("hard lowpass at %.1f kHz, typical of a lossy encoder", v.Cutoff/1000))
+		case steep && v.Cutoff < 0.85*audible:
+			found(VerdictTranscode, 0.75*consistency, fmt.Sprintf("hard lowpass at %.1f kHz, far below %.1f kHz", v.Cutoff/1000, audible/1000))
+		case v.Cutoff < 0.75*audible:
+			v.Reasons = append(v.Reasons, fmt.Sprintf("content ends at %.1f kHz with a gradual roll-off", v.Cutoff/1000))
+		}
+
+		if spectrum.SampleRate > 48000 {
+			for _, rate := range []int{44100, 48000} {
+				if v.Cutoff <= float64(rate)/2+2*shelfToleranceHz {
+					v.SourceSampleRate = rate
+					confidence := 0.65
+					if steep {
+						confidence = 0.85
+					}
+					found(VerdictUpscaled, confidence, fmt.Sprintf("no content above %.1f kHz, resampled from %.1f kHz", v.Cutoff/1000, float64(rate)/1000))
+					break
+				}
+			}
+		}
+	}
+
+	for _, verdict := range []string{VerdictTranscode, VerdictUpscaled} {
+		if confidence, ok := findings[verdict]; ok {
+			v.Verdict = verdict
+			v.Confidence = math.Round(confidence*100) / 100
+			return v
+		}
+	}
+
+	if len(cutoffs) < minActiveSlices {
+		v.Verdict = VerdictInconclusive
+		v.Reasons = append(v.Reasons, "too little audio to judge the spectrum")
+		return v
+	}
+
+	v.Verdict = VerdictGenuine
+	confidence := 0.5 + 0.45*(v.Cutoff-16000)/(audible-16000)
+	v.Confidence = math.Round(min(0.95, max(0.5, confidence))*100) / 100
+	return v
+}
+
+// sliceCutoff averages a slice's magnitudes (dB) into bands and returns the
+// centre of the highest band with content, along with how far the level
+// drops right above it. ok is false for silent slices.
+func sliceCutoff(magnitudes []float64, binHz float64) (cutoff, drop float64, ok bool) {
+	bandBins := max(1, int(math.Round(cutoffBandHz/binHz)))
+	levels := make([]float64, len(magnitudes)/bandBins)
+	if len(levels) < 8 {
+		return 0, 0, false
+	}
+
+	floor, peak := math.Inf(1), math.Inf(-1)
+	for b := range levels {
+		var sum float64
+		for _, m := range magnitudes[b*bandBins : (b+1)*bandBins] {
+			sum += m
+		}
+		levels[b] = sum / float64(bandBins)
+		floor = min(floor, levels[b])
+		peak = max(peak, levels[b])
+	}
+	if peak-floor < minSliceRangeDB {
+		return 0, 0, false
+	}
+
+	// Two adjacent bands are required so a single spike does not count
+	threshold := max(floor+cutoffThresholdDB, peak-cutoffRangeDB)
+	top := -1
+	for b := len(levels) - 1; b > 0; b-- {
+		if levels[b] > threshold && levels[b-1] > threshold {
+			top = b
+			break
+		}
+	}
+	if top < 0 {
+		return 0, 0, false
+	}
+
+	below := mean(levels[max(0, top-2) : top+1])
+	above := floor
+	if top+1 < len(levels) {
+		above = mean(levels[top+1 : min(len(levels), top+4)])
+	}
+	return (float64(top) + 0.5) * float64(bandBins) * binHz, below - above, true
+}
+
+// nearestShelf returns the known lossy lowpass closest to cutoff and its
+// confidence, or zero when none is within shelfToleranceHz.
+func nearestShelf(cutoff float64) (float64, float64) {
+	var frequency, confidence float64
+	distance := shelfToleranceHz
+	for _, shelf := range lossyShelves {
+		if d := math.Abs(cutoff - shelf.Frequency); d <= distance {
+			frequency, confidence, distance = shelf.Frequency, shelf.Confidence, d
+		}
+	}
+	return frequency, confidence
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mewflac "github.com/mewkiz/flac"
+)
+
+// Verification statuses. Files that are not FLAC are skipped because only
+// FLAC carries a checksum of its audio.
+const (
+	VerifyOK      = "ok"
+	VerifyFailed  = "failed"
+	VerifySkipped = "skipped"
+)
+
+// verifyDurationTolerance is how far the decoded duration may differ from the
+// Spotify duration. Releases on different services often differ by a second
+// or two of silence.
+const verifyDurationTolerance = 5.0
+
+// Checks a file can fail, reported in VerifyResult.Check.
+const (
+	CheckDecode   = "decode"
+	CheckSamples  = "samples"
+	CheckMD5      = "md5"
+	CheckDuration = "duration"
+)
+
+// VerifyResult is the outcome of checking a downloaded file. MD5 is "match",
+// "mismatch" or "unset" when the encoder did not store a checksum. Check
+// names the check that failed.
+type VerifyResult struct {
+	Status           string  `json:"status"`
+	Check            string  `json:"check,omitempty"`
+	MD5              string  `json:"md5,omitempty"`
+	Duration         float64 `json:"duration,omitempty"`
+	ExpectedDuration float64 `json:"expected_duration,omitempty"`
+	Samples          uint64  `json:"samples,omitempty"`
+	ExpectedSamples  uint64  `json:"expected_samples,omitempty"`
+	Error            string  `json:"error,omitempty"`
+	VerifiedAt       int64   `json:"verified_at"`
+}
+
+// VerifyFile decodes a FLAC file completely and checks that every frame
+// decodes, that the number of samples and the audio MD5 match STREAMINFO and,
+// when expectedDuration (seconds) is set, that the length matches it.
+func VerifyFile(path string, expectedDuration float64) *VerifyResult {
+	result := &VerifyResult{
+		ExpectedDuration: expectedDuration,
+		VerifiedAt:       time.Now().Unix(),
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".flac") {
+		result.Status = VerifySkipped
+		return result
+	}
+
+	if check, err := verifyFLAC(path, result); err != nil {
+		result.Status = VerifyFailed
+		result.Check = check
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = VerifyOK
+	return result
+}
+
+// Corrupt reports whether the file itself is damaged, as opposed to being a
+// complete file of the wrong length. A corrupt download is worth retrying.
+func (r *VerifyResult) Corrupt() bool {
+	return r.Status == VerifyFailed && r.Check != CheckDuration
+}
+
+func verifyFLAC(path string, result *VerifyResult) (string, error) {
+	stream, err := mewflac.ParseFile(path)
+	if err != nil {
+		return CheckDecode, fmt.Errorf("failed to parse FLAC: %w", err)
+	}
+	defer stream.Close()
+
+	info := stream.Info
+	result.ExpectedSamples = info.NSamples
+
+	hash := md5.New()
+	var decodeErr error
+	for {
+		frame, err := stream.ParseNext()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			decodeErr = err
+			break
+		}
+		frame.Hash(hash)
+		result.Samples += uint64(frame.Subframes[0].NSamples)
+	}
+
+	if info.SampleRate > 0 {
+		result.Duration = math.Round(float64(result.Samples)/float64(info.SampleRate)*100) / 100
+	}
+	if decodeErr != nil {
+		return CheckDecode, fmt.Errorf("decode error after %.1fs: %w", result.Duration, decodeErr)
+	}
+
+	if info.NSamples > 0 && result.Samples != info.NSamples {
+		return CheckSamples, fmt.Errorf("file is truncated: decoded %d of %d samples", result.Samples, info.NSamples)
+	}
+
+	var unset [md5.Size]byte
+	if info.MD5sum == unset {
+		result.MD5 = "unset"
+	} else if sum := hash.Sum(nil); bytes.Equal(sum, info.MD5sum[:]) {
+		result.MD5 = "match"
+	} else {
+		result.MD5 = "mismatch"
+		return CheckMD5, fmt.Errorf("audio MD5 %s does not match STREAMINFO %s", hex.EncodeToString(sum), hex.EncodeToString(info.MD5sum[:]))
+	}
+
+	if result.ExpectedDuration > 0 && math.Abs(result.Duration-result.ExpectedDuration) > verifyDurationTolerance {
+		return CheckDuration, fmt.Errorf("duration %.1fs differs from the expected %.0fs", result.Duration, result.ExpectedDuration)
+	}
+	return "", nil
+}
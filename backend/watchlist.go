@@ -0,0 +1,257 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const watchlistBucket = "ArtistWatchlist"
+
+// ReleaseGroups are the discography groups a watched artist can follow.
+var ReleaseGroups = []string{"album", "single", "compilation"}
+
+// WatchedArtist is an artist whose new releases are downloaded
+// automatically. Since is the release date (YYYY-MM-DD) from which releases
+// count as new; it moves forward after every successful check.
+type WatchedArtist struct {
+	ID              string   `json:"id"`
+	ArtistID        string   `json:"artist_id"`
+	URL             string   `json:"url"`
+	Name            string   `json:"name"`
+	Groups          []string `json:"groups"`
+	Service         string   `json:"service,omitempty"`
+	AudioFormat     string   `json:"audio_format,omitempty"`
+	FallbackProfile string   `json:"fallback_profile,omitempty"`
+	IntervalMinutes int      `json:"interval_minutes"`
+	Enabled         bool     `json:"enabled"`
+	CreatedAt       int64    `json:"created_at"`
+	LastCheckAt     int64    `json:"last_check_at,omitempty"`
+	LastError       string   `json:"last_error,omitempty"`
+	Since           string   `json:"since"`
+	KnownReleases   []string `json:"known_releases,omitempty"`
+}
+
+// ArtistURL validates a Spotify artist URL or URI and returns the artist ID
+// and its canonical URL.
+func ArtistURL(input string) (string, string, error) {
+	parsed, err := parseSpotifyURI(input)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Type != "artist" && parsed.Type != "artist_discography" {
+		return "", "", fmt.Errorf("not an artist URL: %s", input)
+	}
+	return parsed.ID, "https://open.spotify.com/artist/" + parsed.ID, nil
+}
+
+// ReleaseGroup maps a discography release type to its group. Unknown types
+// count as singles, as in fetchArtistDiscography.
+func ReleaseGroup(releaseType string) string {
+	switch strings.ToUpper(releaseType) {
+	case "ALBUM":
+		return "album"
+	case "COMPILATION":
+		return "compilation"
+	default:
+		return "single"
+	}
+}
+
+// Due reports whether the artist should be checked at now.
+func (a *WatchedArtist) Due(now time.Time) bool {
+	if !a.Enabled {
+		return false
+	}
+	interval := time.Duration(a.IntervalMinutes) * time.Minute
+	return a.LastCheckAt == 0 || now.Sub(time.Unix(a.LastCheckAt, 0)) >= interval
+}
+
+// Follows reports whether releases of group are downloaded.
+func (a *WatchedArtist) Follows(group string) bool {
+	for _, g := range a.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// NewReleases returns the releases that are not known yet, were released on
+// or after Since and belong to a followed group, oldest first. Releases that
+// only carry a year are compared by year.
+func (a *WatchedArtist) NewReleases(releases []DiscographyAlbumMetadata) []DiscographyAlbumMetadata {
+	known := make(map[string]bool, len(a.KnownReleases))
+	for _, id := range a.KnownReleases {
+		known[id] = true
+	}
+
+	var fresh []DiscographyAlbumMetadata
+	for _, release := range releases {
+		if release.ID == "" || known[release.ID] || !a.Follows(ReleaseGroup(release.AlbumType)) {
+			continue
+		}
+		since := a.Since
+		if len(release.ReleaseDate) < len(since) {
+			since = since[:len(release.ReleaseDate)]
+		}
+		if release.ReleaseDate < since {
+			continue
+		}
+		fresh = append(fresh, release)
+	}
+
+	sort.SliceStable(fresh, func(i, j int) bool {
+		return fresh[i].ReleaseDate < fresh[j].ReleaseDate
+	})
+	return fresh
+}
+
+// MarkKnown records release IDs so they are not downloaded again.
+func (a *WatchedArtist) MarkKnown(releases []DiscographyAlbumMetadata) {
+	known := make(map[string]bool, len(a.KnownReleases))
+	for _, id := range a.KnownReleases {
+		known[id] = true
+	}
+	for _, release := range releases {
+		if release.ID != "" && !known[release.ID] {
+			known[release.ID] = true
+			a.KnownReleases = append(a.KnownReleases, release.ID)
+		}
+	}
+}
+
+// WatchlistStore persists watched artists in the history database.
+type WatchlistStore struct {
+	appName string
+}
+
+func NewWatchlistStore(appName string) *WatchlistStore {
+	return &WatchlistStore{appName: appName}
+}
+
+func (st *WatchlistStore) ensureDB() error {
+	if historyDB == nil {
+		return InitHistoryDB(st.appName)
+	}
+	return nil
+}
+
+// Add stores a new watched artist and assigns its ID.
+func (st *WatchlistStore) Add(artist *WatchedArtist) error {
+	if err := st.ensureDB(); err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(watchlistBucket))
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var existing WatchedArtist
+			if err := json.Unmarshal(v, &existing); err == nil && existing.ArtistID == artist.ArtistID {
+				return fmt.Errorf("artist is already on the watchlist")
+			}
+		}
+
+		seq, _ := b.NextSequence()
+		artist.ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), seq)
+		artist.CreatedAt = time.Now().Unix()
+
+		buf, err := json.Marshal(artist)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(artist.ID), buf)
+	})
+}
+
+// Save overwrites an existing watched artist.
+func (st *WatchlistStore) Save(artist *WatchedArtist) error {
+	if err := st.ensureDB(); err != nil {
+		return err
+	}
+	return historyDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(watchlistBucket))
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(artist.ID)) == nil {
+			return fmt.Errorf("watched artist not found")
+		}
+		buf, err := json.Marshal(artist)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(artist.ID), buf)
+	})
+}
+
+// Get returns a watched artist, or nil when it does not exist.
+func (st *WatchlistStore) Get(id string) (*WatchedArtist, error) {
+	if err := st.ensureDB(); err != nil {
+		return nil, err
+	}
+	var artist *WatchedArtist
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(watchlistBucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		artist = &WatchedArtist{}
+		return json.Unmarshal(v, artist)
+	})
+	return artist, err
+}
+
+// List returns all watched artists, oldest first.
+func (st *WatchlistStore) List() ([]WatchedArtist, error) {
+	if err := st.ensureDB(); err != nil {
+		return nil, err
+	}
+	artists := []WatchedArtist{}
+	err := historyDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(watchlistBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var artist WatchedArtist
+			if err := json.Unmarshal(v, &artist); err == nil {
+				artists = append(artists, artist)
+			}
+			return nil
+		})
+	})
+
+	sort.SliceStable(artists, func(i, j int) bool {
+		return artists[i].CreatedAt < artists[j].CreatedAt
+	})
+	return artists, err
+}
+
+// Delete removes a watched artist.
+func (st *WatchlistStore) Delete(id string) (bool, error) {
+	if err := st.ensureDB(); err != nil {
+		return false, err
+	}
+	found := false
+	err := historyDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(watchlistBucket))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return nil
+		}
+		found = true
+		return b.Delete([]byte(id))
+	})
+	return found, err
+}
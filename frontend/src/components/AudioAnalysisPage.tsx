@@ -5,6 +5,8 @@ import { AudioAnalysis } from "@/components/AudioAnalysis";
 import { SpectrumVisualization } from "@/components/SpectrumVisualization";
 import { useAudioAnalysis } from "@/hooks/useAudioAnalysis";
 import { toastWithSound as toast } from "@/lib/toast-with-sound";
+const AUDIO_EXTENSIONS = [".flac", ".mp3", ".m4a", ".aac", ".ogg", ".opus", ".wav"];
+const isAudioFile = (name: string) => AUDIO_EXTENSIONS.some((ext) => name.toLowerCase().endsWith(ext));
 interface AudioAnalysisPageProps {
     onBack?: () => void;
 }
@@ -21,9 +23,9 @@ export function AudioAnalysisPage({ onBack }: AudioAnalysisPageProps) {
         const file = e.target.files?.[0];
         if (!file) return;
 
-        if (!file.name.toLowerCase().endsWith(".flac")) {
+        if (!isAudioFile(file.name)) {
             toast.error("Invalid File Type", {
-                description: "Please select a FLAC file for analysis",
+                description: "Please select a FLAC, MP3, M4A, AAC, OGG, Opus or WAV file for analysis",
             });
             return;
         }
@@ -66,9 +68,9 @@ export function AudioAnalysisPage({ onBack }: AudioAnalysisPageProps) {
         if (files.length === 0) return;
 
         const file = files[0];
-        if (!file.name.toLowerCase().endsWith(".flac")) {
+        if (!isAudioFile(file.name)) {
             toast.error("Invalid File Type", {
-                description: "Please drop a FLAC file for analysis",
+                description: "Please drop a FLAC, MP3, M4A, AAC, OGG, Opus or WAV file for analysis",
             });
             return;
         }
@@ -103,7 +105,7 @@ export function AudioAnalysisPage({ onBack }: AudioAnalysisPageProps) {
       <input
         ref={fileInputRef}
         type="file"
-        accept=".flac"
+        accept={AUDIO_EXTENSIONS.join(",")}
         onChange={handleFileInputChange}
         style={{ display: "none" }}
       />
@@ -136,12 +138,12 @@ export function AudioAnalysisPage({ onBack }: AudioAnalysisPageProps) {
           </div>
           <p className="text-sm text-muted-foreground mb-4 text-center">
             {isDragging
-                ? "Drop your FLAC file here"
-                : "Drag and drop a FLAC file here, or click the button below to select"}
+                ? "Drop your audio file here"
+                : "Drag and drop an audio file here, or click the button below to select"}
           </p>
           <Button onClick={handleSelectFile} size="lg">
             <Upload className="h-5 w-5"/>
-            Select FLAC File
+            Select Audio File
           </Button>
         </div>)}
 
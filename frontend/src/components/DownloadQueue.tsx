@@ -175,6 +175,12 @@ export function DownloadQueue({ isOpen, onClose }: DownloadQueueProps) {
             return `${seconds}s`;
         }
     };
+    const formatETA = (etaSeconds: number) => {
+        const total = Math.ceil(etaSeconds);
+        const minutes = Math.floor(total / 60);
+        const seconds = total % 60;
+        return minutes > 0 ? `${minutes}m ${seconds}s` : `${seconds}s`;
+    };
     const [filterStatus, setFilterStatus] = useState<string>("all");
     const toggleFilter = (status: string) => {
         setFilterStatus(prev => prev === status ? "all" : status);
@@ -286,7 +292,9 @@ export function DownloadQueue({ isOpen, onClose }: DownloadQueueProps) {
                 {item.status === "downloading" && (<div className="flex items-center gap-3 mt-1.5 text-xs text-muted-foreground font-mono">
                   <span>
                     {item.progress > 0
-                    ? `${item.progress.toFixed(2)} MB`
+                    ? item.total_size > 0
+                        ? `${item.progress.toFixed(2)} / ${item.total_size.toFixed(2)} MB (${item.percent.toFixed(0)}%)`
+                        : `${item.progress.toFixed(2)} MB`
                     : queueInfo.is_downloading && queueInfo.current_speed > 0
                         ? "Downloading..."
                         : "Starting..."}
@@ -298,6 +306,7 @@ export function DownloadQueue({ isOpen, onClose }: DownloadQueueProps) {
                         ? `${queueInfo.current_speed.toFixed(2)} MB/s`
                         : "—"}
                   </span>
+                  {item.eta > 0 && (<span>{formatETA(item.eta)} left</span>)}
                 </div>)}
 
 
@@ -10,6 +10,7 @@ import { Dialog, DialogContent, DialogDescription, DialogFooter, DialogHeader, D
 import { Switch } from "@/components/ui/switch";
 import { getSettings, getSettingsWithDefaults, saveSettings, resetToDefaultSettings, applyThemeMode, applyFont, FONT_OPTIONS, FOLDER_PRESETS, FILENAME_PRESETS, TEMPLATE_VARIABLES, type Settings as SettingsType, type FontFamily, type FolderPreset, type FilenamePreset, } from "@/lib/settings";
 import { themes, applyTheme } from "@/lib/themes";
+import { previewTemplate } from "@/lib/api";
 import { toastWithSound as toast } from "@/lib/toast-with-sound";
 const TidalIcon = ({ className }: {
     className?: string;
@@ -38,6 +39,7 @@ export function SettingsPage({ onUnsavedChangesChange, onResetRequest, }: Settin
     const [tempSettings, setTempSettings] = useState<SettingsType>(savedSettings);
     const [isDark, setIsDark] = useState(document.documentElement.classList.contains("dark"));
     const [showResetConfirm, setShowResetConfirm] = useState(false);
+    const [filenamePreview, setFilenamePreview] = useState<{ result?: string; error?: string; }>({});
     const hasUnsavedChanges = JSON.stringify(savedSettings) !== JSON.stringify(tempSettings);
     const resetToSaved = useCallback(() => {
         const freshSavedSettings = getSettings();
@@ -54,6 +56,30 @@ export function SettingsPage({ onUnsavedChangesChange, onResetRequest, }: Settin
     useEffect(() => {
         onUnsavedChangesChange?.(hasUnsavedChanges);
     }, [hasUnsavedChanges, onUnsavedChangesChange]);
+    useEffect(() => {
+        const template = tempSettings.filenameTemplate;
+        if (!template) {
+            setFilenamePreview({});
+            return;
+        }
+        let cancelled = false;
+        const timer = setTimeout(() => {
+            previewTemplate({ template, include_track_number: tempSettings.trackNumber })
+                .then((res) => {
+                if (!cancelled)
+                    setFilenamePreview({ result: res.result });
+            })
+                .catch((err) => {
+                const match = err instanceof Error ? err.message.match(/"error":\s*"([^"]*)"/) : null;
+                if (!cancelled)
+                    setFilenamePreview({ error: match ? match[1] : "Invalid template" });
+            });
+        }, 300);
+        return () => {
+            cancelled = true;
+            clearTimeout(timer);
+        };
+    }, [tempSettings.filenameTemplate, tempSettings.trackNumber]);
     useEffect(() => {
         applyThemeMode(savedSettings.themeMode);
         applyTheme(savedSettings.theme);
@@ -586,18 +612,12 @@ export function SettingsPage({ onUnsavedChangesChange, onResetRequest, }: Settin
                     filenameTemplate: e.target.value,
                 }))} placeholder="{track}. {title}" className="h-9 text-sm flex-1"/>)}
               </div>
-              {tempSettings.filenameTemplate && (<p className="text-xs text-muted-foreground">
+              {filenamePreview.result && (<p className="text-xs text-muted-foreground">
                   Preview:{" "}
-                  <span className="font-mono">
-                    {tempSettings.filenameTemplate
-                    .replace(/\{artist\}/g, "Kendrick Lamar, SZA")
-                    .replace(/\{album_artist\}/g, "Kendrick Lamar")
-                    .replace(/\{title\}/g, "All The Stars")
-                    .replace(/\{track\}/g, "01")
-                    .replace(/\{disc\}/g, "1")
-                    .replace(/\{year\}/g, "2018")}
-                    .flac
-                  </span>
+                  <span className="font-mono">{filenamePreview.result}</span>
+                </p>)}
+              {filenamePreview.error && (<p className="text-xs text-destructive">
+                  {filenamePreview.error}
                 </p>)}
             </div>
           </div>)}
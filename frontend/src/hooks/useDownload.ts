@@ -1,6 +1,6 @@
 import { useState, useRef } from "react";
 import { downloadTrack, fetchSpotifyMetadata } from "@/lib/api";
-import { getSettings, parseTemplate, type TemplateData } from "@/lib/settings";
+import { getSettings } from "@/lib/settings";
 import { toastWithSound as toast } from "@/lib/toast-with-sound";
 import { joinPath, sanitizePath } from "@/lib/utils";
 import { logger } from "@/lib/logger";
@@ -80,7 +80,6 @@ export function useDownload(region: string) {
         const os = settings.operatingSystem;
         let outputDir = settings.downloadPath;
         let useAlbumTrackNumber = false;
-        const placeholder = "__SLASH_PLACEHOLDER__";
         let finalReleaseDate = releaseDate;
         let finalTrackNumber = spotifyTrackNumber || 0;
         if (spotifyId) {
@@ -99,7 +98,6 @@ export function useDownload(region: string) {
             catch (err) {
             }
         }
-        const yearValue = releaseYear || finalReleaseDate?.substring(0, 4);
         const hasSubfolder = settings.folderTemplate && settings.folderTemplate.trim() !== "";
         const trackNumberForTemplate = (hasSubfolder && finalTrackNumber > 0) ? finalTrackNumber : (position || 0);
         if (hasSubfolder) {
@@ -111,30 +109,11 @@ export function useDownload(region: string) {
         const displayAlbumArtist = settings.useFirstArtistOnly && albumArtist
             ? getFirstArtist(albumArtist)
             : albumArtist;
-        const templateData: TemplateData = {
-            artist: displayArtist?.replace(/\//g, placeholder),
-            album: albumName?.replace(/\//g, placeholder),
-            album_artist: displayAlbumArtist?.replace(/\//g, placeholder) || displayArtist?.replace(/\//g, placeholder),
-            title: trackName?.replace(/\//g, placeholder),
-            track: trackNumberForTemplate,
-            year: yearValue,
-            playlist: playlistName?.replace(/\//g, placeholder),
-        };
         const folderTemplate = settings.folderTemplate || "";
         const useAlbumSubfolder = folderTemplate.includes("{album}") || folderTemplate.includes("{album_artist}") || folderTemplate.includes("{playlist}");
         if (settings.createPlaylistFolder && playlistName && !useAlbumSubfolder) {
             outputDir = joinPath(os, outputDir, sanitizePath(playlistName.replace(/\//g, " "), os));
         }
-        if (settings.folderTemplate) {
-            const folderPath = parseTemplate(settings.folderTemplate, templateData);
-            if (folderPath) {
-                const parts = folderPath.split("/").filter((p: string) => p.trim());
-                for (const part of parts) {
-                    const sanitizedPart = part.replace(new RegExp(placeholder, "g"), " ");
-                    outputDir = joinPath(os, outputDir, sanitizePath(sanitizedPart, os));
-                }
-            }
-        }
         const serviceForCheck = service === "auto" ? "flac" : (service === "tidal" ? "flac" : (service === "qobuz" ? "flac" : "flac"));
         if (trackName && artistName) {
             try {
@@ -144,7 +123,7 @@ export function useDownload(region: string) {
                     artist_name: displayArtist || "",
                     album_name: albumName,
                     album_artist: displayAlbumArtist,
-                    release_date: finalReleaseDate || releaseDate,
+                    release_date: finalReleaseDate || releaseDate || releaseYear,
                     track_number: finalTrackNumber || spotifyTrackNumber || 0,
                     disc_number: spotifyDiscNumber || 0,
                     position: trackNumberForTemplate,
@@ -197,9 +176,11 @@ export function useDownload(region: string) {
                             artist_name: displayArtist,
                             album_name: albumName,
                             album_artist: displayAlbumArtist,
-                            release_date: finalReleaseDate || releaseDate,
+                            release_date: finalReleaseDate || releaseDate || releaseYear,
                             cover_url: coverUrl,
                             output_dir: outputDir,
+                            folder_template: folderTemplate,
+                            playlist_name: playlistName,
                             filename_format: settings.filenameTemplate,
                             track_number: settings.trackNumber,
                             position,
@@ -240,9 +221,11 @@ export function useDownload(region: string) {
                             artist_name: displayArtist,
                             album_name: albumName,
                             album_artist: displayAlbumArtist,
-                            release_date: finalReleaseDate || releaseDate,
+                            release_date: finalReleaseDate || releaseDate || releaseYear,
                             cover_url: coverUrl,
                             output_dir: outputDir,
+                            folder_template: folderTemplate,
+                            playlist_name: playlistName,
                             filename_format: settings.filenameTemplate,
                             track_number: settings.trackNumber,
                             position,
@@ -280,9 +263,11 @@ export function useDownload(region: string) {
                             artist_name: displayArtist,
                             album_name: albumName,
                             album_artist: displayAlbumArtist,
-                            release_date: finalReleaseDate || releaseDate,
+                            release_date: finalReleaseDate || releaseDate || releaseYear,
                             cover_url: coverUrl,
                             output_dir: outputDir,
+                            folder_template: folderTemplate,
+                            playlist_name: playlistName,
                             filename_format: settings.filenameTemplate,
                             track_number: settings.trackNumber,
                             position: trackNumberForTemplate,
@@ -328,9 +313,11 @@ export function useDownload(region: string) {
             artist_name: displayArtist,
             album_name: albumName,
             album_artist: displayAlbumArtist,
-            release_date: finalReleaseDate || releaseDate,
+            release_date: finalReleaseDate || releaseDate || releaseYear,
             cover_url: coverUrl,
             output_dir: outputDir,
+            folder_template: folderTemplate,
+            playlist_name: playlistName,
             filename_format: settings.filenameTemplate,
             track_number: settings.trackNumber,
             position: trackNumberForTemplate,
@@ -355,7 +342,6 @@ export function useDownload(region: string) {
         const os = settings.operatingSystem;
         let outputDir = settings.downloadPath;
         let useAlbumTrackNumber = false;
-        const placeholder = "__SLASH_PLACEHOLDER__";
         let finalReleaseDate = releaseDate;
         let finalTrackNumber = spotifyTrackNumber || 0;
         if (spotifyId) {
@@ -374,7 +360,6 @@ export function useDownload(region: string) {
             catch (err) {
             }
         }
-        const yearValue = releaseYear || finalReleaseDate?.substring(0, 4);
         const hasSubfolder = settings.folderTemplate && settings.folderTemplate.trim() !== "";
         const trackNumberForTemplate = (hasSubfolder && finalTrackNumber > 0) ? finalTrackNumber : (position || 0);
         const displayArtist = settings.useFirstArtistOnly && artistName
@@ -383,30 +368,11 @@ export function useDownload(region: string) {
         const displayAlbumArtist = settings.useFirstArtistOnly && albumArtist
             ? getFirstArtist(albumArtist)
             : albumArtist;
-        const templateData: TemplateData = {
-            artist: displayArtist?.replace(/\//g, placeholder),
-            album: albumName?.replace(/\//g, placeholder),
-            album_artist: displayAlbumArtist?.replace(/\//g, placeholder) || displayArtist?.replace(/\//g, placeholder),
-            title: trackName?.replace(/\//g, placeholder),
-            track: trackNumberForTemplate,
-            year: yearValue,
-            playlist: folderName?.replace(/\//g, placeholder),
-        };
         const folderTemplate = settings.folderTemplate || "";
         const useAlbumSubfolder = folderTemplate.includes("{album}") || folderTemplate.includes("{album_artist}") || folderTemplate.includes("{playlist}");
         if (settings.createPlaylistFolder && folderName && (!isAlbum || !useAlbumSubfolder)) {
             outputDir = joinPath(os, outputDir, sanitizePath(folderName.replace(/\//g, " "), os));
         }
-        if (settings.folderTemplate) {
-            const folderPath = parseTemplate(settings.folderTemplate, templateData);
-            if (folderPath) {
-                const parts = folderPath.split("/").filter(p => p.trim());
-                for (const part of parts) {
-                    const sanitizedPart = part.replace(new RegExp(placeholder, "g"), " ");
-                    outputDir = joinPath(os, outputDir, sanitizePath(sanitizedPart, os));
-                }
-            }
-        }
         if (service === "auto") {
             let streamingURLs: any = null;
             if (spotifyId) {
@@ -436,9 +402,11 @@ export function useDownload(region: string) {
                             artist_name: displayArtist,
                             album_name: albumName,
                             album_artist: displayAlbumArtist,
-                            release_date: finalReleaseDate || releaseDate,
+                            release_date: finalReleaseDate || releaseDate || releaseYear,
                             cover_url: coverUrl,
                             output_dir: outputDir,
+                            folder_template: folderTemplate,
+                            playlist_name: folderName,
                             filename_format: settings.filenameTemplate,
                             track_number: settings.trackNumber,
                             position,
@@ -477,9 +445,11 @@ export function useDownload(region: string) {
                             artist_name: displayArtist,
                             album_name: albumName,
                             album_artist: displayAlbumArtist,
-                            release_date: finalReleaseDate || releaseDate,
+                            release_date: finalReleaseDate || releaseDate || releaseYear,
                             cover_url: coverUrl,
                             output_dir: outputDir,
+                            folder_template: folderTemplate,
+                            playlist_name: folderName,
                             filename_format: settings.filenameTemplate,
                             track_number: settings.trackNumber,
                             position,
@@ -516,9 +486,11 @@ export function useDownload(region: string) {
                             artist_name: displayArtist,
                             album_name: albumName,
                             album_artist: displayAlbumArtist,
-                            release_date: finalReleaseDate || releaseDate,
+                            release_date: finalReleaseDate || releaseDate || releaseYear,
                             cover_url: coverUrl,
                             output_dir: outputDir,
+                            folder_template: folderTemplate,
+                            playlist_name: folderName,
                             filename_format: settings.filenameTemplate,
                             track_number: settings.trackNumber,
                             position: trackNumberForTemplate,
@@ -565,9 +537,11 @@ export function useDownload(region: string) {
             artist_name: displayArtist,
             album_name: albumName,
             album_artist: displayAlbumArtist,
-            release_date: finalReleaseDate || releaseDate,
+            release_date: finalReleaseDate || releaseDate || releaseYear,
             cover_url: coverUrl,
             output_dir: outputDir,
+            folder_template: folderTemplate,
+            playlist_name: folderName,
             filename_format: settings.filenameTemplate,
             track_number: settings.trackNumber,
             position: trackNumberForTemplate,
@@ -21,11 +21,11 @@ export function useDownloadProgress() {
             try {
                 const data = JSON.parse(event.data);
                 // Update progress state from SSE event
-                // data shape: {type, item_id, status, percent, speed, message}
+                // data shape: {type, item_id, status, percent, mb_downloaded, total_mb, speed, eta, message}
                 if (data.status === 'downloading') {
                     setProgress({
                         is_downloading: true,
-                        mb_downloaded: data.percent || 0,
+                        mb_downloaded: data.mb_downloaded || 0,
                         speed_mbps: data.speed || 0,
                     });
                 } else if (data.status === 'completed' || data.status === 'failed' || data.status === 'exists') {
@@ -11,6 +11,8 @@ interface DownloadItem {
     status: DownloadStatus;
     progress: number;
     total_size: number;
+    percent: number;
+    eta: number;
     speed: number;
     start_time: number;
     end_time: number;
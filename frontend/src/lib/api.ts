@@ -16,6 +16,8 @@ import type {
 	AnalysisResult,
 	ConvertAudioRequest,
 	ConvertAudioResponse,
+	TemplatePreviewRequest,
+	TemplatePreviewResponse,
 } from "@/types/api";
 
 // Base API URL - empty string means same origin
@@ -80,6 +82,15 @@ export async function downloadTrack(
 	});
 }
 
+export async function previewTemplate(
+	request: TemplatePreviewRequest
+): Promise<TemplatePreviewResponse> {
+	return apiRequest<TemplatePreviewResponse>("/api/template/preview", {
+		method: "POST",
+		body: JSON.stringify(request),
+	});
+}
+
 export async function checkHealth(): Promise<HealthResponse> {
 	return apiRequest<HealthResponse>("/api/health");
 }
@@ -98,6 +98,10 @@ export const TEMPLATE_VARIABLES = [
     { key: "{track}", description: "Track number", example: "01" },
     { key: "{disc}", description: "Disc number", example: "1" },
     { key: "{year}", description: "Release year", example: "2014" },
+    { key: "{isrc}", description: "ISRC code", example: "USCJY1431349" },
+    { key: "{label}", description: "Record label", example: "Big Machine" },
+    { key: "{quality}", description: "Requested quality", example: "LOSSLESS" },
+    { key: "{explicit}", description: "\"Explicit\" for explicit tracks", example: "Explicit" },
 ];
 function detectOS(): "Windows" | "linux/MacOS" {
     const platform = window.navigator.platform.toLowerCase();
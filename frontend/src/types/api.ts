@@ -118,6 +118,8 @@ export interface DownloadRequest {
     cover_url?: string;
     api_url?: string;
     output_dir?: string;
+    folder_template?: string;
+    playlist_name?: string;
     audio_format?: string;
     folder_name?: string;
     filename_format?: string;
@@ -138,6 +140,18 @@ export interface DownloadRequest {
     publisher?: string;
     spotify_url?: string;
     use_first_artist_only?: boolean;
+    isrc?: string;
+    explicit?: boolean;
+}
+export interface TemplatePreviewRequest {
+    template: string;
+    kind?: "filename" | "folder";
+    include_track_number?: boolean;
+    track?: DownloadRequest;
+}
+export interface TemplatePreviewResponse {
+    kind: "filename" | "folder";
+    result: string;
 }
 export interface DownloadResponse {
     success: boolean;
@@ -162,9 +176,40 @@ export interface SpectrumData {
     duration: number;
     max_freq: number;
 }
+export interface CutoffPoint {
+    time: number;
+    frequency: number;
+}
+export interface QualityVerdict {
+    verdict: "genuine" | "lossy_transcode" | "upscaled" | "inconclusive";
+    confidence: number;
+    cutoff: number;
+    shelf_drop: number;
+    shelf?: number;
+    sample_rate: number;
+    source_sample_rate?: number;
+    bits_per_sample: number;
+    effective_bits: number;
+    reasons?: string[];
+    cutoff_timeline?: CutoffPoint[];
+}
+export interface Loudness {
+    integrated: number;
+    range: number;
+    true_peak: number;
+    sample_peak: number;
+}
+export interface ReplayGain {
+    track_gain: number;
+    track_peak: number;
+    has_album: boolean;
+    album_gain?: number;
+    album_peak?: number;
+}
 export interface AnalysisResult {
     file_path: string;
     file_size: number;
+    codec: string;
     sample_rate: number;
     channels: number;
     bits_per_sample: number;
@@ -175,6 +220,8 @@ export interface AnalysisResult {
     peak_amplitude: number;
     rms_level: number;
     spectrum?: SpectrumData;
+    verdict?: QualityVerdict;
+    loudness?: Loudness;
 }
 export interface LyricsDownloadRequest {
     spotify_id: string;
@@ -197,6 +244,16 @@ export interface LyricsDownloadResponse {
     error?: string;
     already_exists?: boolean;
 }
+export interface ServiceQuality {
+    available: boolean;
+    url?: string;
+    tier?: "hi_res" | "lossless";
+    bit_depth?: number;
+    sample_rate?: number;
+    duration_ms?: number;
+    explicit?: boolean;
+    warnings?: string[];
+}
 export interface TrackAvailability {
     spotify_id: string;
     tidal: boolean;
@@ -205,6 +262,10 @@ export interface TrackAvailability {
     tidal_url?: string;
     amazon_url?: string;
     qobuz_url?: string;
+    isrc?: string;
+    services?: Record<string, ServiceQuality>;
+    best?: string;
+    error?: string;
 }
 export interface CoverDownloadRequest {
     cover_url: string;
@@ -352,6 +413,9 @@ export interface DownloadItem {
     album_name: string;
     status: "queued" | "downloading" | "completed" | "failed" | "skipped";
     progress: number;
+    total_size: number;
+    percent: number;
+    eta: number;
     speed: number;
     error_message: string;
     file_path: string;
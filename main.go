@@ -11,6 +11,7 @@ import (
 	"os/signal"
 	"spotiflac/backend"
 	"spotiflac/server"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -39,6 +40,11 @@ func main() {
 		dataDir = "./data"
 	}
 
+	downloadWorkers := 2
+	if v, err := strconv.Atoi(os.Getenv("DOWNLOAD_WORKERS")); err == nil && v > 0 {
+		downloadWorkers = v
+	}
+
 	env := os.Getenv("ENV")
 	isDev := env == "development"
 
@@ -77,6 +83,26 @@ func main() {
 	// Create server instance
 	srv := server.NewServer(downloadPath, dataDir)
 
+	// Apply bandwidth and provider limits; saving settings updates them
+	if settings, err := backend.LoadSettings(); err == nil {
+		backend.ApplyRateLimits(settings)
+	}
+
+	// Load schedules first so the queue starts paused or outside its download window
+	if err := srv.StartSchedules(); err != nil {
+		log.Fatalf("Failed to load schedules: %v", err)
+	}
+
+	// Start the persistent download job queue
+	if err := srv.StartJobs(downloadWorkers); err != nil {
+		log.Printf("Failed to start job queue: %v", err)
+	}
+	defer srv.Close()
+
+	// Sync subscribed playlists and watched artists in the background
+	srv.StartSubscriptions()
+	srv.StartWatchlist()
+
 	// API routes
 	api := e.Group("/api")
 
@@ -91,6 +117,8 @@ func main() {
 
 	// Download operations
 	api.POST("/download", srv.HandleDownloadTrack)
+	api.POST("/download/collection", srv.HandleDownloadCollection)
+	api.GET("/providers", srv.HandleGetProviders)
 	api.POST("/lyrics", srv.HandleDownloadLyrics)
 	api.POST("/cover", srv.HandleDownloadCover)
 	api.POST("/header", srv.HandleDownloadHeader)
@@ -106,11 +134,45 @@ func main() {
 	api.POST("/skip-item", srv.HandleSkipDownloadItem)
 	api.GET("/export-failed", srv.HandleExportFailedDownloads)
 
+	// Download jobs
+	api.POST("/jobs", srv.HandleCreateJobs)
+	api.GET("/jobs", srv.HandleListJobs)
+	api.DELETE("/jobs", srv.HandleClearFinishedJobs)
+	api.GET("/jobs/:id", srv.HandleGetJob)
+	api.POST("/jobs/:id/cancel", srv.HandleCancelJob)
+	api.POST("/jobs/pause", srv.HandlePauseQueue)
+	api.POST("/jobs/resume", srv.HandleResumeQueue)
+
+	// Playlist subscriptions
+	api.GET("/subscriptions", srv.HandleListSubscriptions)
+	api.POST("/subscriptions", srv.HandleCreateSubscription)
+	api.PUT("/subscriptions/:id", srv.HandleUpdateSubscription)
+	api.DELETE("/subscriptions/:id", srv.HandleDeleteSubscription)
+	api.POST("/subscriptions/:id/sync", srv.HandleSyncSubscription)
+	api.GET("/subscriptions/:id/runs", srv.HandleListSubscriptionRuns)
+
+	// Artist watchlist
+	api.GET("/watchlist", srv.HandleListWatchlist)
+	api.POST("/watchlist", srv.HandleAddWatchedArtist)
+	api.PUT("/watchlist/:id", srv.HandleUpdateWatchedArtist)
+	api.DELETE("/watchlist/:id", srv.HandleDeleteWatchedArtist)
+	api.POST("/watchlist/:id/check", srv.HandleCheckWatchedArtist)
+
+	// Schedules and download window
+	api.GET("/schedules", srv.HandleListSchedules)
+	api.POST("/schedules", srv.HandleCreateSchedule)
+	api.GET("/schedules/queue", srv.HandleGetQueueState)
+	api.PUT("/schedules/window", srv.HandleSetDownloadWindow)
+	api.PUT("/schedules/:id", srv.HandleUpdateSchedule)
+	api.DELETE("/schedules/:id", srv.HandleDeleteSchedule)
+	api.POST("/schedules/:id/run", srv.HandleRunSchedule)
+
 	// Settings
 	api.GET("/settings", srv.HandleLoadSettings)
 	api.POST("/settings", srv.HandleSaveSettings)
 	api.GET("/defaults", srv.HandleGetDefaults)
 	api.GET("/download-path", srv.HandleGetDownloadPath)
+	api.POST("/template/preview", srv.HandlePreviewTemplate)
 
 	// History
 	api.GET("/history", srv.HandleGetHistory)
@@ -124,11 +186,18 @@ func main() {
 
 	// Track availability and preview
 	api.GET("/track-availability", srv.HandleCheckTrackAvailability)
+	api.POST("/track-availability/batch", srv.HandleCheckAvailabilityBatch)
+	api.GET("/track-availability/batch/:id", srv.HandleGetAvailabilityBatch)
 	api.GET("/preview-url", srv.HandleGetPreviewURL)
 
 	// Audio analysis
 	api.GET("/analyze-track", srv.HandleAnalyzeTrack)
 	api.POST("/analyze-tracks", srv.HandleAnalyzeMultipleTracks)
+	api.POST("/verify", srv.HandleVerify)
+	api.POST("/replaygain", srv.HandleReplayGain)
+	api.GET("/spectrogram.png", srv.HandleSpectrogram)
+	api.GET("/blacklist", srv.HandleGetBlacklist)
+	api.DELETE("/blacklist/:key", srv.HandleDeleteBlacklistEntry)
 
 	// FFmpeg
 	api.GET("/ffmpeg/installed", srv.HandleCheckFFmpegInstalled)
@@ -150,6 +219,7 @@ func main() {
 	api.POST("/rename-file", srv.HandleRenameFileTo)
 	api.POST("/check-files-existence", srv.HandleCheckFilesExistence)
 	api.POST("/create-m3u8", srv.HandleCreateM3U8File)
+	api.POST("/playlist/export", srv.HandleExportPlaylist)
 
 	// Image operations
 	api.POST("/upload-image", srv.HandleUploadImage)
@@ -196,6 +266,7 @@ func main() {
 	log.Printf("SpotiFLAC web server starting on http://localhost%s", address)
 	log.Printf("Download path: %s", downloadPath)
 	log.Printf("Data directory: %s", dataDir)
+	log.Printf("Download workers: %d", downloadWorkers)
 
 	// Start server in a goroutine
 	go func() {
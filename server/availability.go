@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"spotiflac/backend"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// maxAvailabilityBatch caps the number of tracks checked in one batch
+	maxAvailabilityBatch = 500
+	// availabilityWorkers is how many tracks are checked at once. song.link
+	// lookups are serialized by its rate limit either way, but the Tidal and
+	// Qobuz lookups of different tracks can overlap.
+	availabilityWorkers = 4
+	// availabilityBatchTimeout bounds a whole batch; a song.link lookup can
+	// take several seconds per uncached track
+	availabilityBatchTimeout = 2 * time.Hour
+	// availabilityRetention is how long a finished batch can be fetched
+	availabilityRetention = time.Hour
+)
+
+// availabilityRun is a batch availability check running in the background
+type availabilityRun struct {
+	mu     sync.Mutex
+	status AvailabilityBatchStatus
+}
+
+func (r *availabilityRun) snapshot() AvailabilityBatchStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// HandleCheckAvailabilityBatch resolves the tracks of an album, playlist or
+// artist, or takes a list of tracks, and checks them in the background.
+// Progress is broadcast as availability:progress SSE events and the result
+// as availability:done; both can also be polled by ID
+func (s *Server) HandleCheckAvailabilityBatch(c echo.Context) error {
+	var req AvailabilityBatchRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if req.URL == "" && len(req.Tracks) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "URL or tracks are required"})
+	}
+
+	if req.Timeout <= 0 {
+		req.Timeout = 300
+	}
+
+	resp := &AvailabilityBatchResponse{}
+	checks := req.Tracks
+	if req.URL != "" {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), time.Duration(req.Timeout)*time.Second)
+		data, err := backend.NewSpotifyMetadataClient().GetFilteredData(ctx, req.URL, false, 0)
+		cancel()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+
+		col, err := collectionFromMetadata(data)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		resp.Type, resp.Name = col.Type, col.Name
+
+		checks = make([]backend.AvailabilityCheck, 0, len(col.Tracks))
+		for _, track := range col.Tracks {
+			if track.SpotifyID == "" {
+				continue
+			}
+			explicit := track.IsExplicit
+			checks = append(checks, backend.AvailabilityCheck{
+				SpotifyID:  track.SpotifyID,
+				DurationMS: track.DurationMS,
+				Explicit:   &explicit,
+			})
+		}
+	}
+
+	if len(checks) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No tracks to check"})
+	}
+	if len(checks) > maxAvailabilityBatch {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("At most %d tracks can be checked at once", maxAvailabilityBatch)})
+	}
+
+	run := &availabilityRun{status: AvailabilityBatchStatus{
+		ID:    fmt.Sprintf("availability-%d", time.Now().UnixNano()),
+		Total: len(checks),
+	}}
+	s.availability.Store(run.status.ID, run)
+	go s.runAvailabilityBatch(run, checks, resp)
+
+	return c.JSON(http.StatusAccepted, run.snapshot())
+}
+
+// HandleGetAvailabilityBatch returns the progress of a batch availability
+// check, and its result once it has finished
+func (s *Server) HandleGetAvailabilityBatch(c echo.Context) error {
+	value, ok := s.availability.Load(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Availability check not found"})
+	}
+	return c.JSON(http.StatusOK, value.(*availabilityRun).snapshot())
+}
+
+func (s *Server) runAvailabilityBatch(run *availabilityRun, checks []backend.AvailabilityCheck, resp *AvailabilityBatchResponse) {
+	ctx, cancel := context.WithTimeout(context.Background(), availabilityBatchTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp.Tracks = s.checkAvailability(ctx, checks, func(result *backend.TrackAvailability) {
+		run.mu.Lock()
+		run.status.Done++
+		done := run.status.Done
+		run.mu.Unlock()
+
+		s.sseBroker.BroadcastJSON(map[string]interface{}{
+			"type":  "availability:progress",
+			"id":    run.status.ID,
+			"done":  done,
+			"total": len(checks),
+			"track": result,
+		})
+	})
+	resp.Summary, resp.Best = backend.SummarizeAvailability(resp.Tracks)
+
+	run.mu.Lock()
+	run.status.Finished = true
+	run.status.Response = resp
+	run.mu.Unlock()
+
+	s.sseBroker.BroadcastJSON(map[string]interface{}{
+		"type":     "availability:done",
+		"id":       run.status.ID,
+		"response": resp,
+	})
+	time.AfterFunc(availabilityRetention, func() {
+		s.availability.Delete(run.status.ID)
+	})
+}
+
+// checkAvailability checks the tracks concurrently and calls onResult as
+// each one finishes. Results keep the order of checks; a track that fails
+// carries its error instead of failing the batch.
+func (s *Server) checkAvailability(ctx context.Context, checks []backend.AvailabilityCheck, onResult func(*backend.TrackAvailability)) []*backend.TrackAvailability {
+	client := backend.GetSongLinkClient()
+	results := make([]*backend.TrackAvailability, len(checks))
+
+	forEachParallel(len(checks), availabilityWorkers, func(i int) {
+		result, err := client.CheckTrackQuality(ctx, checks[i])
+		if err != nil {
+			result = &backend.TrackAvailability{SpotifyID: checks[i].SpotifyID, Error: err.Error()}
+		}
+		results[i] = result
+		onResult(result)
+	})
+	return results
+}
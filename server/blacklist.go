@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"spotiflac/backend"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HandleGetBlacklist returns the provider tracks blacklisted as fake lossless
+func (s *Server) HandleGetBlacklist(c echo.Context) error {
+	entries, err := backend.GetBlacklist("SpotiFLAC")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// HandleDeleteBlacklistEntry removes a provider track from the blacklist so it
+// can be downloaded again
+func (s *Server) HandleDeleteBlacklistEntry(c echo.Context) error {
+	key, err := url.PathUnescape(c.Param("key"))
+	if err != nil || key == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Key is required"})
+	}
+
+	if err := backend.RemoveFromBlacklist(key, "SpotiFLAC"); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"spotiflac/backend"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// collection is the list of tracks behind an album, playlist or artist URL
+type collection struct {
+	Type          string
+	Name          string
+	PlaylistOwner string
+	Tracks        []backend.AlbumTrackMetadata
+}
+
+// collectionFromMetadata extracts the track list from a GetFilteredData result
+func collectionFromMetadata(data interface{}) (*collection, error) {
+	switch payload := data.(type) {
+	case *backend.AlbumResponsePayload:
+		return &collection{Type: "album", Name: payload.AlbumInfo.Name, Tracks: payload.TrackList}, nil
+	case backend.PlaylistResponsePayload:
+		owner := payload.PlaylistInfo.Owner.DisplayName
+		if owner == "" {
+			owner = payload.PlaylistInfo.Owner.Name
+		}
+		return &collection{Type: "playlist", Name: payload.PlaylistInfo.Name, PlaylistOwner: owner, Tracks: payload.TrackList}, nil
+	case *backend.ArtistDiscographyPayload:
+		return &collection{Type: "artist", Name: payload.ArtistInfo.Name, Tracks: payload.TrackList}, nil
+	default:
+		return nil, fmt.Errorf("URL must point to an album, playlist or artist")
+	}
+}
+
+// collectionTracks builds one download request per track. The folder template
+// is expanded per track by normalizeDownloadRequest.
+func (s *Server) collectionTracks(req CollectionDownloadRequest, col *collection, settings map[string]interface{}) []DownloadRequest {
+	service := req.Service
+	if service == "" {
+		service = backend.SettingString(settings, "downloader", "auto")
+	}
+	audioFormat := req.AudioFormat
+	if audioFormat == "" {
+		audioFormat = backend.DefaultQuality(settings, service)
+	}
+	filenameFormat := req.FilenameFormat
+	if filenameFormat == "" {
+		filenameFormat = backend.SettingString(settings, "filenameTemplate", "")
+	}
+	folderTemplate := backend.SettingString(settings, "folderTemplate", "")
+	if req.FolderTemplate != nil {
+		folderTemplate = *req.FolderTemplate
+	}
+	includeTrackNumber := backend.SettingBool(settings, "trackNumber", false)
+	if req.TrackNumber != nil {
+		includeTrackNumber = *req.TrackNumber
+	}
+	embedMaxQualityCover := backend.SettingBool(settings, "embedMaxQualityCover", false)
+	if req.EmbedMaxQualityCover != nil {
+		embedMaxQualityCover = *req.EmbedMaxQualityCover
+	}
+	useFirstArtistOnly := backend.SettingBool(settings, "useFirstArtistOnly", false)
+	if req.UseFirstArtistOnly != nil {
+		useFirstArtistOnly = *req.UseFirstArtistOnly
+	}
+
+	baseDir := req.OutputDir
+	if baseDir == "" {
+		baseDir = s.downloadPath
+	}
+
+	playlistName := ""
+	if col.Type == "playlist" {
+		playlistName = col.Name
+	}
+
+	// Mirrors useDownload.ts: playlists get their own folder unless the
+	// template already groups tracks by album or playlist
+	hasSubfolder := strings.TrimSpace(folderTemplate) != ""
+	groupsByFolder := false
+	if tmpl, err := backend.ParseTemplate(folderTemplate); err == nil {
+		groupsByFolder = tmpl.Uses("album", "album_artist", "playlist")
+	}
+	if playlistName != "" && !groupsByFolder && backend.SettingBool(settings, "createPlaylistFolder", true) {
+		baseDir = filepath.Join(baseDir, backend.SanitizeFilename(playlistName))
+	}
+
+	// Playlist batches write an M3U8 next to the tracks once every job is done
+	playlistDir := ""
+	createM3U8 := backend.SettingBool(settings, "createM3u8File", false)
+	if req.CreateM3U8 != nil {
+		createM3U8 = *req.CreateM3U8
+	}
+	if playlistName != "" && createM3U8 {
+		playlistDir = baseDir
+	}
+
+	tracks := make([]DownloadRequest, 0, len(col.Tracks))
+	for i, track := range col.Tracks {
+		position := i + 1
+		if hasSubfolder && track.TrackNumber > 0 {
+			position = track.TrackNumber
+		}
+
+		tracks = append(tracks, DownloadRequest{
+			Service:              service,
+			TrackName:            track.Name,
+			ArtistName:           track.Artists,
+			AlbumName:            track.AlbumName,
+			AlbumArtist:          track.AlbumArtist,
+			ReleaseDate:          track.ReleaseDate,
+			CoverURL:             track.Images,
+			OutputDir:            baseDir,
+			FolderTemplate:       folderTemplate,
+			AudioFormat:          audioFormat,
+			FilenameFormat:       filenameFormat,
+			TrackNumber:          includeTrackNumber,
+			Position:             position,
+			UseAlbumTrackNumber:  hasSubfolder,
+			SpotifyID:            track.SpotifyID,
+			EmbedMaxQualityCover: embedMaxQualityCover,
+			Duration:             track.DurationMS / 1000,
+			SpotifyTrackNumber:   track.TrackNumber,
+			SpotifyDiscNumber:    track.DiscNumber,
+			SpotifyTotalTracks:   track.TotalTracks,
+			SpotifyTotalDiscs:    track.TotalDiscs,
+			PlaylistName:         playlistName,
+			PlaylistOwner:        col.PlaylistOwner,
+			UseFirstArtistOnly:   useFirstArtistOnly,
+			FallbackChain:        req.FallbackChain,
+			FallbackProfile:      req.FallbackProfile,
+			Explicit:             track.IsExplicit,
+			PlaylistDir:          playlistDir,
+		})
+	}
+	return tracks
+}
+
+// HandleDownloadCollection resolves a Spotify album, playlist or artist URL and
+// enqueues every track as a download job
+func (s *Server) HandleDownloadCollection(c echo.Context) error {
+	var req CollectionDownloadRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if req.URL == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "URL is required"})
+	}
+
+	if req.Timeout <= 0 {
+		req.Timeout = 300
+	}
+
+	settings, err := backend.LoadSettings()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), time.Duration(req.Timeout)*time.Second)
+	defer cancel()
+
+	data, err := backend.NewSpotifyMetadataClient().GetFilteredData(ctx, req.URL, false, 0)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	col, err := collectionFromMetadata(data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(col.Tracks) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s has no tracks", col.Type)})
+	}
+
+	batchID := fmt.Sprintf("batch-%d", time.Now().UnixNano())
+	tracks := s.collectionTracks(req, col, settings)
+
+	jobs := make([]backend.Job, 0, len(tracks))
+	for i := range tracks {
+		job, err := s.newDownloadJob(tracks[i], batchID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("track %d: %s", i+1, err.Error())})
+		}
+		jobs = append(jobs, job)
+	}
+
+	jobs, err = s.jobQueue.Enqueue(jobs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, CollectionDownloadResponse{
+		Type:    col.Type,
+		Name:    col.Name,
+		BatchID: batchID,
+		Jobs:    jobs,
+	})
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"spotiflac/backend"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,6 +25,19 @@ type Server struct {
 	sseBroker      *SSEBroker
 	downloadPath   string
 	dataDir        string
+	jobQueue       *backend.JobQueue
+	playlistMu     sync.Mutex
+	subscriptions  *backend.SubscriptionStore
+	syncMu         sync.Mutex
+	syncRuns       sync.Map
+	watchlist      *backend.WatchlistStore
+	watchMu        sync.Mutex
+	schedules      *backend.ScheduleStore
+	windowOverride atomic.Bool
+	gainBatches    sync.Map
+	scheduleRuns   sync.Map
+	availability   sync.Map
+	stop           chan struct{}
 }
 
 // NewServer creates a new server instance
@@ -32,9 +49,31 @@ func NewServer(downloadPath, dataDir string) *Server {
 		sseBroker:    broker,
 		downloadPath: downloadPath,
 		dataDir:      dataDir,
+		stop:         make(chan struct{}),
 	}
 }
 
+// forEachParallel calls fn for every index below n on up to workers
+// goroutines and returns once all calls have finished
+func forEachParallel(n, workers int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
 // getFirstArtist extracts the first artist from a delimited string
 func getFirstArtist(artistString string) string {
 	if artistString == "" {
@@ -147,8 +186,8 @@ func (s *Server) HandleGetStreamingURLs(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Spotify track ID is required"})
 	}
 
-	client := backend.NewSongLinkClient()
-	songlink, err := client.GetAllURLsFromSpotify(spotifyTrackID, region)
+	client := backend.GetSongLinkClient()
+	songlink, err := client.GetAllURLsFromSpotify(c.Request().Context(), spotifyTrackID, region)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -227,50 +266,38 @@ func (s *Server) HandleDownloadTrack(c echo.Context) error {
 		})
 	}
 
-	// SECURITY: Validate and sanitize output directory
-	// The frontend calculates OutputDir with folder templates applied.
-	// We must ensure it's within the allowed downloadPath boundary.
-	if req.OutputDir != "" {
-		// Check if the requested path is within our download path
-		absRequested, err := filepath.Abs(req.OutputDir)
-		if err != nil {
-			// If we can't resolve the path, use default
-			req.OutputDir = s.downloadPath
-		} else {
-			absDownloadPath, err := filepath.Abs(s.downloadPath)
-			if err != nil || !strings.HasPrefix(absRequested, absDownloadPath) {
-				// Path is outside allowed boundary or can't be validated - use default
-				req.OutputDir = s.downloadPath
-			}
-			// Otherwise, keep the client's OutputDir (which includes folder template)
-		}
-	} else {
-		req.OutputDir = s.downloadPath
-	}
-
-	if req.Service == "qobuz" && req.SpotifyID == "" {
+	if err := s.normalizeDownloadRequest(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, DownloadResponse{
 			Success: false,
-			Error:   "Spotify ID is required for Qobuz",
+			Error:   err.Error(),
 		})
 	}
 
+	return c.JSON(http.StatusOK, s.executeDownload(c.Request().Context(), req))
+}
+
+// normalizeDownloadRequest validates a download request and fills in defaults
+func (s *Server) normalizeDownloadRequest(req *DownloadRequest) error {
+	if req.Service == "qobuz" && req.SpotifyID == "" {
+		return errors.New("Spotify ID is required for Qobuz")
+	}
+
 	if req.Service == "" {
 		req.Service = "tidal"
 	}
 
+	if _, err := req.fallbackChain(); err != nil {
+		return err
+	}
+
 	if req.AudioFormat == "" {
 		req.AudioFormat = "flac"
 	}
 
 	if req.FilenameFormat == "" {
-		req.FilenameFormat = "{track_number}. {track_name}"
+		req.FilenameFormat = "<{track}. >{title}"
 	}
 
-	// Folder template is applied by the frontend in useDownload.ts
-	// The frontend calculates the full OutputDir path with templates applied
-	// and we validate it's within the download path boundary above
-
 	// Handle first artist only if requested
 	if req.UseFirstArtistOnly && req.ArtistName != "" {
 		req.ArtistName = getFirstArtist(req.ArtistName)
@@ -279,26 +306,174 @@ func (s *Server) HandleDownloadTrack(c echo.Context) error {
 		}
 	}
 
+	outputDir := req.OutputDir
+	if outputDir == "" {
+		outputDir = s.downloadPath
+	}
+
+	// Apply the folder template on top of the requested directory. It is
+	// cleared afterwards so a normalized request is never expanded twice.
+	if req.FolderTemplate != "" {
+		folder, err := backend.BuildFolderPath(req.FolderTemplate, req.templateData())
+		if err != nil {
+			return fmt.Errorf("invalid folder template: %w", err)
+		}
+		outputDir = filepath.Join(outputDir, folder)
+		req.FolderTemplate = ""
+	}
+
+	// SECURITY: Validate the final resolved path. It must stay within the
+	// allowed downloadPath boundary, otherwise the default is used.
+	req.OutputDir = s.downloadPath
+	if s.inDownloadPath(outputDir) {
+		req.OutputDir = outputDir
+	}
+	if req.PlaylistDir != "" && !s.inDownloadPath(req.PlaylistDir) {
+		req.PlaylistDir = ""
+	}
+
+	return nil
+}
+
+// inDownloadPath reports whether path resolves to the download path or a
+// folder below it
+func (s *Server) inDownloadPath(path string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDownloadPath, err := filepath.Abs(s.downloadPath)
+	if err != nil {
+		return false
+	}
+	return isWithinDir(absPath, absDownloadPath)
+}
+
+// isWithinDir reports whether path is dir or one of its descendants
+func isWithinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// templateData returns the values available to folder templates
+func (req DownloadRequest) templateData() backend.TemplateData {
+	track := req.Position
+	if req.SpotifyTrackNumber > 0 {
+		track = req.SpotifyTrackNumber
+	}
+
+	return backend.TemplateData{
+		Title:       req.TrackName,
+		Artist:      req.ArtistName,
+		Album:       req.AlbumName,
+		AlbumArtist: req.AlbumArtist,
+		ReleaseDate: req.ReleaseDate,
+		Playlist:    req.PlaylistName,
+		Creator:     req.PlaylistOwner,
+		Track:       track,
+		Disc:        req.SpotifyDiscNumber,
+		TotalTracks: req.SpotifyTotalTracks,
+		TotalDiscs:  req.SpotifyTotalDiscs,
+		ISRC:        req.ISRC,
+		Label:       req.Publisher,
+		Quality:     req.AudioFormat,
+		Explicit:    req.Explicit,
+	}
+}
+
+// trackRequest converts a download request into a provider track request
+func (req DownloadRequest) trackRequest() backend.TrackRequest {
+	return backend.TrackRequest{
+		SpotifyID:            req.SpotifyID,
+		OutputDir:            req.OutputDir,
+		Quality:              req.AudioFormat,
+		RequestedQuality:     req.AudioFormat,
+		FilenameFormat:       req.FilenameFormat,
+		PlaylistName:         req.PlaylistName,
+		PlaylistOwner:        req.PlaylistOwner,
+		IncludeTrackNumber:   req.Position > 0,
+		Position:             req.Position,
+		UseAlbumTrackNumber:  req.UseAlbumTrackNumber,
+		TrackName:            req.TrackName,
+		ArtistName:           req.ArtistName,
+		AlbumName:            req.AlbumName,
+		AlbumArtist:          req.AlbumArtist,
+		ReleaseDate:          req.ReleaseDate,
+		CoverURL:             req.CoverURL,
+		EmbedMaxQualityCover: req.EmbedMaxQualityCover,
+		TrackNumber:          req.SpotifyTrackNumber,
+		DiscNumber:           req.SpotifyDiscNumber,
+		TotalTracks:          req.SpotifyTotalTracks,
+		TotalDiscs:           req.SpotifyTotalDiscs,
+		Copyright:            req.Copyright,
+		Publisher:            req.Publisher,
+		SpotifyURL:           req.ServiceURL,
+		AllowFallback:        req.AllowFallback,
+		UseFirstArtistOnly:   req.UseFirstArtistOnly,
+		ISRC:                 req.ISRC,
+		Explicit:             req.Explicit,
+		Duration:             req.Duration,
+	}
+}
+
+// progressMessage formats a progress update for display.
+func progressMessage(update backend.ProgressUpdate) string {
+	if update.TotalMB <= 0 {
+		return fmt.Sprintf("Downloading: %.2f MB (%.2f MB/s)", update.MBDownloaded, update.SpeedMBps)
+	}
+	msg := fmt.Sprintf("Downloading: %.2f / %.2f MB (%.0f%%, %.2f MB/s)", update.MBDownloaded, update.TotalMB, update.Percent, update.SpeedMBps)
+	if update.ETA >= 0 {
+		msg += fmt.Sprintf(", %s left", (time.Duration(update.ETA) * time.Second).String())
+	}
+	return msg
+}
+
+// fallbackChain returns the services to try for a request: an explicit chain,
+// a named settings profile, the auto order from settings, or the single
+// requested service
+func (req DownloadRequest) fallbackChain() ([]backend.FallbackStep, error) {
+	switch {
+	case req.FallbackChain != "":
+		return backend.ParseFallbackChain(req.FallbackChain)
+	case req.FallbackProfile != "":
+		return backend.GetFallbackProfile(req.FallbackProfile)
+	case req.Service == "auto":
+		return backend.DefaultFallbackChain()
+	}
+
+	if _, err := backend.GetProvider(req.Service); err != nil {
+		return nil, err
+	}
+	return []backend.FallbackStep{{Provider: req.Service, Quality: req.AudioFormat}}, nil
+}
+
+// executeDownload performs a normalized download request and reports progress over SSE.
+// Cancelling ctx aborts the transfer.
+func (s *Server) executeDownload(ctx context.Context, req DownloadRequest) DownloadResponse {
 	// Create download item if ItemID is provided
 	if req.ItemID != "" {
 		backend.AddToQueue(req.ItemID, req.TrackName, req.ArtistName, req.AlbumName, req.SpotifyID)
 		backend.StartDownloadItem(req.ItemID)
 
-		// Set up global progress callback for this download
-		backend.SetGlobalProgressCallback(func(itemID string, mbDownloaded, speedMBps float64) {
-			// Calculate percentage if we have total size info
-			percent := mbDownloaded // Approximate percentage, actual file size unknown until complete
-
-			// Broadcast progress event
+		// Each download gets its own reporter so concurrent items don't
+		// overwrite each other's progress
+		reporter := backend.NewProgressReporter(req.ItemID, func(update backend.ProgressUpdate) {
 			s.sseBroker.BroadcastJSON(map[string]interface{}{
-				"type":     "download:progress",
-				"item_id":  itemID,
-				"status":   "downloading",
-				"percent":  percent,
-				"speed":    speedMBps,
-				"message":  fmt.Sprintf("Downloading: %.2f MB (%.2f MB/s)", mbDownloaded, speedMBps),
+				"type":          "download:progress",
+				"item_id":       update.ItemID,
+				"status":        "downloading",
+				"percent":       update.Percent,
+				"mb_downloaded": update.MBDownloaded,
+				"total_mb":      update.TotalMB,
+				"speed":         update.SpeedMBps,
+				"eta":           update.ETA,
+				"message":       progressMessage(update),
 			})
 		})
+		ctx = backend.WithProgressReporter(ctx, reporter)
 
 		// Broadcast start event
 		s.sseBroker.BroadcastJSON(map[string]interface{}{
@@ -310,36 +485,35 @@ func (s *Server) HandleDownloadTrack(c echo.Context) error {
 		})
 	}
 
-	// Perform the download
-	var downloadErr error
-	var filePath string
-	var message string
-	success := false
-
-	switch req.Service {
-	case "tidal":
-		downloader := backend.NewTidalDownloader(req.ApiURL)
-		filePath, downloadErr = downloader.Download(req.SpotifyID, req.OutputDir, req.Query, req.FilenameFormat, req.Position > 0, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.UseAlbumTrackNumber, req.CoverURL, req.EmbedMaxQualityCover, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, req.ServiceURL, req.AllowFallback, req.UseFirstArtistOnly)
-	case "qobuz":
-		downloader := backend.NewQobuzDownloader()
-		filePath, downloadErr = downloader.DownloadTrack(req.SpotifyID, req.OutputDir, req.Query, req.FilenameFormat, req.Position > 0, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.UseAlbumTrackNumber, req.CoverURL, req.EmbedMaxQualityCover, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, req.ServiceURL, req.AllowFallback, req.UseFirstArtistOnly)
-	case "amazon":
-		downloader := backend.NewAmazonDownloader()
-		filePath, downloadErr = downloader.DownloadBySpotifyID(req.SpotifyID, req.OutputDir, req.Query, req.FilenameFormat, "", "", req.Position > 0, req.Position, req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist, req.ReleaseDate, req.CoverURL, req.SpotifyTrackNumber, req.SpotifyDiscNumber, req.SpotifyTotalTracks, req.EmbedMaxQualityCover, req.SpotifyTotalDiscs, req.Copyright, req.Publisher, req.ServiceURL, req.UseFirstArtistOnly)
-	default:
-		downloadErr = fmt.Errorf("unsupported service: %s", req.Service)
-	}
-
-	// Clear global callback after download completes
-	if req.ItemID != "" {
-		backend.SetGlobalProgressCallback(nil)
+	// Perform the download, trying each service of the fallback chain in turn
+	var result *backend.DownloadResult
+	chain, downloadErr := req.fallbackChain()
+	if downloadErr == nil {
+		result, downloadErr = backend.DownloadWithFallback(ctx, chain, req.trackRequest(), func(attempt backend.FallbackAttempt) {
+			if req.ItemID == "" {
+				return
+			}
+			if attempt.Status == backend.AttemptTrying {
+				backend.SetDownloadItemSource(req.ItemID, attempt.Provider, attempt.Quality)
+			}
+			s.sseBroker.BroadcastJSON(map[string]interface{}{
+				"type":     "download:attempt",
+				"item_id":  req.ItemID,
+				"attempt":  attempt.Attempt,
+				"total":    attempt.Total,
+				"provider": attempt.Provider,
+				"quality":  attempt.Quality,
+				"status":   attempt.Status,
+				"error":    attempt.Error,
+			})
+		})
 	}
 
 	// Check if file already exists
-	if downloadErr == nil && filePath != "" && strings.HasPrefix(filePath, "EXISTS:") {
-		actualPath := strings.TrimPrefix(filePath, "EXISTS:")
+	if downloadErr == nil && result.AlreadyExists {
 		if req.ItemID != "" {
-			backend.SkipDownloadItem(req.ItemID, actualPath)
+			backend.SetDownloadItemSource(req.ItemID, result.Provider, result.Quality)
+			backend.SkipDownloadItem(req.ItemID, result.FilePath)
 			// Broadcast exists event
 			s.sseBroker.BroadcastJSON(map[string]interface{}{
 				"type":    "download:progress",
@@ -349,46 +523,59 @@ func (s *Server) HandleDownloadTrack(c echo.Context) error {
 				"message": "File already exists",
 			})
 		}
-		return c.JSON(http.StatusOK, DownloadResponse{
-			Success: true,
-			Message: "File already exists",
-			File:    actualPath,
+		return DownloadResponse{
+			Success:       true,
+			Message:       "File already exists",
+			File:          result.FilePath,
+			AlreadyExists: true,
+			Provider:      result.Provider,
+			ItemID:        req.ItemID,
+		}
+	}
+
+	if downloadErr != nil && ctx.Err() != nil {
+		if req.ItemID != "" {
+			backend.CancelDownloadItem(req.ItemID)
+			// Broadcast cancellation event
+			s.sseBroker.BroadcastJSON(map[string]interface{}{
+				"type":    "download:progress",
+				"item_id": req.ItemID,
+				"status":  "cancelled",
+				"message": "Download cancelled",
+			})
+		}
+		return DownloadResponse{
+			Success: false,
+			Error:   "Download cancelled",
 			ItemID:  req.ItemID,
-		})
+		}
 	}
 
 	if downloadErr != nil {
-		if req.AllowFallback && req.ItemID != "" {
+		if req.AllowFallback && req.ItemID != "" && len(chain) == 1 {
 			// Return error but don't mark as failed yet - caller will handle fallback
-			return c.JSON(http.StatusOK, DownloadResponse{
+			return DownloadResponse{
 				Success: false,
 				Error:   downloadErr.Error(),
 				ItemID:  req.ItemID,
-			})
+			}
 		}
 
 		if req.ItemID != "" {
-			backend.FailDownloadItem(req.ItemID, downloadErr.Error())
-			// Broadcast failure event
-			s.sseBroker.BroadcastJSON(map[string]interface{}{
-				"type":    "download:progress",
-				"item_id": req.ItemID,
-				"status":  "error",
-				"message": downloadErr.Error(),
-			})
+			s.failDownloadItem(req.ItemID, downloadErr.Error())
 		}
 
-		return c.JSON(http.StatusOK, DownloadResponse{
+		return DownloadResponse{
 			Success: false,
 			Error:   downloadErr.Error(),
 			ItemID:  req.ItemID,
-		})
+		}
 	}
 
-	success = true
-	message = "Download completed successfully"
+	filePath := result.FilePath
 
 	if req.ItemID != "" {
+		backend.SetDownloadItemSource(req.ItemID, result.Provider, result.Quality)
 		// Get file size
 		var finalSize float64
 		if fileInfo, err := os.Stat(filePath); err == nil {
@@ -405,27 +592,70 @@ func (s *Server) HandleDownloadTrack(c echo.Context) error {
 		})
 	}
 
+	quality := result.Quality
+	if quality == "" {
+		quality = req.AudioFormat
+	}
+
 	// Add to download history
 	historyItem := backend.HistoryItem{
-		Title:     req.TrackName,
-		Artists:   req.ArtistName,
-		Album:     req.AlbumName,
-		Quality:   req.AudioFormat,
-		Format:    req.AudioFormat,
-		Timestamp: time.Now().Unix(),
-		Path:      filePath,
-		SpotifyID: req.SpotifyID,
+		Title:        req.TrackName,
+		Artists:      req.ArtistName,
+		Album:        req.AlbumName,
+		Quality:      quality,
+		Format:       strings.TrimPrefix(filepath.Ext(filePath), "."),
+		Provider:     result.Provider,
+		Match:        result.Match,
+		Verification: result.Verification,
+		Verdict:      result.Verdict,
+		Timestamp:    time.Now().Unix(),
+		Path:         filePath,
+		SpotifyID:    req.SpotifyID,
 	}
 	backend.AddHistoryItem(historyItem, "SpotiFLAC")
 
-	return c.JSON(http.StatusOK, DownloadResponse{
-		Success: success,
-		Message: message,
-		File:    filePath,
-		ItemID:  req.ItemID,
+	return DownloadResponse{
+		Success:      true,
+		Message:      "Download completed successfully",
+		File:         filePath,
+		Provider:     result.Provider,
+		Quality:      result.Quality,
+		Match:        result.Match,
+		Verification: result.Verification,
+		Verdict:      result.Verdict,
+		ReplayGain:   result.ReplayGain,
+		ItemID:       req.ItemID,
+	}
+}
+
+// failDownloadItem marks a queue item as failed and broadcasts the failure
+func (s *Server) failDownloadItem(itemID, errorMsg string) {
+	backend.FailDownloadItem(itemID, errorMsg)
+	// Broadcast failure event
+	s.sseBroker.BroadcastJSON(map[string]interface{}{
+		"type":    "download:progress",
+		"item_id": itemID,
+		"status":  "error",
+		"message": errorMsg,
 	})
 }
 
+// HandleGetProviders lists the registered download providers and their qualities
+func (s *Server) HandleGetProviders(c echo.Context) error {
+	result := []ProviderInfo{}
+	for _, name := range backend.ProviderNames() {
+		provider, err := backend.GetProvider(name)
+		if err != nil {
+			continue
+		}
+		result = append(result, ProviderInfo{
+			Name:      provider.Name(),
+			Qualities: provider.Qualities(),
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
 // HandleDownloadLyrics handles lyrics download requests
 func (s *Server) HandleDownloadLyrics(c echo.Context) error {
 	var req LyricsDownloadRequest
@@ -682,6 +912,9 @@ func (s *Server) HandleClearAllDownloads(c echo.Context) error {
 // HandleCancelAllQueuedItems cancels all queued items
 func (s *Server) HandleCancelAllQueuedItems(c echo.Context) error {
 	backend.CancelAllQueuedItems()
+	if s.jobQueue != nil {
+		s.jobQueue.CancelQueued()
+	}
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
@@ -751,23 +984,8 @@ func (s *Server) HandleGetDefaults(c echo.Context) error {
 
 // HandleLoadSettings loads settings from file
 func (s *Server) HandleLoadSettings(c echo.Context) error {
-	configPath, err := backend.GetFFmpegDir()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-
-	settingsFile := filepath.Join(configPath, "settings.json")
-	data, err := os.ReadFile(settingsFile)
+	settings, err := backend.LoadSettings()
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Return default settings
-			return c.JSON(http.StatusOK, map[string]interface{}{})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-
-	var settings map[string]interface{}
-	if err := json.Unmarshal(data, &settings); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
@@ -781,24 +999,10 @@ func (s *Server) HandleSaveSettings(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 
-	configPath, err := backend.GetFFmpegDir()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-
-	if err := os.MkdirAll(configPath, 0755); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-
-	settingsFile := filepath.Join(configPath, "settings.json")
-	data, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-
-	if err := os.WriteFile(settingsFile, data, 0644); err != nil {
+	if err := backend.SaveSettings(settings); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
+	backend.ApplyRateLimits(settings)
 
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -893,16 +1097,32 @@ func (s *Server) HandleAddFetchHistory(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// HandleCheckTrackAvailability checks if a track is available
+// HandleCheckTrackAvailability checks if a track is available and in which
+// quality. The optional duration_ms and explicit parameters are the Spotify
+// values each service is compared against
 func (s *Server) HandleCheckTrackAvailability(c echo.Context) error {
-	spotifyTrackID := c.QueryParam("spotify_track_id")
+	check := backend.AvailabilityCheck{SpotifyID: c.QueryParam("spotify_track_id")}
 
-	if spotifyTrackID == "" {
+	if check.SpotifyID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Spotify track ID is required"})
 	}
+	if v := c.QueryParam("duration_ms"); v != "" {
+		durationMS, err := strconv.Atoi(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid duration_ms"})
+		}
+		check.DurationMS = durationMS
+	}
+	if v := c.QueryParam("explicit"); v != "" {
+		explicit, err := strconv.ParseBool(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid explicit flag"})
+		}
+		check.Explicit = &explicit
+	}
 
-	client := backend.NewSongLinkClient()
-	availability, err := client.CheckTrackAvailability(spotifyTrackID)
+	client := backend.GetSongLinkClient()
+	availability, err := client.CheckTrackQuality(c.Request().Context(), check)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -939,7 +1159,7 @@ func (s *Server) HandleAnalyzeTrack(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "File path is required"})
 	}
 
-	analysis, err := backend.AnalyzeTrack(filePath)
+	analysis, err := backend.AnalyzeTrack(c.Request().Context(), filePath)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -969,7 +1189,7 @@ func (s *Server) HandleAnalyzeMultipleTracks(c echo.Context) error {
 	var results []map[string]interface{}
 
 	for _, filePath := range req.FilePaths {
-		analysis, err := backend.AnalyzeTrack(filePath)
+		analysis, err := backend.AnalyzeTrack(c.Request().Context(), filePath)
 		if err != nil {
 			results = append(results, map[string]interface{}{
 				"file_path": filePath,
@@ -1053,7 +1273,7 @@ func (s *Server) HandleConvertAudio(c echo.Context) error {
 		Codec:        req.Codec,
 	}
 
-	results, err := backend.ConvertAudio(backendReq)
+	results, err := backend.ConvertAudio(c.Request().Context(), backendReq)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -1303,19 +1523,6 @@ func (s *Server) HandleCheckFilesExistence(c echo.Context) error {
 	return c.JSON(http.StatusOK, results)
 }
 
-// HandleCreateM3U8File creates an M3U8 playlist file
-// Note: This feature is not supported in web server mode as it requires local file system access
-func (s *Server) HandleCreateM3U8File(c echo.Context) error {
-	var req M3U8Request
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
-	}
-
-	return c.JSON(http.StatusNotImplemented, map[string]string{
-		"error": "M3U8 playlist creation is not supported in web server mode",
-	})
-}
-
 // HandleGetOSInfo returns OS information
 func (s *Server) HandleGetOSInfo(c echo.Context) error {
 	osInfo, err := backend.GetOSInfo()
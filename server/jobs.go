@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"spotiflac/backend"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StartJobs creates the persistent job queue and starts its workers
+func (s *Server) StartJobs(workers int) error {
+	s.jobQueue = backend.NewJobQueue("SpotiFLAC", workers, s.runDownloadJob)
+	s.jobQueue.SetAdmitFunc(s.downloadAllowed)
+	if s.schedules != nil && s.schedules.QueuePaused() {
+		s.jobQueue.Pause()
+	}
+	s.jobQueue.SetUpdateCallback(func(job backend.Job) {
+		s.sseBroker.BroadcastJSON(map[string]interface{}{
+			"type": "job:update",
+			"job":  job,
+		})
+		if job.BatchID != "" && job.IsFinished() {
+			go s.writeBatchPlaylist(job.BatchID)
+			go s.applyBatchReplayGain(job.BatchID)
+		}
+	})
+	return s.jobQueue.Start()
+}
+
+// Close stops background workers
+func (s *Server) Close() {
+	close(s.stop)
+	if s.jobQueue != nil {
+		s.jobQueue.Stop()
+	}
+}
+
+// runDownloadJob executes a queued download job
+func (s *Server) runDownloadJob(ctx context.Context, job *backend.Job) backend.JobResult {
+	var req DownloadRequest
+	if err := json.Unmarshal(job.Payload, &req); err != nil {
+		errorMsg := fmt.Sprintf("invalid job payload: %v", err)
+		s.failDownloadItem(job.ID, errorMsg)
+		return backend.JobResult{Status: backend.JobFailed, Error: errorMsg}
+	}
+
+	// Queue items are keyed by job ID so progress events line up with the job
+	req.ItemID = job.ID
+	// The job is the final attempt; there is no caller left to handle a fallback
+	req.AllowFallback = false
+
+	resp := s.executeDownload(ctx, req)
+	switch {
+	case ctx.Err() != nil && !resp.Success:
+		return backend.JobResult{Status: backend.JobCancelled, Error: "Cancelled"}
+	case resp.Success && resp.AlreadyExists:
+		return backend.JobResult{Status: backend.JobSkipped, FilePath: resp.File}
+	case resp.Success:
+		return backend.JobResult{Status: backend.JobCompleted, FilePath: resp.File}
+	default:
+		return backend.JobResult{Status: backend.JobFailed, Error: resp.Error}
+	}
+}
+
+// HandleCreateJobs enqueues download jobs that are processed by the server's worker pool
+func (s *Server) HandleCreateJobs(c echo.Context) error {
+	var req JobsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if len(req.Tracks) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "At least one track is required"})
+	}
+
+	batchID := ""
+	if len(req.Tracks) > 1 {
+		batchID = fmt.Sprintf("batch-%d", time.Now().UnixNano())
+	}
+
+	jobs := make([]backend.Job, 0, len(req.Tracks))
+	for i := range req.Tracks {
+		job, err := s.newDownloadJob(req.Tracks[i], batchID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("track %d: %s", i+1, err.Error())})
+		}
+		jobs = append(jobs, job)
+	}
+
+	jobs, err := s.jobQueue.Enqueue(jobs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, JobsResponse{BatchID: batchID, Jobs: jobs})
+}
+
+// newDownloadJob validates a download request and wraps it in a job for the queue
+func (s *Server) newDownloadJob(track DownloadRequest, batchID string) (backend.Job, error) {
+	if err := s.normalizeDownloadRequest(&track); err != nil {
+		return backend.Job{}, err
+	}
+	track.ItemID = ""
+
+	payload, err := json.Marshal(track)
+	if err != nil {
+		return backend.Job{}, err
+	}
+
+	return backend.Job{
+		BatchID:    batchID,
+		TrackName:  track.TrackName,
+		ArtistName: track.ArtistName,
+		AlbumName:  track.AlbumName,
+		SpotifyID:  track.SpotifyID,
+		Payload:    payload,
+	}, nil
+}
+
+// HandleListJobs lists download jobs, optionally filtered by status and batch
+func (s *Server) HandleListJobs(c echo.Context) error {
+	status := backend.JobStatus(c.QueryParam("status"))
+	batchID := c.QueryParam("batch_id")
+
+	jobs, err := s.jobQueue.ListJobs(status, batchID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// HandleGetJob returns a single download job
+func (s *Server) HandleGetJob(c echo.Context) error {
+	id := c.Param("id")
+
+	job, err := s.jobQueue.GetJob(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if job == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Job not found"})
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// HandleCancelJob cancels a queued or running download job and removes its partial files
+func (s *Server) HandleCancelJob(c echo.Context) error {
+	id := c.Param("id")
+
+	cancelled, err := s.jobQueue.Cancel(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !cancelled {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Job not found or already finished"})
+	}
+
+	// Queued jobs never reach a worker, so update their queue item here
+	if job, err := s.jobQueue.GetJob(id); err == nil && job != nil && job.Status == backend.JobCancelled {
+		backend.CancelDownloadItem(id)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleClearFinishedJobs removes completed, failed, skipped and cancelled jobs
+func (s *Server) HandleClearFinishedJobs(c echo.Context) error {
+	if err := s.jobQueue.ClearFinishedJobs(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	s.forgetClearedGainBatches()
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
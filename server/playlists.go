@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"spotiflac/backend"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// writePlaylist reads the tracks and writes <name>.m3u8 into dir
+func writePlaylist(dir, name string, paths []string) (string, int, error) {
+	entries := backend.ReadPlaylistEntries(paths)
+	if len(entries) == 0 {
+		return "", 0, fmt.Errorf("none of the playlist files exist")
+	}
+
+	playlistPath := filepath.Join(dir, backend.SanitizeFilename(name)+".m3u8")
+	if err := backend.WriteM3U8(playlistPath, entries); err != nil {
+		return "", 0, err
+	}
+	return playlistPath, len(entries), nil
+}
+
+// HandleCreateM3U8File writes an extended M3U8 playlist for downloaded files.
+// The playlist and its tracks must be inside the download path.
+func (s *Server) HandleCreateM3U8File(c echo.Context) error {
+	var req M3U8Request
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if strings.TrimSpace(req.M3U8Name) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Playlist name is required"})
+	}
+
+	outputDir := req.OutputDir
+	if outputDir == "" {
+		outputDir = s.downloadPath
+	}
+	if !s.inDownloadPath(outputDir) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Output directory must be inside the download path"})
+	}
+
+	paths := make([]string, 0, len(req.FilePaths))
+	for _, path := range req.FilePaths {
+		if path != "" && s.inDownloadPath(path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No files inside the download path"})
+	}
+
+	file, tracks, err := writePlaylist(outputDir, req.M3U8Name, paths)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, M3U8Response{Success: true, File: file, Tracks: tracks})
+}
+
+// writeBatchPlaylist regenerates the M3U8 of a playlist batch once all of its
+// jobs have finished. Batches without a playlist directory are ignored.
+func (s *Server) writeBatchPlaylist(batchID string) {
+	if subID, ok := subscriptionFromBatch(batchID); ok {
+		s.refreshSubscriptionPlaylist(subID)
+		return
+	}
+
+	s.playlistMu.Lock()
+	defer s.playlistMu.Unlock()
+
+	jobs, err := s.jobQueue.ListJobs("", batchID)
+	if err != nil || len(jobs) == 0 {
+		return
+	}
+
+	var paths []string
+	for _, job := range jobs {
+		if !job.IsFinished() {
+			return
+		}
+		if (job.Status == backend.JobCompleted || job.Status == backend.JobSkipped) && job.FilePath != "" {
+			paths = append(paths, job.FilePath)
+		}
+	}
+
+	var req DownloadRequest
+	if err := json.Unmarshal(jobs[0].Payload, &req); err != nil || req.PlaylistDir == "" || req.PlaylistName == "" {
+		return
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	file, tracks, err := writePlaylist(req.PlaylistDir, req.PlaylistName, paths)
+	if err != nil {
+		fmt.Printf("✗ Failed to write playlist for %s: %v\n", batchID, err)
+		return
+	}
+
+	fmt.Printf("✓ Wrote playlist %s (%d tracks)\n", file, tracks)
+	s.sseBroker.BroadcastJSON(map[string]interface{}{
+		"type":     "playlist:created",
+		"batch_id": batchID,
+		"file":     file,
+		"tracks":   tracks,
+	})
+}
+
+// HandleExportPlaylist writes a playlist as M3U8, XSPF, JSPF or PLS. Tracks
+// that were not downloaded are kept with their Spotify URL and ISRC, except in
+// M3U8 which can only list files.
+func (s *Server) HandleExportPlaylist(c echo.Context) error {
+	var req PlaylistExportRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	format := strings.ToLower(req.Format)
+	ext, ok := backend.PlaylistFormats[format]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be m3u8, xspf, jspf or pls"})
+	}
+	if len(req.Playlist.TrackList) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Playlist has no tracks"})
+	}
+
+	outputDir := req.OutputDir
+	if outputDir == "" {
+		outputDir = s.downloadPath
+	}
+	if !s.inDownloadPath(outputDir) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Output directory must be inside the download path"})
+	}
+
+	files := map[string]string{}
+	isrcs := map[string]string{}
+	for id, isrc := range req.ISRCs {
+		isrcs[id] = isrc
+	}
+	if req.BatchID != "" {
+		if err := s.batchFiles(req.BatchID, files, isrcs); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	for id, path := range req.FilePaths {
+		files[id] = path
+	}
+	for id, path := range files {
+		if !s.inDownloadPath(path) {
+			delete(files, id)
+		}
+	}
+
+	export := backend.NewPlaylistExport(&req.Playlist, files, isrcs)
+	name := export.Title
+	if name == "" {
+		name = "playlist"
+	}
+	playlistPath := filepath.Join(outputDir, backend.SanitizeFilename(name)+ext)
+
+	if err := export.Write(playlistPath, format); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, PlaylistExportResponse{
+		Success:    true,
+		File:       playlistPath,
+		Format:     format,
+		Tracks:     len(export.Tracks),
+		Downloaded: export.Downloaded(),
+	})
+}
+
+// batchFiles collects the files and ISRCs of a batch's finished download
+// jobs, keyed by Spotify track ID
+func (s *Server) batchFiles(batchID string, files, isrcs map[string]string) error {
+	jobs, err := s.jobQueue.ListJobs("", batchID)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.SpotifyID == "" {
+			continue
+		}
+		if (job.Status == backend.JobCompleted || job.Status == backend.JobSkipped) && job.FilePath != "" {
+			files[job.SpotifyID] = job.FilePath
+		}
+		var req DownloadRequest
+		if err := json.Unmarshal(job.Payload, &req); err == nil && req.ISRC != "" && isrcs[job.SpotifyID] == "" {
+			isrcs[job.SpotifyID] = req.ISRC
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"spotiflac/backend"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// applyBatchReplayGain writes album ReplayGain tags once all jobs of a batch
+// have finished. Only albums whose tracks were all downloaded in the batch
+// are tagged, so a partial album does not get a wrong album gain
+func (s *Server) applyBatchReplayGain(batchID string) {
+	settings, err := backend.LoadSettings()
+	if err != nil || !backend.SettingBool(settings, "replayGain", false) {
+		return
+	}
+
+	jobs, err := s.jobQueue.ListJobs("", batchID)
+	if err != nil {
+		return
+	}
+	if len(jobs) == 0 {
+		s.gainBatches.Delete(batchID)
+		return
+	}
+	for _, job := range jobs {
+		if !job.IsFinished() {
+			return
+		}
+	}
+	// Several jobs can finish at the same moment; tag each set of finished
+	// files once. A batch whose failed jobs were run again and now has more
+	// files is tagged again
+	key := gainBatchKey(jobs)
+	if previous, loaded := s.gainBatches.Swap(batchID, key); loaded && previous == key {
+		return
+	}
+
+	type album struct {
+		name  string
+		total int
+		paths []string
+	}
+	albums := map[string]*album{}
+	var order []string
+	for _, job := range jobs {
+		if (job.Status != backend.JobCompleted && job.Status != backend.JobSkipped) || job.FilePath == "" {
+			continue
+		}
+		var req DownloadRequest
+		if err := json.Unmarshal(job.Payload, &req); err != nil || req.AlbumName == "" {
+			continue
+		}
+		key := req.AlbumArtist + "\x00" + req.AlbumName
+		a, ok := albums[key]
+		if !ok {
+			a = &album{name: req.AlbumName}
+			albums[key] = a
+			order = append(order, key)
+		}
+		a.total = max(a.total, req.SpotifyTotalTracks)
+		a.paths = append(a.paths, job.FilePath)
+	}
+
+	for _, key := range order {
+		a := albums[key]
+		if len(a.paths) < 2 || len(a.paths) < a.total {
+			continue
+		}
+		results, loudness := backend.ApplyAlbumReplayGain(context.Background(), a.paths)
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Printf("⚠ Failed to write album ReplayGain to %s: %s\n", result.Path, result.Error)
+			}
+		}
+		if loudness != nil {
+			fmt.Printf("✓ Album ReplayGain for %s: %.1f LUFS (%d tracks)\n", a.name, loudness.Integrated, len(a.paths))
+		}
+	}
+}
+
+// gainBatchKey identifies the downloaded files of a finished batch
+func gainBatchKey(jobs []backend.Job) string {
+	var files []string
+	for _, job := range jobs {
+		if (job.Status == backend.JobCompleted || job.Status == backend.JobSkipped) && job.FilePath != "" {
+			files = append(files, job.ID+"\x00"+job.FilePath)
+		}
+	}
+	sort.Strings(files)
+	return strings.Join(files, "\n")
+}
+
+// forgetClearedGainBatches drops the album gain state of batches whose jobs
+// have all been cleared from the queue
+func (s *Server) forgetClearedGainBatches() {
+	s.gainBatches.Range(func(key, _ interface{}) bool {
+		if jobs, err := s.jobQueue.ListJobs("", key.(string)); err == nil && len(jobs) == 0 {
+			s.gainBatches.Delete(key)
+		}
+		return true
+	})
+}
+
+// HandleReplayGain measures files and writes their ReplayGain tags. With
+// album set the files are treated as one album and also get album gain
+func (s *Server) HandleReplayGain(c echo.Context) error {
+	var req ReplayGainRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if len(req.FilePaths) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "file_paths is required"})
+	}
+	for _, path := range req.FilePaths {
+		if !s.inDownloadPath(path) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("File is outside the download path: %s", path)})
+		}
+	}
+
+	var resp ReplayGainResponse
+	if req.Album {
+		resp.Files, resp.Album = backend.ApplyAlbumReplayGain(c.Request().Context(), req.FilePaths)
+	} else {
+		for _, path := range req.FilePaths {
+			result := backend.AlbumReplayGainResult{Path: path}
+			loudness, rg, err := backend.ApplyTrackReplayGain(c.Request().Context(), path)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Loudness, result.ReplayGain = loudness, rg
+			}
+			resp.Files = append(resp.Files, result)
+		}
+	}
+
+	for _, f := range resp.Files {
+		if f.Error == "" {
+			resp.Tagged++
+		} else {
+			resp.Failed++
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
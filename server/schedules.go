@@ -0,0 +1,435 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"spotiflac/backend"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Schedule actions
+const (
+	actionQueuePause        = "queue.pause"
+	actionQueueResume       = "queue.resume"
+	actionQueueStart        = "queue.start"
+	actionSyncSubscriptions = "subscriptions.sync"
+	actionCheckWatchlist    = "watchlist.check"
+	actionClearJobs         = "jobs.clear"
+	actionCleanParts        = "parts.clean"
+)
+
+// errScheduleRunning is returned when a schedule is started while its
+// previous run is still in progress
+var errScheduleRunning = errors.New("schedule is already running")
+
+var scheduleActions = map[string]bool{
+	actionQueuePause:        true,
+	actionQueueResume:       true,
+	actionQueueStart:        true,
+	actionSyncSubscriptions: true,
+	actionCheckWatchlist:    true,
+	actionClearJobs:         true,
+	actionCleanParts:        true,
+}
+
+// stalePartAge is how long a partial download may sit untouched before the
+// parts.clean action removes it
+const stalePartAge = 7 * 24 * time.Hour
+
+// StartSchedules loads schedules.json from the data directory and runs
+// schedules whose cron expression fired since the previous minute. It must be
+// called before StartJobs so the queue starts paused or held outside the
+// download window.
+func (s *Server) StartSchedules() error {
+	store, err := backend.NewScheduleStore(filepath.Join(s.dataDir, "schedules.json"))
+	if err != nil {
+		return err
+	}
+	s.schedules = store
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				s.runDueSchedules(last, now)
+				last = now
+			}
+		}
+	}()
+	return nil
+}
+
+// runDueSchedules starts every enabled schedule that fired in (since, now]
+// and lets the queue pick up jobs when the download window has opened
+func (s *Server) runDueSchedules(since, now time.Time) {
+	for _, sched := range s.schedules.List() {
+		if !sched.Enabled {
+			continue
+		}
+		cron, err := backend.ParseCron(sched.Cron)
+		if err != nil {
+			continue
+		}
+		if next := cron.Next(since); !next.IsZero() && !next.After(now) {
+			go func(id, name string) {
+				if _, err := s.runSchedule(id); errors.Is(err, errScheduleRunning) {
+					fmt.Printf("⚠ Skipping schedule %s: the previous run has not finished\n", name)
+				}
+			}(sched.ID, sched.Name)
+		}
+	}
+
+	if s.jobQueue == nil {
+		return
+	}
+	if s.windowOverride.Load() && s.jobQueue.Pending() == 0 {
+		s.windowOverride.Store(false)
+	}
+	s.jobQueue.Wake()
+}
+
+// downloadAllowed reports whether queued jobs may start now. queue.start
+// lifts the download window until the queue is empty.
+func (s *Server) downloadAllowed() bool {
+	if s.schedules == nil || s.windowOverride.Load() {
+		return true
+	}
+	window := s.schedules.Window()
+	return window == nil || window.Contains(time.Now())
+}
+
+// setQueuePaused pauses or resumes the queue and remembers the state across
+// restarts
+func (s *Server) setQueuePaused(paused bool) error {
+	if paused {
+		s.jobQueue.Pause()
+	} else {
+		s.jobQueue.Resume()
+	}
+	return s.schedules.SetQueuePaused(paused)
+}
+
+// runSchedule runs a schedule's action and records the outcome. It returns
+// nil when the schedule does not exist and errScheduleRunning while the
+// previous run of the schedule is still in progress
+func (s *Server) runSchedule(id string) (*backend.Schedule, error) {
+	sched := s.schedules.Get(id)
+	if sched == nil {
+		return nil, nil
+	}
+	if _, running := s.scheduleRuns.LoadOrStore(id, true); running {
+		return nil, errScheduleRunning
+	}
+	defer s.scheduleRuns.Delete(id)
+
+	err := s.runScheduleAction(sched)
+	sched.LastRunAt = time.Now().Unix()
+	sched.LastError = ""
+	if err != nil {
+		sched.LastError = err.Error()
+		fmt.Printf("✗ Schedule %s (%s) failed: %v\n", sched.Name, sched.Action, err)
+	} else {
+		fmt.Printf("✓ Ran schedule %s (%s)\n", sched.Name, sched.Action)
+	}
+
+	// The schedule may have been edited while the action ran; only the
+	// run fields are written back
+	if current := s.schedules.Get(id); current != nil {
+		current.LastRunAt = sched.LastRunAt
+		current.LastError = sched.LastError
+		if err := s.schedules.Save(current); err != nil {
+			fmt.Printf("✗ Failed to save schedule %s: %v\n", id, err)
+		}
+	}
+
+	s.sseBroker.BroadcastJSON(map[string]interface{}{
+		"type":     "schedule:run",
+		"schedule": sched,
+	})
+	return sched, nil
+}
+
+func (s *Server) runScheduleAction(sched *backend.Schedule) error {
+	switch sched.Action {
+	case actionQueuePause:
+		return s.setQueuePaused(true)
+	case actionQueueResume:
+		return s.setQueuePaused(false)
+	case actionQueueStart:
+		s.windowOverride.Store(true)
+		return s.setQueuePaused(false)
+	case actionSyncSubscriptions:
+		return s.runScheduledSyncs(sched.Target)
+	case actionCheckWatchlist:
+		return s.runScheduledChecks(sched.Target)
+	case actionClearJobs:
+		if err := s.jobQueue.ClearFinishedJobs(); err != nil {
+			return err
+		}
+		s.forgetClearedGainBatches()
+		return nil
+	case actionCleanParts:
+		removed, err := backend.CleanStaleParts(s.downloadPath, stalePartAge)
+		if removed > 0 {
+			fmt.Printf("✓ Removed %d stale partial downloads\n", removed)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown schedule action: %s", sched.Action)
+	}
+}
+
+// runScheduledSyncs syncs one subscription, or every enabled one when target
+// is empty
+func (s *Server) runScheduledSyncs(target string) error {
+	ids := []string{target}
+	if target == "" {
+		subs, err := s.subscriptions.List()
+		if err != nil {
+			return err
+		}
+		ids = nil
+		for _, sub := range subs {
+			if sub.Enabled {
+				ids = append(ids, sub.ID)
+			}
+		}
+	}
+
+	failed := 0
+	for _, id := range ids {
+		run, err := s.syncSubscription(id)
+		if errors.Is(err, errSubscriptionSyncing) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if run == nil {
+			return fmt.Errorf("subscription not found: %s", id)
+		}
+		if run.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d syncs failed", failed, len(ids))
+	}
+	return nil
+}
+
+// runScheduledChecks checks one watched artist, or every enabled one when
+// target is empty
+func (s *Server) runScheduledChecks(target string) error {
+	ids := []string{target}
+	if target == "" {
+		artists, err := s.watchlist.List()
+		if err != nil {
+			return err
+		}
+		ids = nil
+		for _, artist := range artists {
+			if artist.Enabled {
+				ids = append(ids, artist.ID)
+			}
+		}
+	}
+
+	failed := 0
+	for _, id := range ids {
+		resp := s.checkWatchedArtist(id)
+		if resp == nil {
+			return fmt.Errorf("watched artist not found: %s", id)
+		}
+		if resp.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(ids))
+	}
+	return nil
+}
+
+// queueState describes whether the download queue is currently starting jobs
+func (s *Server) queueState() QueueStateResponse {
+	window := s.schedules.Window()
+	return QueueStateResponse{
+		Paused:     s.jobQueue.Paused(),
+		Window:     window,
+		WindowOpen: window == nil || window.Contains(time.Now()),
+		Override:   s.windowOverride.Load(),
+		Pending:    s.jobQueue.Pending(),
+	}
+}
+
+// HandleListSchedules lists schedules with their next run time
+func (s *Server) HandleListSchedules(c echo.Context) error {
+	schedules := s.schedules.List()
+	resp := make([]ScheduleResponse, 0, len(schedules))
+	for _, sched := range schedules {
+		resp = append(resp, newScheduleResponse(sched))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// newScheduleResponse adds the next run time to a schedule
+func newScheduleResponse(sched backend.Schedule) ScheduleResponse {
+	resp := ScheduleResponse{Schedule: sched}
+	if cron, err := backend.ParseCron(sched.Cron); err == nil && sched.Enabled {
+		if next := cron.Next(time.Now()); !next.IsZero() {
+			resp.NextRunAt = next.Unix()
+		}
+	}
+	return resp
+}
+
+// HandleCreateSchedule adds a schedule
+func (s *Server) HandleCreateSchedule(c echo.Context) error {
+	var req ScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.Cron == "" || req.Action == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "cron and action are required"})
+	}
+
+	sched := &backend.Schedule{Enabled: true}
+	if err := applyScheduleRequest(sched, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := s.schedules.Add(sched); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, newScheduleResponse(*sched))
+}
+
+// HandleUpdateSchedule changes a schedule
+func (s *Server) HandleUpdateSchedule(c echo.Context) error {
+	var req ScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	sched := s.schedules.Get(c.Param("id"))
+	if sched == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Schedule not found"})
+	}
+	if err := applyScheduleRequest(sched, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := s.schedules.Save(sched); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, newScheduleResponse(*sched))
+}
+
+// applyScheduleRequest validates the options set in req and copies them to
+// sched
+func applyScheduleRequest(sched *backend.Schedule, req ScheduleRequest) error {
+	if req.Cron != "" {
+		cron, err := backend.ParseCron(req.Cron)
+		if err != nil {
+			return err
+		}
+		if cron.Next(time.Now()).IsZero() {
+			return fmt.Errorf("cron expression never fires: %s", req.Cron)
+		}
+		sched.Cron = req.Cron
+	}
+	if req.Action != "" {
+		if !scheduleActions[req.Action] {
+			return fmt.Errorf("unknown schedule action: %s", req.Action)
+		}
+		sched.Action = req.Action
+	}
+	if req.Name != "" {
+		sched.Name = req.Name
+	}
+	if req.Target != nil {
+		sched.Target = *req.Target
+	}
+	if req.Enabled != nil {
+		sched.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// HandleDeleteSchedule removes a schedule
+func (s *Server) HandleDeleteSchedule(c echo.Context) error {
+	found, err := s.schedules.Delete(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Schedule not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleRunSchedule runs a schedule's action immediately
+func (s *Server) HandleRunSchedule(c echo.Context) error {
+	sched, err := s.runSchedule(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if sched == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Schedule not found"})
+	}
+	return c.JSON(http.StatusOK, newScheduleResponse(*sched))
+}
+
+// HandleGetQueueState returns the queue's paused state and download window
+func (s *Server) HandleGetQueueState(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.queueState())
+}
+
+// HandleSetDownloadWindow sets the hours in which queued jobs may start. An
+// empty start and end removes the window.
+func (s *Server) HandleSetDownloadWindow(c echo.Context) error {
+	var window backend.DownloadWindow
+	if err := c.Bind(&window); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var err error
+	if window.Start == "" && window.End == "" {
+		err = s.schedules.SetWindow(nil)
+	} else {
+		if err := window.Validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		err = s.schedules.SetWindow(&window)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	s.jobQueue.Wake()
+	return c.JSON(http.StatusOK, s.queueState())
+}
+
+// HandlePauseQueue stops the queue from starting new jobs
+func (s *Server) HandlePauseQueue(c echo.Context) error {
+	if err := s.setQueuePaused(true); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, s.queueState())
+}
+
+// HandleResumeQueue lets the queue start jobs again
+func (s *Server) HandleResumeQueue(c echo.Context) error {
+	if err := s.setQueuePaused(false); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, s.queueState())
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"spotiflac/backend"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HandleSpectrogram renders the spectrogram of a file as PNG. Images are
+// cached in the data directory by file hash and options
+func (s *Server) HandleSpectrogram(c echo.Context) error {
+	filePath := c.QueryParam("file")
+	if filePath == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "file is required"})
+	}
+	if !s.inDownloadPath(filePath) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "File must be inside the download path"})
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("File not found: %s", filePath)})
+	}
+
+	opts := backend.SpectrogramOptions{
+		Colormap: c.QueryParam("colormap"),
+		Scale:    c.QueryParam("scale"),
+		Channel:  c.QueryParam("channel"),
+	}
+	for name, value := range map[string]*int{"width": &opts.Width, "height": &opts.Height} {
+		if v := c.QueryParam(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid %s", name)})
+			}
+			*value = n
+		}
+	}
+	if err := opts.Normalize(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	image, err := backend.SpectrogramPNG(c.Request().Context(), filePath, filepath.Join(s.dataDir, "spectrograms"), opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	// The image name holds the file hash and options, so it doubles as ETag
+	c.Response().Header().Set("ETag", strconv.Quote(strings.TrimSuffix(filepath.Base(image), ".png")))
+	return c.File(image)
+}
@@ -0,0 +1,618 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"spotiflac/backend"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSubscriptionInterval = 24 * 60
+	minSubscriptionInterval     = 15
+	subscriptionSyncTimeout     = 10 * time.Minute
+	subscriptionArchiveDir      = "Archive"
+)
+
+// StartSubscriptions opens the subscription store and checks every minute
+// for playlists that are due for a sync
+func (s *Server) StartSubscriptions() {
+	s.subscriptions = backend.NewSubscriptionStore("SpotiFLAC")
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			s.syncDueSubscriptions()
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Server) syncDueSubscriptions() {
+	subs, err := s.subscriptions.List()
+	if err != nil {
+		fmt.Printf("✗ Failed to load subscriptions: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, sub := range subs {
+		if sub.Due(now) {
+			s.syncSubscription(sub.ID)
+		}
+	}
+}
+
+// subscriptionBatchPrefix prefixes the batch IDs of a subscription's jobs
+func subscriptionBatchPrefix(subID string) string {
+	return "sub-" + subID + "-"
+}
+
+// subscriptionFromBatch returns the subscription a batch was created for
+func subscriptionFromBatch(batchID string) (string, bool) {
+	if !strings.HasPrefix(batchID, "sub-") {
+		return "", false
+	}
+	idx := strings.LastIndex(batchID, "-")
+	if idx <= len("sub-") {
+		return "", false
+	}
+	return batchID[len("sub-"):idx], true
+}
+
+// errSubscriptionSyncing is returned when a subscription is synced while its
+// previous sync is still in progress
+var errSubscriptionSyncing = errors.New("subscription is already syncing")
+
+// syncSubscription re-fetches a playlist, enqueues new tracks, archives
+// removed ones if requested and rewrites the playlist file. Every run is
+// recorded, including failed ones. It returns nil when the subscription no
+// longer exists and errSubscriptionSyncing while it is already syncing
+func (s *Server) syncSubscription(id string) (*backend.SyncRun, error) {
+	run, err := s.startSubscriptionSync(id)
+	if run == nil || err != nil {
+		return nil, err
+	}
+	s.finishSubscriptionSync(run)
+	return run, nil
+}
+
+// startSubscriptionSync records the start of a sync run. It returns nil when
+// the subscription does not exist; otherwise finishSubscriptionSync must be
+// called with the run
+func (s *Server) startSubscriptionSync(id string) (*backend.SyncRun, error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	sub, err := s.subscriptions.Get(id)
+	if err != nil || sub == nil {
+		return nil, err
+	}
+	if _, running := s.syncRuns.LoadOrStore(id, true); running {
+		return nil, errSubscriptionSyncing
+	}
+
+	run := &backend.SyncRun{SubscriptionID: id, StartedAt: time.Now().Unix()}
+	if err := s.subscriptions.AddRun(run); err != nil {
+		s.syncRuns.Delete(id)
+		return nil, err
+	}
+	return run, nil
+}
+
+// finishSubscriptionSync runs a sync started by startSubscriptionSync. The
+// playlist is fetched without holding syncMu, so other subscription requests
+// are not blocked while Spotify answers
+func (s *Server) finishSubscriptionSync(run *backend.SyncRun) {
+	defer s.syncRuns.Delete(run.SubscriptionID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), subscriptionSyncTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	col, tracks, err := s.fetchSubscriptionPlaylist(ctx, run.SubscriptionID)
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	// The subscription may have been edited or deleted during the fetch
+	sub, getErr := s.subscriptions.Get(run.SubscriptionID)
+	if getErr != nil || sub == nil {
+		return
+	}
+	if err == nil {
+		err = s.applySubscriptionSync(sub, col, tracks, run)
+	}
+	run.FinishedAt = time.Now().Unix()
+
+	sub.LastSyncAt = run.FinishedAt
+	sub.LastError = ""
+	if err != nil {
+		run.Error = err.Error()
+		sub.LastError = err.Error()
+		fmt.Printf("✗ Sync of %s failed: %v\n", sub.URL, err)
+	} else {
+		fmt.Printf("✓ Synced %s: %d added, %d removed, %d retried\n", sub.Name, run.Added, run.Removed, run.Retried)
+	}
+
+	if err := s.subscriptions.Save(sub); err != nil {
+		fmt.Printf("✗ Failed to save subscription %s: %v\n", sub.ID, err)
+	}
+	if err := s.subscriptions.SaveRun(run); err != nil {
+		fmt.Printf("✗ Failed to record sync run for %s: %v\n", sub.ID, err)
+	}
+
+	s.sseBroker.BroadcastJSON(map[string]interface{}{
+		"type":            "subscription:sync",
+		"subscription_id": sub.ID,
+		"run":             run,
+	})
+}
+
+// fetchSubscriptionPlaylist fetches a subscribed playlist and the download
+// requests of its tracks
+func (s *Server) fetchSubscriptionPlaylist(ctx context.Context, id string) (*collection, []DownloadRequest, error) {
+	sub, err := s.subscriptions.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sub == nil {
+		return nil, nil, fmt.Errorf("subscription not found")
+	}
+	settings, err := backend.LoadSettings()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := backend.NewSpotifyMetadataClient().GetFilteredData(ctx, sub.URL, false, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	col, err := collectionFromMetadata(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if col.Type != "playlist" {
+		return nil, nil, fmt.Errorf("%s is not a playlist", sub.URL)
+	}
+
+	// The playlist file is written here rather than per batch, so every
+	// track is listed and not only the newly added ones
+	createM3U8 := true
+	tracks := s.collectionTracks(CollectionDownloadRequest{
+		URL:         sub.URL,
+		Service:     sub.Service,
+		AudioFormat: sub.AudioFormat,
+		CreateM3U8:  &createM3U8,
+	}, col, settings)
+	return col, tracks, nil
+}
+
+// applySubscriptionSync compares a fetched playlist with the snapshot of sub,
+// enqueues and archives tracks and updates the snapshot. syncMu must be held
+func (s *Server) applySubscriptionSync(sub *backend.Subscription, col *collection, tracks []DownloadRequest, run *backend.SyncRun) error {
+	sub.Name = col.Name
+	sub.Owner = col.PlaylistOwner
+	if len(tracks) > 0 && s.inDownloadPath(tracks[0].PlaylistDir) {
+		sub.PlaylistDir = tracks[0].PlaylistDir
+	}
+
+	files := s.subscriptionFiles(sub)
+	current := make([]backend.SubscriptionTrack, 0, len(col.Tracks))
+	for _, track := range col.Tracks {
+		if track.SpotifyID == "" {
+			continue
+		}
+		current = append(current, backend.SubscriptionTrack{
+			SpotifyID:  track.SpotifyID,
+			Name:       track.Name,
+			Artists:    track.Artists,
+			FilePath:   files[track.SpotifyID].path,
+			Downloaded: files[track.SpotifyID].downloaded,
+		})
+	}
+
+	added, removed := backend.DiffSnapshot(sub.Snapshot, current)
+	run.Added = len(added)
+	run.Removed = len(removed)
+
+	// Tracks of earlier syncs whose jobs failed or were cancelled have no
+	// file yet and are queued again, unless a job for them is still pending
+	pending := s.subscriptionPending(sub)
+	isNew := make(map[string]bool, len(added))
+	var wanted []backend.SubscriptionTrack
+	for _, track := range added {
+		isNew[track.SpotifyID] = true
+		if !pending[track.SpotifyID] {
+			wanted = append(wanted, track)
+		}
+	}
+	for _, track := range current {
+		if track.FilePath == "" && !isNew[track.SpotifyID] && !pending[track.SpotifyID] {
+			wanted = append(wanted, track)
+			run.Retried++
+		}
+	}
+
+	if len(wanted) > 0 {
+		batchID, err := s.enqueueSubscriptionTracks(sub, tracks, wanted)
+		if err != nil {
+			return err
+		}
+		run.BatchID = batchID
+	}
+
+	if sub.ArchiveRemoved && len(removed) > 0 {
+		referenced := s.referencedSubscriptionFiles(sub.ID, current)
+		for _, track := range removed {
+			archived, err := s.archiveSubscriptionTrack(sub, track, referenced)
+			if err != nil {
+				fmt.Printf("⚠ Failed to archive %s: %v\n", track.FilePath, err)
+				continue
+			}
+			if archived {
+				run.Archived++
+			}
+		}
+	}
+
+	sub.Snapshot = current
+	s.writeSubscriptionPlaylist(sub)
+	return nil
+}
+
+// enqueueSubscriptionTracks enqueues the download requests of the given tracks
+// as one batch
+func (s *Server) enqueueSubscriptionTracks(sub *backend.Subscription, tracks []DownloadRequest, queue []backend.SubscriptionTrack) (string, error) {
+	wanted := make(map[string]bool, len(queue))
+	for _, track := range queue {
+		wanted[track.SpotifyID] = true
+	}
+
+	batchID := fmt.Sprintf("%s%d", subscriptionBatchPrefix(sub.ID), time.Now().UnixNano())
+	var jobs []backend.Job
+	for _, track := range tracks {
+		if !wanted[track.SpotifyID] {
+			continue
+		}
+		job, err := s.newDownloadJob(track, batchID)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", track.TrackName, err)
+		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		return "", nil
+	}
+
+	if _, err := s.jobQueue.Enqueue(jobs); err != nil {
+		return "", err
+	}
+	return batchID, nil
+}
+
+// subscriptionFile is a track's file and whether the subscription itself
+// downloaded it
+type subscriptionFile struct {
+	path       string
+	downloaded bool
+}
+
+// subscriptionFiles maps Spotify IDs to their files, from the previous
+// snapshot and the finished jobs of the subscription's batches. Skipped jobs
+// found a file that was already on disk, which the subscription does not own
+func (s *Server) subscriptionFiles(sub *backend.Subscription) map[string]subscriptionFile {
+	files := map[string]subscriptionFile{}
+	for _, track := range sub.Snapshot {
+		if track.FilePath != "" {
+			files[track.SpotifyID] = subscriptionFile{path: track.FilePath, downloaded: track.Downloaded}
+		}
+	}
+
+	jobs, err := s.jobQueue.ListJobs("", "")
+	if err != nil {
+		return files
+	}
+	prefix := subscriptionBatchPrefix(sub.ID)
+	for _, job := range jobs {
+		if !strings.HasPrefix(job.BatchID, prefix) || job.SpotifyID == "" || job.FilePath == "" {
+			continue
+		}
+		switch job.Status {
+		case backend.JobCompleted:
+			files[job.SpotifyID] = subscriptionFile{path: job.FilePath, downloaded: true}
+		case backend.JobSkipped:
+			if _, ok := files[job.SpotifyID]; !ok {
+				files[job.SpotifyID] = subscriptionFile{path: job.FilePath}
+			}
+		}
+	}
+	return files
+}
+
+// subscriptionPending returns the Spotify IDs of tracks whose subscription
+// jobs are still queued or running
+func (s *Server) subscriptionPending(sub *backend.Subscription) map[string]bool {
+	pending := map[string]bool{}
+	jobs, err := s.jobQueue.ListJobs("", "")
+	if err != nil {
+		return pending
+	}
+	prefix := subscriptionBatchPrefix(sub.ID)
+	for _, job := range jobs {
+		if strings.HasPrefix(job.BatchID, prefix) && job.SpotifyID != "" && !job.IsFinished() {
+			pending[job.SpotifyID] = true
+		}
+	}
+	return pending
+}
+
+// referencedSubscriptionFiles returns the files listed in the snapshots of
+// the other subscriptions and in the given snapshot
+func (s *Server) referencedSubscriptionFiles(subID string, snapshot []backend.SubscriptionTrack) map[string]bool {
+	referenced := map[string]bool{}
+	for _, track := range snapshot {
+		if track.FilePath != "" {
+			referenced[filepath.Clean(track.FilePath)] = true
+		}
+	}
+	subs, err := s.subscriptions.List()
+	if err != nil {
+		return referenced
+	}
+	for _, other := range subs {
+		if other.ID == subID {
+			continue
+		}
+		for _, track := range other.Snapshot {
+			if track.FilePath != "" {
+				referenced[filepath.Clean(track.FilePath)] = true
+			}
+		}
+	}
+	return referenced
+}
+
+// archiveSubscriptionTrack moves a removed track's file to
+// <download path>/Archive/<playlist>. Only files the subscription downloaded
+// itself and no other playlist lists are moved, and existing archive files
+// are never replaced. It reports whether the file was moved
+func (s *Server) archiveSubscriptionTrack(sub *backend.Subscription, track backend.SubscriptionTrack, referenced map[string]bool) (bool, error) {
+	if track.FilePath == "" || !track.Downloaded || !s.inDownloadPath(track.FilePath) {
+		return false, nil
+	}
+	if referenced[filepath.Clean(track.FilePath)] {
+		return false, nil
+	}
+	if _, err := os.Stat(track.FilePath); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	archiveDir := filepath.Join(s.downloadPath, subscriptionArchiveDir, backend.SanitizeFilename(sub.Name))
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return false, err
+	}
+	target, err := unusedArchivePath(archiveDir, filepath.Base(track.FilePath))
+	if err != nil {
+		return false, err
+	}
+	if err := os.Rename(track.FilePath, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// unusedArchivePath returns dir/name, or dir/"name (n).ext" with the lowest
+// n that does not exist yet
+func unusedArchivePath(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 1; n < 1000; n++ {
+		candidate := filepath.Join(dir, name)
+		if n > 1 {
+			candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+		}
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free name for %s in %s", name, dir)
+}
+
+// writeSubscriptionPlaylist rewrites the M3U8 of a subscription from its
+// snapshot, in playlist order
+func (s *Server) writeSubscriptionPlaylist(sub *backend.Subscription) {
+	if sub.PlaylistDir == "" || sub.Name == "" {
+		return
+	}
+
+	var paths []string
+	for _, track := range sub.Snapshot {
+		if track.FilePath != "" {
+			paths = append(paths, track.FilePath)
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	file, tracks, err := writePlaylist(sub.PlaylistDir, sub.Name, paths)
+	if err != nil {
+		fmt.Printf("✗ Failed to write playlist for %s: %v\n", sub.Name, err)
+		return
+	}
+	fmt.Printf("✓ Wrote playlist %s (%d tracks)\n", file, tracks)
+}
+
+// refreshSubscriptionPlaylist records the files of finished subscription
+// jobs in the snapshot and rewrites the playlist
+func (s *Server) refreshSubscriptionPlaylist(subID string) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if s.subscriptions == nil {
+		return
+	}
+	sub, err := s.subscriptions.Get(subID)
+	if err != nil || sub == nil {
+		return
+	}
+
+	files := s.subscriptionFiles(sub)
+	for i := range sub.Snapshot {
+		if file, ok := files[sub.Snapshot[i].SpotifyID]; ok {
+			sub.Snapshot[i].FilePath = file.path
+			sub.Snapshot[i].Downloaded = file.downloaded
+		}
+	}
+	if err := s.subscriptions.Save(sub); err != nil {
+		fmt.Printf("✗ Failed to save subscription %s: %v\n", sub.ID, err)
+		return
+	}
+	s.writeSubscriptionPlaylist(sub)
+}
+
+// HandleListSubscriptions lists playlist subscriptions
+func (s *Server) HandleListSubscriptions(c echo.Context) error {
+	subs, err := s.subscriptions.List()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, subs)
+}
+
+// HandleCreateSubscription subscribes to a Spotify playlist and runs the
+// first sync in the background
+func (s *Server) HandleCreateSubscription(c echo.Context) error {
+	var req SubscriptionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if !strings.Contains(req.URL, "playlist") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A Spotify playlist URL is required"})
+	}
+
+	sub := &backend.Subscription{
+		URL:             req.URL,
+		IntervalMinutes: defaultSubscriptionInterval,
+		Enabled:         true,
+	}
+	applySubscriptionRequest(sub, req)
+
+	if err := s.subscriptions.Add(sub); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if sub.Enabled {
+		go s.syncSubscription(sub.ID)
+	}
+	return c.JSON(http.StatusOK, sub)
+}
+
+// HandleUpdateSubscription changes the options of a subscription
+func (s *Server) HandleUpdateSubscription(c echo.Context) error {
+	var req SubscriptionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	sub, err := s.subscriptions.Get(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if sub == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Subscription not found"})
+	}
+
+	applySubscriptionRequest(sub, req)
+	if err := s.subscriptions.Save(sub); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, sub)
+}
+
+// applySubscriptionRequest copies the options set in req to sub
+func applySubscriptionRequest(sub *backend.Subscription, req SubscriptionRequest) {
+	if req.Service != "" {
+		sub.Service = req.Service
+	}
+	if req.AudioFormat != "" {
+		sub.AudioFormat = req.AudioFormat
+	}
+	if req.IntervalMinutes > 0 {
+		sub.IntervalMinutes = max(req.IntervalMinutes, minSubscriptionInterval)
+	}
+	if req.ArchiveRemoved != nil {
+		sub.ArchiveRemoved = *req.ArchiveRemoved
+	}
+	if req.Enabled != nil {
+		sub.Enabled = *req.Enabled
+	}
+}
+
+// HandleDeleteSubscription removes a subscription. Downloaded files are kept.
+func (s *Server) HandleDeleteSubscription(c echo.Context) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	found, err := s.subscriptions.Delete(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Subscription not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleSyncSubscription starts a sync of a subscription in the background
+// and returns the run. Its outcome is broadcast as a subscription:sync SSE
+// event and recorded under the run's ID in the subscription's runs
+func (s *Server) HandleSyncSubscription(c echo.Context) error {
+	run, err := s.startSubscriptionSync(c.Param("id"))
+	if errors.Is(err, errSubscriptionSyncing) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The subscription is already syncing"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if run == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Subscription not found"})
+	}
+
+	go s.finishSubscriptionSync(run)
+	return c.JSON(http.StatusAccepted, run)
+}
+
+// HandleListSubscriptionRuns returns the recent sync runs of a subscription
+func (s *Server) HandleListSubscriptionRuns(c echo.Context) error {
+	runs, err := s.subscriptions.ListRuns(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, runs)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"spotiflac/backend"
+
+	"github.com/labstack/echo/v4"
+)
+
+// templatePreviewSample is the track used when a preview request has none
+var templatePreviewSample = DownloadRequest{
+	TrackName:          "All The Stars",
+	ArtistName:         "Kendrick Lamar, SZA",
+	AlbumName:          "Black Panther The Album Music From And Inspired By",
+	AlbumArtist:        "Kendrick Lamar",
+	ReleaseDate:        "2018-02-09",
+	AudioFormat:        "LOSSLESS",
+	SpotifyTrackNumber: 14,
+	SpotifyDiscNumber:  1,
+	SpotifyTotalTracks: 14,
+	SpotifyTotalDiscs:  1,
+	Publisher:          "Top Dawg Entertainment",
+	ISRC:               "USUM71800058",
+}
+
+// HandlePreviewTemplate expands a filename or folder template against a sample
+// track so the settings page can show the result before saving
+func (s *Server) HandlePreviewTemplate(c echo.Context) error {
+	var req TemplatePreviewRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	track := templatePreviewSample
+	if req.Track != nil {
+		track = *req.Track
+	}
+	data := track.templateData()
+
+	switch req.Kind {
+	case "", "filename":
+		name, err := backend.RenderFilename(req.Template, data, req.IncludeTrackNumber)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if name == "" {
+			name = "Unknown"
+		}
+		return c.JSON(http.StatusOK, TemplatePreviewResponse{Kind: "filename", Result: name + ".flac"})
+	case "folder":
+		path, err := backend.BuildFolderPath(req.Template, data)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, TemplatePreviewResponse{Kind: "folder", Result: filepath.ToSlash(path)})
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "kind must be filename or folder"})
+	}
+}
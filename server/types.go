@@ -1,5 +1,7 @@
 package server
 
+import "spotiflac/backend"
+
 // SpotifyMetadataRequest represents a request to fetch Spotify metadata
 type SpotifyMetadataRequest struct {
 	URL     string  `json:"url"`
@@ -20,6 +22,7 @@ type DownloadRequest struct {
 	CoverURL             string `json:"cover_url,omitempty"`
 	ApiURL               string `json:"api_url,omitempty"`
 	OutputDir            string `json:"output_dir,omitempty"`
+	FolderTemplate       string `json:"folder_template,omitempty"`
 	AudioFormat          string `json:"audio_format,omitempty"`
 	FilenameFormat       string `json:"filename_format,omitempty"`
 	TrackNumber          bool   `json:"track_number,omitempty"`
@@ -41,16 +44,75 @@ type DownloadRequest struct {
 	PlaylistOwner        string `json:"playlist_owner,omitempty"`
 	AllowFallback        bool   `json:"allow_fallback"`
 	UseFirstArtistOnly   bool   `json:"use_first_artist_only,omitempty"`
+	FallbackChain        string `json:"fallback_chain,omitempty"`
+	FallbackProfile      string `json:"fallback_profile,omitempty"`
+	ISRC                 string `json:"isrc,omitempty"`
+	Explicit             bool   `json:"explicit,omitempty"`
+	PlaylistDir          string `json:"playlist_dir,omitempty"`
 }
 
 // DownloadResponse represents the response from a download request
 type DownloadResponse struct {
-	Success       bool   `json:"success"`
-	Message       string `json:"message"`
-	File          string `json:"file,omitempty"`
-	Error         string `json:"error,omitempty"`
-	AlreadyExists bool   `json:"already_exists,omitempty"`
-	ItemID        string `json:"item_id,omitempty"`
+	Success       bool                    `json:"success"`
+	Message       string                  `json:"message"`
+	File          string                  `json:"file,omitempty"`
+	Error         string                  `json:"error,omitempty"`
+	AlreadyExists bool                    `json:"already_exists,omitempty"`
+	Provider      string                  `json:"provider,omitempty"`
+	Quality       string                  `json:"quality,omitempty"`
+	Match         *backend.TrackMatch     `json:"match,omitempty"`
+	Verification  *backend.VerifyResult   `json:"verification,omitempty"`
+	Verdict       *backend.QualityVerdict `json:"verdict,omitempty"`
+	ReplayGain    *backend.ReplayGain     `json:"replaygain,omitempty"`
+	ItemID        string                  `json:"item_id,omitempty"`
+}
+
+// CollectionDownloadRequest represents a request to download every track of a
+// Spotify album, playlist or artist discography. Empty fields fall back to the
+// saved settings.
+type CollectionDownloadRequest struct {
+	URL                  string  `json:"url"`
+	Service              string  `json:"service,omitempty"`
+	AudioFormat          string  `json:"audio_format,omitempty"`
+	FallbackChain        string  `json:"fallback_chain,omitempty"`
+	FallbackProfile      string  `json:"fallback_profile,omitempty"`
+	OutputDir            string  `json:"output_dir,omitempty"`
+	FolderTemplate       *string `json:"folder_template,omitempty"`
+	FilenameFormat       string  `json:"filename_format,omitempty"`
+	TrackNumber          *bool   `json:"track_number,omitempty"`
+	EmbedMaxQualityCover *bool   `json:"embed_max_quality_cover,omitempty"`
+	UseFirstArtistOnly   *bool   `json:"use_first_artist_only,omitempty"`
+	CreateM3U8           *bool   `json:"create_m3u8,omitempty"`
+	Timeout              int     `json:"timeout,omitempty"`
+}
+
+// CollectionDownloadResponse represents the jobs created for a collection
+type CollectionDownloadResponse struct {
+	Type    string        `json:"type"`
+	Name    string        `json:"name"`
+	BatchID string        `json:"batch_id"`
+	Jobs    []backend.Job `json:"jobs"`
+}
+
+// TemplatePreviewRequest asks for a filename or folder template to be
+// expanded. Track overrides the built-in sample track.
+type TemplatePreviewRequest struct {
+	Template           string           `json:"template"`
+	Kind               string           `json:"kind,omitempty"`
+	IncludeTrackNumber bool             `json:"include_track_number,omitempty"`
+	Track              *DownloadRequest `json:"track,omitempty"`
+}
+
+// TemplatePreviewResponse holds an expanded template
+type TemplatePreviewResponse struct {
+	Kind   string `json:"kind"`
+	Result string `json:"result"`
+}
+
+// ProviderInfo describes a registered download provider
+type ProviderInfo struct {
+	Name      string   `json:"name"`
+	Qualities []string `json:"qualities"`
 }
 
 // LyricsDownloadRequest represents a lyrics download request
@@ -155,6 +217,45 @@ type M3U8Request struct {
 	FilePaths []string `json:"file_paths"`
 }
 
+// M3U8Response represents a written M3U8 playlist
+type M3U8Response struct {
+	Success bool   `json:"success"`
+	File    string `json:"file"`
+	Tracks  int    `json:"tracks"`
+}
+
+// PlaylistExportRequest asks for a downloaded playlist to be exported.
+// FilePaths and ISRCs are keyed by Spotify track ID; with BatchID they are
+// filled in from the batch's download jobs.
+type PlaylistExportRequest struct {
+	Format    string                          `json:"format"`
+	Playlist  backend.PlaylistResponsePayload `json:"playlist"`
+	FilePaths map[string]string               `json:"file_paths,omitempty"`
+	ISRCs     map[string]string               `json:"isrcs,omitempty"`
+	BatchID   string                          `json:"batch_id,omitempty"`
+	OutputDir string                          `json:"output_dir,omitempty"`
+}
+
+// PlaylistExportResponse represents a written playlist export
+type PlaylistExportResponse struct {
+	Success    bool   `json:"success"`
+	File       string `json:"file"`
+	Format     string `json:"format"`
+	Tracks     int    `json:"tracks"`
+	Downloaded int    `json:"downloaded"`
+}
+
+// SubscriptionRequest creates or updates a playlist subscription. Omitted
+// fields keep their current value on update.
+type SubscriptionRequest struct {
+	URL             string `json:"url"`
+	Service         string `json:"service,omitempty"`
+	AudioFormat     string `json:"audio_format,omitempty"`
+	IntervalMinutes int    `json:"interval_minutes,omitempty"`
+	ArchiveRemoved  *bool  `json:"archive_removed,omitempty"`
+	Enabled         *bool  `json:"enabled,omitempty"`
+}
+
 // HealthResponse represents a health check response
 type HealthResponse struct {
 	Status string `json:"status"`
@@ -164,3 +265,138 @@ type HealthResponse struct {
 type DownloadPathResponse struct {
 	Path string `json:"path"`
 }
+
+// JobsRequest represents a request to enqueue one or more download jobs
+type JobsRequest struct {
+	Tracks []DownloadRequest `json:"tracks"`
+}
+
+// WatchlistRequest adds or updates a watched artist. Groups takes album,
+// single and compilation. Omitted fields keep their current value on update.
+type WatchlistRequest struct {
+	URL             string   `json:"url"`
+	Groups          []string `json:"groups,omitempty"`
+	Service         string   `json:"service,omitempty"`
+	AudioFormat     string   `json:"audio_format,omitempty"`
+	FallbackProfile string   `json:"fallback_profile,omitempty"`
+	IntervalMinutes int      `json:"interval_minutes,omitempty"`
+	Enabled         *bool    `json:"enabled,omitempty"`
+}
+
+// NewRelease is a release found by a watchlist check and the batch its
+// tracks were enqueued in
+type NewRelease struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Group       string `json:"group"`
+	ReleaseDate string `json:"release_date"`
+	Artist      string `json:"artist"`
+	URL         string `json:"url"`
+	Image       string `json:"image,omitempty"`
+	Tracks      int    `json:"tracks"`
+	BatchID     string `json:"batch_id"`
+}
+
+// WatchlistCheckResponse is the outcome of checking a watched artist
+type WatchlistCheckResponse struct {
+	WatchID  string       `json:"watch_id"`
+	Releases []NewRelease `json:"releases"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// ScheduleRequest creates or updates a schedule. Omitted fields keep their
+// current value on update.
+type ScheduleRequest struct {
+	Name    string  `json:"name,omitempty"`
+	Cron    string  `json:"cron,omitempty"`
+	Action  string  `json:"action,omitempty"`
+	Target  *string `json:"target,omitempty"`
+	Enabled *bool   `json:"enabled,omitempty"`
+}
+
+// ScheduleResponse is a schedule with the time it fires next
+type ScheduleResponse struct {
+	backend.Schedule
+	NextRunAt int64 `json:"next_run_at,omitempty"`
+}
+
+// QueueStateResponse reports whether the download queue is starting jobs
+type QueueStateResponse struct {
+	Paused     bool                    `json:"paused"`
+	Window     *backend.DownloadWindow `json:"download_window"`
+	WindowOpen bool                    `json:"window_open"`
+	Override   bool                    `json:"window_override"`
+	Pending    int                     `json:"pending"`
+}
+
+// AvailabilityBatchRequest checks the tracks of an album, playlist or
+// artist URL, or an explicit list of tracks
+type AvailabilityBatchRequest struct {
+	URL     string                      `json:"url,omitempty"`
+	Tracks  []backend.AvailabilityCheck `json:"tracks,omitempty"`
+	Timeout int                         `json:"timeout,omitempty"`
+}
+
+// AvailabilityBatchResponse reports the availability of every track and the
+// service that covers the batch best
+type AvailabilityBatchResponse struct {
+	Type    string                             `json:"type,omitempty"`
+	Name    string                             `json:"name,omitempty"`
+	Tracks  []*backend.TrackAvailability       `json:"tracks"`
+	Summary map[string]*backend.ServiceSummary `json:"summary"`
+	Best    string                             `json:"best,omitempty"`
+}
+
+// AvailabilityBatchStatus is the progress of a batch availability check.
+// Response is set once every track has been checked
+type AvailabilityBatchStatus struct {
+	ID       string                     `json:"id"`
+	Done     int                        `json:"done"`
+	Total    int                        `json:"total"`
+	Finished bool                       `json:"finished"`
+	Response *AvailabilityBatchResponse `json:"response,omitempty"`
+}
+
+// VerifyRequest selects files to verify again, by history item or path.
+// All verifies every file in the download history
+type VerifyRequest struct {
+	HistoryIDs []string `json:"history_ids,omitempty"`
+	FilePaths  []string `json:"file_paths,omitempty"`
+	All        bool     `json:"all,omitempty"`
+}
+
+// VerifiedFile is the verification result of one file
+type VerifiedFile struct {
+	HistoryID string `json:"history_id,omitempty"`
+	Path      string `json:"path"`
+	*backend.VerifyResult
+}
+
+// VerifyResponse lists the verified files and counts them by status
+type VerifyResponse struct {
+	Files   []VerifiedFile `json:"files"`
+	OK      int            `json:"ok"`
+	Failed  int            `json:"failed"`
+	Skipped int            `json:"skipped"`
+}
+
+// ReplayGainRequest selects files to tag with ReplayGain. Album treats them
+// as one album and adds album gain
+type ReplayGainRequest struct {
+	FilePaths []string `json:"file_paths"`
+	Album     bool     `json:"album,omitempty"`
+}
+
+// ReplayGainResponse lists the tagged files and, for albums, the album loudness
+type ReplayGainResponse struct {
+	Files  []backend.AlbumReplayGainResult `json:"files"`
+	Album  *backend.Loudness               `json:"album,omitempty"`
+	Tagged int                             `json:"tagged"`
+	Failed int                             `json:"failed"`
+}
+
+// JobsResponse represents the jobs created by a jobs request
+type JobsResponse struct {
+	BatchID string        `json:"batch_id,omitempty"`
+	Jobs    []backend.Job `json:"jobs"`
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"spotiflac/backend"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// verifyWorkers is how many files are decoded at once
+const verifyWorkers = 2
+
+// HandleVerify decodes downloaded files again and checks their integrity.
+// Results for history items are stored on the item
+func (s *Server) HandleVerify(c echo.Context) error {
+	var req VerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if !req.All && len(req.HistoryIDs) == 0 && len(req.FilePaths) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "history_ids, file_paths or all is required"})
+	}
+
+	var items []backend.HistoryItem
+	if req.All || len(req.HistoryIDs) > 0 {
+		history, err := backend.GetHistoryItems("SpotiFLAC")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		wanted := map[string]bool{}
+		for _, id := range req.HistoryIDs {
+			wanted[id] = true
+		}
+		for _, item := range history {
+			if req.All || wanted[item.ID] {
+				items = append(items, item)
+				delete(wanted, item.ID)
+			}
+		}
+		for _, id := range req.HistoryIDs {
+			if wanted[id] {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("History item not found: %s", id)})
+			}
+		}
+	}
+
+	for _, path := range req.FilePaths {
+		if !s.inDownloadPath(path) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("File is outside the download path: %s", path)})
+		}
+		items = append(items, backend.HistoryItem{Path: path})
+	}
+
+	files := make([]VerifiedFile, len(items))
+	forEachParallel(len(items), verifyWorkers, func(i int) {
+		files[i] = s.verifyItem(items[i])
+	})
+
+	resp := VerifyResponse{Files: files}
+	for _, f := range files {
+		switch f.Status {
+		case backend.VerifyOK:
+			resp.OK++
+		case backend.VerifyFailed:
+			resp.Failed++
+		default:
+			resp.Skipped++
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// verifyItem verifies the file of a history item against its expected
+// duration, and stores the result on the item
+func (s *Server) verifyItem(item backend.HistoryItem) VerifiedFile {
+	expected := item.ExpectedDuration()
+
+	var result *backend.VerifyResult
+	if _, err := os.Stat(item.Path); err != nil {
+		result = &backend.VerifyResult{
+			Status:           backend.VerifyFailed,
+			ExpectedDuration: expected,
+			Error:            "file not found",
+			VerifiedAt:       time.Now().Unix(),
+		}
+	} else {
+		result = backend.VerifyFile(item.Path, expected)
+	}
+	if result.Status == backend.VerifyFailed {
+		fmt.Printf("✗ Verification failed for %s: %s\n", item.Path, result.Error)
+	}
+
+	if item.ID != "" {
+		item.Verification = result
+		if err := backend.UpdateHistoryItem(item, "SpotiFLAC"); err != nil {
+			fmt.Printf("⚠ Failed to store verification of %s: %v\n", item.Path, err)
+		}
+	}
+
+	return VerifiedFile{HistoryID: item.ID, Path: item.Path, VerifyResult: result}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"spotiflac/backend"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultWatchInterval = 12 * 60
+	minWatchInterval     = 60
+	watchCheckTimeout    = 10 * time.Minute
+)
+
+// defaultReleaseGroups are followed when a watch does not name any groups
+var defaultReleaseGroups = []string{"album", "single"}
+
+// StartWatchlist opens the watchlist store and checks every minute for
+// artists that are due for a check
+func (s *Server) StartWatchlist() {
+	s.watchlist = backend.NewWatchlistStore("SpotiFLAC")
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			s.checkDueArtists()
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Server) checkDueArtists() {
+	artists, err := s.watchlist.List()
+	if err != nil {
+		fmt.Printf("✗ Failed to load watchlist: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, artist := range artists {
+		if artist.Due(now) {
+			s.checkWatchedArtist(artist.ID)
+		}
+	}
+}
+
+// checkWatchedArtist looks for releases of a watched artist that appeared
+// since the last check and enqueues each one as a batch. It returns nil when
+// the artist is no longer on the watchlist.
+func (s *Server) checkWatchedArtist(id string) *WatchlistCheckResponse {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	artist, err := s.watchlist.Get(id)
+	if err != nil || artist == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), watchCheckTimeout)
+	defer cancel()
+
+	startedAt := time.Now()
+	resp := &WatchlistCheckResponse{WatchID: artist.ID, Releases: []NewRelease{}}
+	err = s.runWatchlistCheck(ctx, artist, resp)
+
+	artist.LastCheckAt = time.Now().Unix()
+	artist.LastError = ""
+	if err != nil {
+		resp.Error = err.Error()
+		artist.LastError = err.Error()
+		fmt.Printf("✗ Watchlist check of %s failed: %v\n", artist.URL, err)
+	} else {
+		// Releases can show up a day after their release date, so the
+		// window overlaps the previous check; known IDs prevent duplicates
+		artist.Since = startedAt.AddDate(0, 0, -1).Format("2006-01-02")
+		fmt.Printf("✓ Checked %s: %d new releases\n", artist.Name, len(resp.Releases))
+	}
+
+	if err := s.watchlist.Save(artist); err != nil {
+		fmt.Printf("✗ Failed to save watched artist %s: %v\n", artist.ID, err)
+	}
+	return resp
+}
+
+// runWatchlistCheck enqueues the new releases of an artist. A release that
+// fails is left unknown so the next check retries it.
+func (s *Server) runWatchlistCheck(ctx context.Context, artist *backend.WatchedArtist, resp *WatchlistCheckResponse) error {
+	settings, err := backend.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	name, releases, err := backend.NewSpotifyMetadataClient().GetArtistReleases(ctx, artist.URL)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		artist.Name = name
+	}
+
+	var failed int
+	for _, release := range artist.NewReleases(releases) {
+		found, err := s.enqueueRelease(ctx, artist, release, settings)
+		if err != nil {
+			fmt.Printf("✗ Failed to enqueue %s: %v\n", release.Name, err)
+			failed++
+			continue
+		}
+		artist.MarkKnown([]backend.DiscographyAlbumMetadata{release})
+		resp.Releases = append(resp.Releases, *found)
+
+		s.sseBroker.BroadcastJSON(map[string]interface{}{
+			"type":     "new_release",
+			"watch_id": artist.ID,
+			"release":  found,
+		})
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d new releases could not be enqueued", failed)
+	}
+	return nil
+}
+
+// enqueueRelease fetches a release's tracks, enqueues them as one batch with
+// the watch's quality options and records the release in fetch history
+func (s *Server) enqueueRelease(ctx context.Context, artist *backend.WatchedArtist, release backend.DiscographyAlbumMetadata, settings map[string]interface{}) (*NewRelease, error) {
+	data, err := backend.NewSpotifyMetadataClient().GetFilteredData(ctx, release.ExternalURL, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	col, err := collectionFromMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(col.Tracks) == 0 {
+		return nil, fmt.Errorf("%s has no tracks", release.Name)
+	}
+
+	tracks := s.collectionTracks(CollectionDownloadRequest{
+		URL:             release.ExternalURL,
+		Service:         artist.Service,
+		AudioFormat:     artist.AudioFormat,
+		FallbackProfile: artist.FallbackProfile,
+	}, col, settings)
+
+	batchID := fmt.Sprintf("batch-%d", time.Now().UnixNano())
+	jobs := make([]backend.Job, 0, len(tracks))
+	for i := range tracks {
+		job, err := s.newDownloadJob(tracks[i], batchID)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", i+1, err)
+		}
+		jobs = append(jobs, job)
+	}
+	if _, err := s.jobQueue.Enqueue(jobs); err != nil {
+		return nil, err
+	}
+
+	item := backend.FetchHistoryItem{
+		URL:   release.ExternalURL,
+		Type:  "album",
+		Name:  release.Name,
+		Info:  fmt.Sprintf("New release by %s · %s", artist.Name, release.ReleaseDate),
+		Image: release.Images,
+	}
+	if buf, err := json.Marshal(data); err == nil {
+		item.Data = string(buf)
+	}
+	if err := backend.AddFetchHistoryItem(item, "SpotiFLAC"); err != nil {
+		fmt.Printf("⚠ Failed to add %s to fetch history: %v\n", release.Name, err)
+	}
+
+	return &NewRelease{
+		ID:          release.ID,
+		Name:        release.Name,
+		Group:       backend.ReleaseGroup(release.AlbumType),
+		ReleaseDate: release.ReleaseDate,
+		Artist:      artist.Name,
+		URL:         release.ExternalURL,
+		Image:       release.Images,
+		Tracks:      len(jobs),
+		BatchID:     batchID,
+	}, nil
+}
+
+// HandleListWatchlist lists watched artists
+func (s *Server) HandleListWatchlist(c echo.Context) error {
+	artists, err := s.watchlist.List()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, artists)
+}
+
+// HandleAddWatchedArtist adds an artist to the watchlist. The current
+// discography is recorded as known, so only later releases are downloaded.
+func (s *Server) HandleAddWatchedArtist(c echo.Context) error {
+	var req WatchlistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	artistID, artistURL, err := backend.ArtistURL(req.URL)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A Spotify artist URL is required"})
+	}
+
+	artist := &backend.WatchedArtist{
+		ArtistID:        artistID,
+		URL:             artistURL,
+		Groups:          defaultReleaseGroups,
+		IntervalMinutes: defaultWatchInterval,
+		Enabled:         true,
+	}
+	if err := applyWatchlistRequest(artist, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), watchCheckTimeout)
+	defer cancel()
+
+	name, releases, err := backend.NewSpotifyMetadataClient().GetArtistReleases(ctx, artist.URL)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	now := time.Now()
+	artist.Name = name
+	artist.MarkKnown(releases)
+	artist.Since = now.Format("2006-01-02")
+	artist.LastCheckAt = now.Unix()
+
+	if err := s.watchlist.Add(artist); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, artist)
+}
+
+// HandleUpdateWatchedArtist changes the options of a watched artist
+func (s *Server) HandleUpdateWatchedArtist(c echo.Context) error {
+	var req WatchlistRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	artist, err := s.watchlist.Get(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if artist == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Watched artist not found"})
+	}
+
+	if err := applyWatchlistRequest(artist, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := s.watchlist.Save(artist); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, artist)
+}
+
+// applyWatchlistRequest validates the options set in req and copies them to
+// artist
+func applyWatchlistRequest(artist *backend.WatchedArtist, req WatchlistRequest) error {
+	if len(req.Groups) > 0 {
+		for _, group := range req.Groups {
+			if backend.ReleaseGroup(group) != group {
+				return fmt.Errorf("unknown release group: %s", group)
+			}
+		}
+		artist.Groups = req.Groups
+	}
+	if req.FallbackProfile != "" {
+		if _, err := backend.GetFallbackProfile(req.FallbackProfile); err != nil {
+			return err
+		}
+		artist.FallbackProfile = req.FallbackProfile
+	}
+	if req.Service != "" {
+		artist.Service = req.Service
+	}
+	if req.AudioFormat != "" {
+		artist.AudioFormat = req.AudioFormat
+	}
+	if req.IntervalMinutes > 0 {
+		artist.IntervalMinutes = max(req.IntervalMinutes, minWatchInterval)
+	}
+	if req.Enabled != nil {
+		artist.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// HandleDeleteWatchedArtist removes an artist from the watchlist
+func (s *Server) HandleDeleteWatchedArtist(c echo.Context) error {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	found, err := s.watchlist.Delete(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Watched artist not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleCheckWatchedArtist checks a watched artist immediately
+func (s *Server) HandleCheckWatchedArtist(c echo.Context) error {
+	resp := s.checkWatchedArtist(c.Param("id"))
+	if resp == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Watched artist not found"})
+	}
+	return c.JSON(http.StatusOK, resp)
+}